@@ -0,0 +1,911 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"isy-api/audit"
+	"isy-api/auth"
+	"isy-api/response"
+	"isy-api/tenant"
+	"isy-api/validate"
+)
+
+var (
+	errInvalidUserID   = response.New(http.StatusBadRequest, response.CodeInvalidID, "Invalid user ID")
+	errInvalidAPIKeyID = response.New(http.StatusBadRequest, response.CodeInvalidID, "Invalid API key ID")
+)
+
+// CreateUserRequest represents the payload for creating an admin
+type CreateUserRequest struct {
+	Username    string   `json:"username"`
+	Email       string   `json:"email"`
+	Password    string   `json:"password"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	// Tenant binds the new admin to a shop or clinic. Admins that are bound
+	// to a tenant themselves can only create admins for that tenant.
+	Tenant string `json:"tenant"`
+	// MustChangePassword defaults to true: the creator picks a temporary
+	// password and the new admin replaces it on first login
+	MustChangePassword *bool `json:"mustChangePassword"`
+}
+
+// UpdateUserRequest represents the payload for updating an admin. Only the
+// fields present in the JSON are changed.
+type UpdateUserRequest struct {
+	Email       *string   `json:"email"`
+	Role        *string   `json:"role"`
+	Permissions *[]string `json:"permissions"`
+	IsActive    *bool     `json:"isActive"`
+}
+
+// ResetPasswordRequest represents an administrator setting another admin's password
+type ResetPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// ChangePasswordRequest represents an admin changing their own password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// MFACodeRequest carries a TOTP code confirming an MFA settings change
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// AdminHandlers contains the admin user management handlers
+type AdminHandlers struct {
+	DB      *mongo.Database
+	Auth    *auth.Service
+	Audit   *audit.Logger
+	Tenants *tenant.Registry
+}
+
+// NewAdminHandlers creates a new admin handlers instance
+func NewAdminHandlers(db *mongo.Database, authService *auth.Service, auditLogger *audit.Logger, tenants *tenant.Registry) *AdminHandlers {
+	return &AdminHandlers{DB: db, Auth: authService, Audit: auditLogger, Tenants: tenants}
+}
+
+// GetUsers lists all admins the caller may manage
+func (ah *AdminHandlers) GetUsers(w http.ResponseWriter, r *http.Request) {
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	ctx := r.Context()
+	collection := ah.DB.Collection("admins")
+
+	filter := bson.M{}
+	if t := callerTenant(r); t != "" {
+		filter["tenant"] = t
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to fetch users")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	users := []auth.Admin{}
+	if err := cursor.All(ctx, &users); err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to parse users")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, users)
+}
+
+// GetUser retrieves a single admin by ID
+func (ah *AdminHandlers) GetUser(w http.ResponseWriter, r *http.Request) {
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidUserID)
+		return
+	}
+
+	var user auth.Admin
+	err = ah.DB.Collection("admins").FindOne(r.Context(), userFilter(r, objID)).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			response.Fail(w, r, http.StatusNotFound, "User not found")
+			return
+		}
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, user)
+}
+
+// CreateUser creates a new admin
+func (ah *AdminHandlers) CreateUser(w http.ResponseWriter, r *http.Request) {
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	var req CreateUserRequest
+	if err := validate.DecodeInto(r, createUserSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		response.Fail(w, r, http.StatusBadRequest, "Username is required")
+		return
+	}
+	if err := auth.ValidatePassword(req.Password, req.Username); err != nil {
+		respondWithPasswordError(w, r, err)
+		return
+	}
+
+	tenantID, ok := ah.assignTenant(w, r, req.Tenant)
+	if !ok {
+		return
+	}
+	if !ah.authorizeGrant(w, r, req.Role, req.Permissions) {
+		return
+	}
+
+	ctx := r.Context()
+	collection := ah.DB.Collection("admins")
+
+	count, err := collection.CountDocuments(ctx, bson.M{"username": req.Username})
+	if err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+	if count > 0 {
+		response.Fail(w, r, http.StatusConflict, "Username already exists")
+		return
+	}
+
+	hashed, err := auth.HashPassword(req.Password)
+	if err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	mustChange := true
+	if req.MustChangePassword != nil {
+		mustChange = *req.MustChangePassword
+	}
+	if req.Permissions == nil {
+		req.Permissions = []string{}
+	}
+
+	active := true
+	now := time.Now()
+	user := auth.Admin{
+		Username:           req.Username,
+		Password:           hashed,
+		Email:              req.Email,
+		Role:               req.Role,
+		Permissions:        req.Permissions,
+		IsActive:           &active,
+		Tenant:             tenantID,
+		MustChangePassword: mustChange,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+
+	result, err := collection.InsertOne(ctx, user)
+	if err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+
+	ah.Audit.RecordRequest(r, "admin.user.create", "admins", user.ID.Hex(), map[string]interface{}{
+		"username":    user.Username,
+		"role":        user.Role,
+		"permissions": user.Permissions,
+		"tenant":      user.Tenant,
+	})
+
+	response.JSON(w, r, http.StatusCreated, user)
+}
+
+// UpdateUser changes an admin's profile, role, permissions or active flag.
+// Deactivating an admin or changing their access revokes all of their
+// sessions, so no token keeps the old rights.
+func (ah *AdminHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidUserID)
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := validate.DecodeInto(r, updateUserSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	if req.IsActive != nil && !*req.IsActive && isCurrentUser(r, objID) {
+		response.Fail(w, r, http.StatusBadRequest, "You cannot deactivate your own account")
+		return
+	}
+	if _, ok := ah.findTarget(w, r, objID, "Failed to update user"); !ok {
+		return
+	}
+
+	var role string
+	var permissions []string
+	if req.Role != nil {
+		role = *req.Role
+	}
+	if req.Permissions != nil {
+		permissions = *req.Permissions
+	}
+	if !ah.authorizeGrant(w, r, role, permissions) {
+		return
+	}
+
+	updates := bson.M{"updatedAt": time.Now()}
+	changes := map[string]interface{}{}
+	if req.Email != nil {
+		updates["email"] = *req.Email
+		changes["email"] = *req.Email
+	}
+	if req.Role != nil {
+		updates["role"] = *req.Role
+		changes["role"] = *req.Role
+	}
+	if req.Permissions != nil {
+		updates["permissions"] = *req.Permissions
+		changes["permissions"] = *req.Permissions
+	}
+	if req.IsActive != nil {
+		updates["isActive"] = *req.IsActive
+		changes["isActive"] = *req.IsActive
+	}
+
+	ctx := r.Context()
+	result, err := ah.DB.Collection("admins").UpdateOne(ctx, userFilter(r, objID), bson.M{"$set": updates})
+	if err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+	if result.MatchedCount == 0 {
+		response.Fail(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	revokeReason := ""
+	switch {
+	case req.IsActive != nil && !*req.IsActive:
+		revokeReason = "admin deactivated"
+	case req.Role != nil || req.Permissions != nil:
+		revokeReason = "admin access changed"
+	}
+	if revokeReason != "" {
+		if err := ah.Auth.RevokeAdminSessions(ctx, objID, revokeReason); err != nil {
+			response.Fail(w, r, http.StatusInternalServerError, "User updated but sessions could not be revoked")
+			return
+		}
+	}
+
+	ah.Audit.RecordRequest(r, "admin.user.update", "admins", objID.Hex(), changes)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "User updated successfully"})
+}
+
+// DeleteUser removes an admin and revokes their sessions
+func (ah *AdminHandlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidUserID)
+		return
+	}
+
+	if isCurrentUser(r, objID) {
+		response.Fail(w, r, http.StatusBadRequest, "You cannot delete your own account")
+		return
+	}
+	if _, ok := ah.findTarget(w, r, objID, "Failed to delete user"); !ok {
+		return
+	}
+
+	ctx := r.Context()
+	result, err := ah.DB.Collection("admins").DeleteOne(ctx, userFilter(r, objID))
+	if err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+	if result.DeletedCount == 0 {
+		response.Fail(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := ah.Auth.RevokeAdminSessions(ctx, objID, "admin deleted"); err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "User deleted but sessions could not be revoked")
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "admin.user.delete", "admins", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "User deleted successfully"})
+}
+
+// ResetPassword sets a new temporary password for another admin. The admin
+// must change it at next login.
+func (ah *AdminHandlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidUserID)
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := validate.DecodeInto(r, resetPasswordSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	user, ok := ah.findTarget(w, r, objID, "Failed to reset password")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := ah.Auth.SetPassword(ctx, objID, user.Username, req.Password, true); err != nil {
+		respondWithPasswordError(w, r, err)
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "admin.user.password_reset", "admins", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "Password reset successfully"})
+}
+
+// UnlockUser clears the failed login counter of an admin so they can sign in
+// again before their lockout expires
+func (ah *AdminHandlers) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidUserID)
+		return
+	}
+
+	user, ok := ah.findTarget(w, r, objID, "Failed to unlock user")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := ah.Auth.Unlock(ctx, user.Username); err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to unlock user")
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "admin.user.unlock", "admins", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "User unlocked successfully"})
+}
+
+// GetLockouts lists the usernames and IPs currently locked out
+func (ah *AdminHandlers) GetLockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := ah.Auth.Lockouts(r.Context())
+	if err != nil {
+		if errors.Is(err, auth.ErrUnavailable) {
+			response.WriteError(w, r, response.ErrUnavailable)
+			return
+		}
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to fetch lockouts")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, lockouts)
+}
+
+// DeleteLockout clears a lockout by key, e.g. "ip:203.0.113.7"
+func (ah *AdminHandlers) DeleteLockout(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	found, err := ah.Auth.UnlockKey(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnavailable) {
+			response.WriteError(w, r, response.ErrUnavailable)
+			return
+		}
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to clear lockout")
+		return
+	}
+	if !found {
+		response.Fail(w, r, http.StatusNotFound, "Lockout not found")
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "admin.lockout.clear", "login_attempts", key, nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "Lockout cleared successfully"})
+}
+
+// GetCurrentUser returns the authenticated admin
+func (ah *AdminHandlers) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	r = mux.SetURLVars(r, map[string]string{"id": claims.Subject})
+	ah.GetUser(w, r)
+}
+
+// ChangeOwnPassword lets the authenticated admin replace their password. It
+// is reachable while the account is flagged with mustChangePassword and
+// returns a fresh token pair, since every other session is revoked.
+func (ah *AdminHandlers) ChangeOwnPassword(w http.ResponseWriter, r *http.Request) {
+	objID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := validate.DecodeInto(r, changePasswordSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	result, err := ah.Auth.ChangePassword(r.Context(), objID, req.CurrentPassword, req.NewPassword, auth.ClientInfoFromRequest(r))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			response.Fail(w, r, http.StatusUnauthorized, "Current password is incorrect")
+			return
+		}
+		if errors.Is(err, auth.ErrLocked) {
+			auth.RespondWithLoginError(w, r, err)
+			return
+		}
+		respondWithPasswordError(w, r, err)
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "auth.password_change", "admins", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, result)
+}
+
+// BeginMFAEnrollment starts TOTP enrollment for the authenticated admin and
+// returns the secret and otpauth:// URI to render as a QR code
+func (ah *AdminHandlers) BeginMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	objID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	enrollment, err := ah.Auth.BeginMFAEnrollment(r.Context(), objID)
+	if err != nil {
+		respondWithMFAError(w, r, err)
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, enrollment)
+}
+
+// ActivateMFA confirms enrollment with a code from the authenticator app and
+// returns the recovery codes, which are not shown again
+func (ah *AdminHandlers) ActivateMFA(w http.ResponseWriter, r *http.Request) {
+	objID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := validate.DecodeInto(r, mfaCodeSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	codes, err := ah.Auth.ActivateMFA(r.Context(), objID, req.Code)
+	if err != nil {
+		respondWithMFAError(w, r, err)
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "auth.mfa.enable", "admins", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"recoveryCodes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the authenticated admin's recovery codes
+func (ah *AdminHandlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	objID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := validate.DecodeInto(r, mfaCodeSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	codes, err := ah.Auth.RegenerateRecoveryCodes(r.Context(), objID, req.Code)
+	if err != nil {
+		respondWithMFAError(w, r, err)
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "auth.mfa.recovery_codes", "admins", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"recoveryCodes": codes})
+}
+
+// DisableMFA turns off MFA for the authenticated admin where policy allows it
+func (ah *AdminHandlers) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	objID, ok := currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := validate.DecodeInto(r, mfaCodeSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	if err := ah.Auth.DisableMFA(r.Context(), objID, req.Code); err != nil {
+		respondWithMFAError(w, r, err)
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "auth.mfa.disable", "admins", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "MFA disabled successfully"})
+}
+
+// ResetMFA removes another admin's MFA enrollment, e.g. after a lost phone
+func (ah *AdminHandlers) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidUserID)
+		return
+	}
+
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+	if _, ok := ah.findTarget(w, r, objID, "Failed to update MFA settings"); !ok {
+		return
+	}
+
+	if err := ah.Auth.ResetMFA(r.Context(), objID); err != nil {
+		respondWithMFAError(w, r, err)
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "admin.user.mfa_reset", "admins", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "MFA reset successfully"})
+}
+
+// GetAPIKeys lists all API keys the caller may manage
+func (ah *AdminHandlers) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := ah.Auth.ListAPIKeys(r.Context(), callerTenant(r))
+	if err != nil {
+		if errors.Is(err, auth.ErrUnavailable) {
+			response.WriteError(w, r, response.ErrUnavailable)
+			return
+		}
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to fetch API keys")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, keys)
+}
+
+// CreateAPIKey creates a key for a machine client. The plain key is only
+// included in this response.
+func (ah *AdminHandlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if ah.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	var req auth.NewAPIKey
+	if err := validate.DecodeInto(r, apiKeySchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	tenantID, ok := ah.assignTenant(w, r, req.Tenant)
+	if !ok {
+		return
+	}
+	req.Tenant = tenantID
+
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	created, err := ah.Auth.CreateAPIKey(r.Context(), req, claims)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnavailable) {
+			response.WriteError(w, r, response.ErrUnavailable)
+			return
+		}
+		if errors.Is(err, auth.ErrInvalidAPIKeySpec) {
+			response.Fail(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, auth.ErrPermissionNotHeld) {
+			response.WriteError(w, r, response.New(http.StatusForbidden, auth.CodePermissionDenied, "You cannot grant permissions you do not hold").
+				WithDetails(map[string]interface{}{"missing": auth.Ungranted(claims.Permissions, req.Permissions)}))
+			return
+		}
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "admin.api_key.create", "api_keys", created.ID.Hex(), map[string]interface{}{
+		"name":        created.Name,
+		"module":      created.Module,
+		"permissions": created.Permissions,
+		"tenant":      created.Tenant,
+	})
+
+	response.JSON(w, r, http.StatusCreated, created)
+}
+
+// RevokeAPIKey disables a key
+func (ah *AdminHandlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidAPIKeyID)
+		return
+	}
+
+	if err := ah.Auth.RevokeAPIKey(r.Context(), objID, callerTenant(r)); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUnavailable):
+			response.WriteError(w, r, response.ErrUnavailable)
+		case errors.Is(err, mongo.ErrNoDocuments):
+			response.Fail(w, r, http.StatusNotFound, "API key not found")
+		default:
+			response.Fail(w, r, http.StatusInternalServerError, "Failed to revoke API key")
+		}
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "admin.api_key.revoke", "api_keys", objID.Hex(), nil)
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "API key revoked successfully"})
+}
+
+// GetTenants lists all tenants. Only platform admins, who are not bound to
+// a tenant themselves, can see the registry.
+func (ah *AdminHandlers) GetTenants(w http.ResponseWriter, r *http.Request) {
+	if callerTenant(r) != "" {
+		response.Fail(w, r, http.StatusForbidden, "Only platform admins can manage tenants")
+		return
+	}
+
+	tenants, err := ah.Tenants.List(r.Context())
+	if err != nil {
+		if errors.Is(err, tenant.ErrUnavailable) {
+			response.WriteError(w, r, response.ErrUnavailable)
+			return
+		}
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to fetch tenants")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, tenants)
+}
+
+// CreateTenant registers a shop or clinic and provisions its database
+func (ah *AdminHandlers) CreateTenant(w http.ResponseWriter, r *http.Request) {
+	if callerTenant(r) != "" {
+		response.Fail(w, r, http.StatusForbidden, "Only platform admins can manage tenants")
+		return
+	}
+
+	var req tenant.NewTenant
+	if err := validate.DecodeInto(r, tenantSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	created, err := ah.Tenants.Provision(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, tenant.ErrUnavailable):
+			response.WriteError(w, r, response.ErrUnavailable)
+		case errors.Is(err, tenant.ErrInvalidTenant):
+			response.Fail(w, r, http.StatusBadRequest, err.Error())
+		case mongo.IsDuplicateKeyError(err):
+			response.Fail(w, r, http.StatusConflict, "Tenant ID, database or host already in use")
+		default:
+			response.Fail(w, r, http.StatusInternalServerError, "Failed to provision tenant")
+		}
+		return
+	}
+
+	ah.Audit.RecordRequest(r, "admin.tenant.create", "tenants", created.ID, map[string]interface{}{
+		"name":     created.Name,
+		"database": created.Database,
+		"hosts":    created.Hosts,
+	})
+
+	response.JSON(w, r, http.StatusCreated, created)
+}
+
+// callerTenant returns the tenant the authenticated caller is bound to
+func callerTenant(r *http.Request) string {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	return claims.Tenant
+}
+
+// userFilter matches the admin with id, restricted to the caller's tenant
+// when the caller is bound to one
+func userFilter(r *http.Request, id primitive.ObjectID) bson.M {
+	filter := bson.M{"_id": id}
+	if t := callerTenant(r); t != "" {
+		filter["tenant"] = t
+	}
+	return filter
+}
+
+// assignTenant decides the tenant of a new admin or API key. Callers bound to
+// a tenant always create for their own tenant; platform admins may name any
+// registered tenant or none.
+func (ah *AdminHandlers) assignTenant(w http.ResponseWriter, r *http.Request, requested string) (string, bool) {
+	requested = strings.ToLower(strings.TrimSpace(requested))
+	if own := callerTenant(r); own != "" {
+		if requested != "" && requested != own {
+			response.Fail(w, r, http.StatusForbidden, "You can only manage your own tenant")
+			return "", false
+		}
+		return own, true
+	}
+	if requested == "" {
+		return "", true
+	}
+
+	if _, err := ah.Tenants.Lookup(r.Context(), requested); err != nil {
+		if errors.Is(err, tenant.ErrNotFound) {
+			response.Fail(w, r, http.StatusBadRequest, "Unknown tenant")
+			return "", false
+		}
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to look up tenant")
+		return "", false
+	}
+	return requested, true
+}
+
+// authorizeGrant checks that the caller holds every permission role and
+// permissions would grant, so no admin can hand out more than they have.
+// It reports whether the grant may go ahead; if not, the response is
+// written.
+func (ah *AdminHandlers) authorizeGrant(w http.ResponseWriter, r *http.Request, role string, permissions []string) bool {
+	if role == "" && len(permissions) == 0 {
+		return true
+	}
+
+	return ah.authorizeHeld(w, r, &auth.Admin{Role: role, Permissions: permissions}, "You cannot grant permissions you do not hold")
+}
+
+// findTarget loads the admin with id that the caller acts on and checks
+// that the caller holds every permission the admin has. Otherwise an admin
+// could reset the password of, deactivate or delete one with more access
+// than their own. It reports whether the action may go ahead; if not, the
+// response is written.
+func (ah *AdminHandlers) findTarget(w http.ResponseWriter, r *http.Request, id primitive.ObjectID, failure string) (auth.Admin, bool) {
+	var target auth.Admin
+	if err := ah.DB.Collection("admins").FindOne(r.Context(), userFilter(r, id)).Decode(&target); err != nil {
+		if err == mongo.ErrNoDocuments {
+			response.Fail(w, r, http.StatusNotFound, "User not found")
+			return auth.Admin{}, false
+		}
+		response.Fail(w, r, http.StatusInternalServerError, failure)
+		return auth.Admin{}, false
+	}
+	if !ah.authorizeHeld(w, r, &target, "You cannot manage an admin with permissions you do not hold") {
+		return auth.Admin{}, false
+	}
+	return target, true
+}
+
+// authorizeHeld checks that the caller holds every effective permission of
+// admin, answering 403 with message and the missing permissions if not
+func (ah *AdminHandlers) authorizeHeld(w http.ResponseWriter, r *http.Request, admin *auth.Admin, message string) bool {
+	permissions, err := ah.Auth.LoadPermissions(r.Context(), admin)
+	if err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to resolve permissions")
+		return false
+	}
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	var held []string
+	if claims != nil {
+		held = claims.Permissions
+	}
+	if missing := auth.Ungranted(held, permissions); len(missing) > 0 {
+		response.WriteError(w, r, response.New(http.StatusForbidden, auth.CodePermissionDenied, message).
+			WithDetails(map[string]interface{}{"missing": missing}))
+		return false
+	}
+	return true
+}
+
+func currentUserID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	objID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		response.WriteError(w, r, response.New(http.StatusUnauthorized, response.CodeInvalidToken, "Invalid or expired token"))
+		return primitive.NilObjectID, false
+	}
+	return objID, true
+}
+
+func isCurrentUser(r *http.Request, id primitive.ObjectID) bool {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	return ok && claims.Subject == id.Hex()
+}
+
+// Helper functions
+func respondWithMFAError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidMFACode):
+		response.WriteError(w, r, response.New(http.StatusBadRequest, auth.CodeInvalidMFACode, "Invalid MFA code"))
+	case errors.Is(err, auth.ErrMFAAlreadyEnabled), errors.Is(err, auth.ErrMFANotEnabled), errors.Is(err, auth.ErrMFANotPending):
+		response.Fail(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, auth.ErrMFAEnforced):
+		response.Fail(w, r, http.StatusForbidden, "MFA is required for this account")
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, auth.ErrInvalidToken):
+		response.Fail(w, r, http.StatusNotFound, "User not found")
+	case errors.Is(err, auth.ErrUnavailable):
+		response.WriteError(w, r, response.ErrUnavailable)
+	default:
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to update MFA settings")
+	}
+}
+
+func respondWithPasswordError(w http.ResponseWriter, r *http.Request, err error) {
+	var passwordErr *auth.PasswordError
+	switch {
+	case errors.As(err, &passwordErr):
+		response.WriteError(w, r, response.New(http.StatusBadRequest, auth.CodeWeakPassword,
+			"Password does not meet strength requirements").WithDetails(passwordErr.Problems))
+	case errors.Is(err, mongo.ErrNoDocuments):
+		response.Fail(w, r, http.StatusNotFound, "User not found")
+	case errors.Is(err, auth.ErrUnavailable):
+		response.WriteError(w, r, response.ErrUnavailable)
+	default:
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to update password")
+	}
+}
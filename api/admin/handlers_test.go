@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/apitest"
+	"isy-api/audit"
+	"isy-api/auth"
+	"isy-api/config"
+	"isy-api/mongodb/mongotest"
+)
+
+// seedAdmin stores an admin with role and permissions and returns its ID
+func seedAdmin(t *testing.T, ah *AdminHandlers, username, role string, permissions ...string) primitive.ObjectID {
+	t.Helper()
+	if permissions == nil {
+		permissions = []string{}
+	}
+	result, err := ah.DB.Collection("admins").InsertOne(context.Background(), auth.Admin{
+		Username:    username,
+		Password:    "unchanged",
+		Role:        role,
+		Permissions: permissions,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.InsertedID.(primitive.ObjectID)
+}
+
+func TestActingOnAnotherAdmin(t *testing.T) {
+	db := mongotest.Database(t)
+	signer, err := auth.NewSigner("test", map[string][]byte{"test": []byte("test-secret")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ah := NewAdminHandlers(db, auth.NewService(db, signer, config.Auth{}), audit.NewLogger(db), nil)
+
+	superadmin := seedAdmin(t, ah, "root", "admin")
+	clerk := seedAdmin(t, ah, "clerk", "", "kiosk:orders:read")
+	// A lesser admin may manage users, but only those holding no more than
+	// they do
+	lesser := &auth.Claims{Username: "users", Permissions: []string{"admin:users:write", "kiosk:*"}}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		target  primitive.ObjectID
+		caller  *auth.Claims
+		want    int
+	}{
+		{"lesser admin resets a superadmin's password", ah.ResetPassword, "POST", `{"password":"Lantern-Orbit-4711"}`, superadmin, lesser, http.StatusForbidden},
+		{"lesser admin deletes a superadmin", ah.DeleteUser, "DELETE", "", superadmin, lesser, http.StatusForbidden},
+		{"lesser admin deactivates a superadmin", ah.UpdateUser, "PUT", `{"isActive":false}`, superadmin, lesser, http.StatusForbidden},
+		{"lesser admin demotes a superadmin", ah.UpdateUser, "PUT", `{"role":"clerk"}`, superadmin, lesser, http.StatusForbidden},
+		{"lesser admin unlocks a superadmin", ah.UnlockUser, "POST", "", superadmin, lesser, http.StatusForbidden},
+		{"lesser admin resets a superadmin's MFA", ah.ResetMFA, "DELETE", "", superadmin, lesser, http.StatusForbidden},
+		{"lesser admin resets a clerk's password", ah.ResetPassword, "POST", `{"password":"Lantern-Orbit-4711"}`, clerk, lesser, http.StatusOK},
+		{"superadmin resets a superadmin's password", ah.ResetPassword, "POST", `{"password":"Lantern-Orbit-4711"}`, superadmin, apitest.Admin, http.StatusOK},
+		{"lesser admin deletes a clerk", ah.DeleteUser, "DELETE", "", clerk, lesser, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := apitest.Request(tt.method, "/admin/users/"+tt.target.Hex(), tt.body)
+			r = apitest.WithVars(apitest.As(r, tt.caller), map[string]string{"id": tt.target.Hex()})
+			status, body := apitest.Serve(t, tt.handler, r)
+			if status != tt.want {
+				t.Fatalf("status = %d %s, want %d", status, body.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden && body.Code != string(auth.CodePermissionDenied) {
+				t.Errorf("code = %s, want %s", body.Code, auth.CodePermissionDenied)
+			}
+		})
+	}
+
+	// The refused requests changed nothing
+	var stored auth.Admin
+	if err := db.Collection("admins").FindOne(context.Background(), bson.M{"_id": superadmin}).Decode(&stored); err != nil {
+		t.Fatalf("superadmin after the refused requests: %v", err)
+	}
+	if stored.Role != "admin" || !stored.Active() {
+		t.Errorf("superadmin = role %q, active %v; want admin, active", stored.Role, stored.Active())
+	}
+}
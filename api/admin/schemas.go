@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"isy-api/auth"
+	"isy-api/validate"
+)
+
+// Shared field shapes. Password strength is checked by the auth service;
+// the length cap only keeps bcrypt input bounded.
+var (
+	password    = validate.Field{Type: validate.String, MaxLength: 128}
+	email       = validate.Field{Name: "email", Type: validate.String, MaxLength: 254, Format: validate.Email}
+	permissions = validate.Field{Name: "permissions", Type: validate.Array, MaxLength: 200, Items: &validate.Field{Type: validate.String, MinLength: 1, MaxLength: 100}}
+	tenantID    = validate.Field{Name: "tenant", Type: validate.String, MaxLength: 40}
+)
+
+// Bodies of the admin endpoints
+var (
+	createUserSchema = validate.Schema{Fields: []validate.Field{
+		{Name: "username", Type: validate.String, Required: true, MaxLength: 64},
+		email,
+		password.Named("password").Require(),
+		{Name: "role", Type: validate.String, MaxLength: 64},
+		permissions,
+		tenantID,
+		{Name: "mustChangePassword", Type: validate.Bool},
+	}}
+
+	updateUserSchema = validate.Schema{Fields: []validate.Field{
+		email,
+		{Name: "role", Type: validate.String, MaxLength: 64},
+		permissions,
+		{Name: "isActive", Type: validate.Bool},
+	}}
+
+	resetPasswordSchema = validate.Schema{Fields: []validate.Field{
+		password.Named("password").Require(),
+	}}
+
+	changePasswordSchema = validate.Schema{Fields: []validate.Field{
+		password.Named("currentPassword").Require(),
+		password.Named("newPassword").Require(),
+	}}
+
+	// TOTP codes are 6 digits; recovery codes are longer
+	mfaCodeSchema = validate.Schema{Fields: []validate.Field{
+		{Name: "code", Type: validate.String, Required: true, MaxLength: 32},
+	}}
+
+	apiKeySchema = validate.Schema{Fields: []validate.Field{
+		{Name: "name", Type: validate.String, Required: true, MaxLength: 100},
+		{Name: "module", Type: validate.String, Required: true, Enum: auth.APIKeyModules},
+		tenantID,
+		permissions.Require(),
+		{Name: "rateLimit", Type: validate.Integer, Min: validate.Limit(0)},
+		{Name: "expiresAt", Type: validate.Time},
+	}}
+
+	tenantSchema = validate.Schema{Fields: []validate.Field{
+		{Name: "id", Type: validate.String, Required: true, MaxLength: 40},
+		{Name: "name", Type: validate.String, Required: true, MaxLength: 100},
+		{Name: "database", Type: validate.String, MaxLength: 64},
+		{Name: "hosts", Type: validate.Array, MaxLength: 20, Items: &validate.Field{Type: validate.String, MinLength: 1, MaxLength: 253}},
+	}}
+)
@@ -0,0 +1,83 @@
+// Package apitest builds the requests handler tests send and decodes the
+// envelopes and problems the handlers answer with
+package apitest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"isy-api/auth"
+	"isy-api/tenant"
+)
+
+// Admin is a caller holding every permission, bound to no tenant
+var Admin = &auth.Claims{Username: "tester", Permissions: []string{auth.Wildcard}}
+
+// Request builds a JSON request with body
+func Request(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// As returns r made by the caller claims describes
+func As(r *http.Request, claims *auth.Claims) *http.Request {
+	return r.WithContext(auth.WithClaims(r.Context(), claims))
+}
+
+// ForTenant returns r resolved to tenantID. The shared database, which an
+// empty tenantID stands for, needs no resolving.
+func ForTenant(r *http.Request, tenantID string) *http.Request {
+	if tenantID == "" {
+		return r
+	}
+	return r.WithContext(tenant.WithTenant(r.Context(), &tenant.Tenant{ID: tenantID}, nil))
+}
+
+// WithVars returns r with vars as its route variables
+func WithVars(r *http.Request, vars map[string]string) *http.Request {
+	return mux.SetURLVars(r, vars)
+}
+
+// Body holds the parts of an envelope or problem tests look at
+type Body struct {
+	Data json.RawMessage `json:"data"`
+	Page *struct {
+		Total int64 `json:"total"`
+	} `json:"page"`
+	Code    string          `json:"code"`
+	Details json.RawMessage `json:"details"`
+}
+
+// Serve runs handler on r and returns the status and the body it wrote
+func Serve(t testing.TB, handler http.HandlerFunc, r *http.Request) (int, Body) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, r)
+	var body Body
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: decoding %q: %v", r.Method, r.URL, w.Body.String(), err)
+	}
+	return w.Code, body
+}
+
+// Decode unmarshals the data of b into v
+func (b Body) Decode(t testing.TB, v any) {
+	t.Helper()
+	if err := json.Unmarshal(b.Data, v); err != nil {
+		t.Fatalf("decoding %s: %v", b.Data, err)
+	}
+}
+
+// Count returns how many items the list in the data of b holds
+func (b Body) Count(t testing.TB) int {
+	t.Helper()
+	var items []json.RawMessage
+	b.Decode(t, &items)
+	return len(items)
+}
@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"isy-api/auth"
+)
+
+// Entry represents a record in the audit_logs collection
+type Entry struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id,omitempty"`
+	Action     string                 `bson:"action" json:"action"`
+	ActorID    string                 `bson:"actorId" json:"actorId"`
+	Actor      string                 `bson:"actor" json:"actor"`
+	Tenant     string                 `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Resource   string                 `bson:"resource" json:"resource"`
+	ResourceID string                 `bson:"resourceId" json:"resourceId"`
+	Details    map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	IP         string                 `bson:"ip" json:"ip"`
+	UserAgent  string                 `bson:"userAgent" json:"userAgent"`
+	CreatedAt  time.Time              `bson:"createdAt" json:"createdAt"`
+}
+
+// Logger writes audit entries
+type Logger struct {
+	DB *mongo.Database
+}
+
+// NewLogger creates a new audit logger
+func NewLogger(db *mongo.Database) *Logger {
+	return &Logger{DB: db}
+}
+
+// Record stores entry. Audit failures are logged but never fail the request
+// that triggered them.
+func (l *Logger) Record(ctx context.Context, entry Entry) {
+	if l.DB == nil {
+		log.Printf("Audit entry dropped, database not available: %s %s/%s", entry.Action, entry.Resource, entry.ResourceID)
+		return
+	}
+
+	entry.CreatedAt = time.Now()
+	if _, err := l.DB.Collection("audit_logs").InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry %s: %v", entry.Action, err)
+	}
+}
+
+// RecordRequest stores an entry attributed to the authenticated caller of r
+func (l *Logger) RecordRequest(r *http.Request, action, resource, resourceID string, details map[string]interface{}) {
+	entry := Entry{
+		Action:     action,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Details:    details,
+	}
+
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+		entry.ActorID = claims.Subject
+		entry.Actor = claims.Username
+		entry.Tenant = claims.Tenant
+	}
+
+	client := auth.ClientInfoFromRequest(r)
+	entry.IP = client.IP
+	entry.UserAgent = client.UserAgent
+
+	l.Record(r.Context(), entry)
+}
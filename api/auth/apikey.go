@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// apiKeyPrefix starts every key so leaked keys are easy to grep for
+	apiKeyPrefix = "isy"
+
+	// DefaultAPIKeyRateLimit applies to keys created without a rate limit
+	DefaultAPIKeyRateLimit = 120
+
+	// lastUsedResolution limits how often lastUsedAt is written per key
+	lastUsedResolution = time.Minute
+)
+
+var (
+	// ErrInvalidAPIKey is returned for unknown, malformed, revoked or expired keys
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrRateLimited is wrapped by *RateLimitError
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrInvalidAPIKeySpec is wrapped by CreateAPIKey validation errors
+	ErrInvalidAPIKeySpec = errors.New("invalid API key request")
+	// ErrPermissionNotHeld is wrapped when a key would get a permission its
+	// creator does not hold
+	ErrPermissionNotHeld = errors.New("permission not held by the creator")
+)
+
+// APIKeyModules lists the modules keys can be scoped to. Admin endpoints are
+// deliberately absent: keys are for unattended clients, not user management.
+var APIKeyModules = []string{"kiosk", "retail", "healthcare", "site"}
+
+// RateLimitError is returned when an API key has used up its request budget
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrRateLimited, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// APIKey represents an entry in the api_keys collection. Only a hash of the
+// secret is stored; Prefix is the public part used to find the key.
+type APIKey struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string             `bson:"name" json:"name"`
+	Prefix      string             `bson:"prefix" json:"prefix"`
+	KeyHash     string             `bson:"keyHash" json:"-"`
+	Module      string             `bson:"module" json:"module"`
+	Tenant      string             `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	RateLimit   int                `bson:"rateLimit" json:"rateLimit"` // requests per minute
+	CreatedBy   string             `bson:"createdBy" json:"createdBy"`
+	LastUsedAt  *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	LastUsedIP  string             `bson:"lastUsedIp,omitempty" json:"lastUsedIp,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	RevokedAt   *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// NewAPIKey describes a key to create
+type NewAPIKey struct {
+	Name        string     `json:"name"`
+	Module      string     `json:"module"`
+	Tenant      string     `json:"tenant"`
+	Permissions []string   `json:"permissions"`
+	RateLimit   int        `json:"rateLimit"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+// CreatedAPIKey is returned once on creation; Key is never shown again
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey generates and stores a new key on behalf of creator. Every
+// permission has to belong to the key's module so a kiosk terminal key can
+// never touch healthcare data, has to be held by the creator and must not
+// require MFA.
+func (s *Service) CreateAPIKey(ctx context.Context, req NewAPIKey, creator *Claims) (*CreatedAPIKey, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Module = strings.TrimSpace(req.Module)
+	if req.Name == "" || req.Module == "" {
+		return nil, fmt.Errorf("%w: name and module are required", ErrInvalidAPIKeySpec)
+	}
+	if !slices.Contains(APIKeyModules, req.Module) {
+		return nil, fmt.Errorf("%w: unknown module %q", ErrInvalidAPIKeySpec, req.Module)
+	}
+	if len(req.Permissions) == 0 {
+		return nil, fmt.Errorf("%w: at least one permission is required", ErrInvalidAPIKeySpec)
+	}
+	for _, permission := range req.Permissions {
+		if !strings.HasPrefix(permission, req.Module+":") {
+			return nil, fmt.Errorf("%w: permission %q is outside module %q", ErrInvalidAPIKeySpec, permission, req.Module)
+		}
+	}
+	// A key cannot answer a TOTP challenge, so it never gets what MFA guards
+	if s.MFA.Required(req.Permissions) {
+		return nil, fmt.Errorf("%w: permissions requiring MFA cannot be given to a key", ErrInvalidAPIKeySpec)
+	}
+	if missing := Ungranted(creator.Permissions, req.Permissions); len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPermissionNotHeld, strings.Join(missing, ", "))
+	}
+	if req.RateLimit < 0 {
+		return nil, fmt.Errorf("%w: rate limit must not be negative", ErrInvalidAPIKeySpec)
+	}
+	if req.RateLimit == 0 {
+		req.RateLimit = DefaultAPIKeyRateLimit
+	}
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	prefix, err := randomToken(6)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + "_" + prefix + "_" + secret
+
+	apiKey := APIKey{
+		Name:        req.Name,
+		Prefix:      prefix,
+		KeyHash:     hashToken(key),
+		Module:      req.Module,
+		Tenant:      req.Tenant,
+		Permissions: req.Permissions,
+		RateLimit:   req.RateLimit,
+		CreatedBy:   creator.Username,
+		ExpiresAt:   req.ExpiresAt,
+		CreatedAt:   time.Now(),
+	}
+
+	result, err := s.DB.Collection("api_keys").InsertOne(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	apiKey.ID = result.InsertedID.(primitive.ObjectID)
+
+	return &CreatedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+// ListAPIKeys returns the keys of tenant, or every key if tenant is empty,
+// newest first
+func (s *Service) ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	filter := bson.M{}
+	if tenant != "" {
+		filter["tenant"] = tenant
+	}
+	cursor, err := s.DB.Collection("api_keys").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey disables a key immediately. A non-empty tenant restricts the
+// lookup to that tenant's keys.
+func (s *Service) RevokeAPIKey(ctx context.Context, id primitive.ObjectID, tenant string) error {
+	if s.DB == nil {
+		return ErrUnavailable
+	}
+
+	filter := bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}}
+	if tenant != "" {
+		filter["tenant"] = tenant
+	}
+	result, err := s.DB.Collection("api_keys").UpdateOne(ctx, filter,
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// AuthenticateAPIKey resolves a presented key to claims carrying the key's
+// permissions, enforcing its rate limit and recording its last use
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key string, client ClientInfo) (*Claims, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var apiKey APIKey
+	err := s.DB.Collection("api_keys").FindOne(ctx, bson.M{"prefix": parts[1]}).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(key)), []byte(apiKey.KeyHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+	// Keys created before their permissions came to require MFA stop working
+	if s.MFA.Required(apiKey.Permissions) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	limit := apiKey.RateLimit
+	if limit <= 0 {
+		limit = DefaultAPIKeyRateLimit
+	}
+	if ok, retryAfter := s.apiKeyLimiter.allow(apiKey.ID.Hex(), limit, now); !ok {
+		return nil, &RateLimitError{RetryAfter: retryAfter}
+	}
+
+	// Only write lastUsedAt once per resolution window to keep hot keys cheap
+	_, err = s.DB.Collection("api_keys").UpdateOne(ctx,
+		bson.M{"_id": apiKey.ID, "$or": bson.A{
+			bson.M{"lastUsedAt": bson.M{"$exists": false}},
+			bson.M{"lastUsedAt": bson.M{"$lt": now.Add(-lastUsedResolution)}},
+		}},
+		bson.M{"$set": bson.M{"lastUsedAt": now, "lastUsedIp": client.IP}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{
+		Username:    "apikey:" + apiKey.Name,
+		Permissions: apiKey.Permissions,
+		Tenant:      apiKey.Tenant,
+		APIKeyID:    apiKey.ID.Hex(),
+	}
+	claims.Subject = apiKey.ID.Hex()
+	return claims, nil
+}
+
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	scheme, key, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "ApiKey") {
+		return "", false
+	}
+	key = strings.TrimSpace(key)
+	return key, key != ""
+}
+
+// rateLimiter is a per-key token bucket. Buckets live in process memory, so
+// with several replicas each one enforces the limit on its own share of the
+// traffic; that is good enough to stop a runaway terminal.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a token from key's bucket, which refills at perMinute tokens
+// per minute and holds at most perMinute tokens
+func (l *rateLimiter) allow(key string, perMinute int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(perMinute)
+	rate := capacity / time.Minute.Seconds()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > capacity {
+		bucket.tokens = capacity
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
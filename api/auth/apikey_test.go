@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCreateAPIKeyRefusals(t *testing.T) {
+	// Without a database every request that passes validation ends at the
+	// insert, so ErrUnavailable marks an accepted request
+	s := &Service{MFA: DefaultMFAPolicy}
+	clerk := &Claims{Username: "clerk", Permissions: []string{"kiosk:orders:*", "kiosk:products:read"}}
+
+	tests := []struct {
+		name    string
+		req     NewAPIKey
+		creator *Claims
+		want    error
+	}{
+		{"held permissions", NewAPIKey{Name: "till", Module: "kiosk", Permissions: []string{"kiosk:orders:write", "kiosk:products:read"}}, clerk, ErrUnavailable},
+		{"permission the creator lacks", NewAPIKey{Name: "till", Module: "kiosk", Permissions: []string{"kiosk:orders:write", "kiosk:products:write"}}, clerk, ErrPermissionNotHeld},
+		{"wildcard wider than the creator's", NewAPIKey{Name: "till", Module: "kiosk", Permissions: []string{"kiosk:*"}}, clerk, ErrPermissionNotHeld},
+		{"superadmin", NewAPIKey{Name: "till", Module: "kiosk", Permissions: []string{"kiosk:*"}}, &Claims{Permissions: []string{Wildcard}}, ErrUnavailable},
+		{"permission outside the module", NewAPIKey{Name: "till", Module: "kiosk", Permissions: []string{"retail:orders:read"}}, clerk, ErrInvalidAPIKeySpec},
+		{"admin module", NewAPIKey{Name: "till", Module: "admin", Permissions: []string{"admin:users:read"}}, clerk, ErrInvalidAPIKeySpec},
+		{"no permissions", NewAPIKey{Name: "till", Module: "kiosk"}, clerk, ErrInvalidAPIKeySpec},
+		{"no name", NewAPIKey{Name: " ", Module: "kiosk", Permissions: []string{"kiosk:orders:read"}}, clerk, ErrInvalidAPIKeySpec},
+		{"permission requiring MFA", NewAPIKey{Name: "lab", Module: "healthcare", Permissions: []string{"healthcare:medical-records:read"}}, &Claims{Permissions: []string{Wildcard}}, ErrInvalidAPIKeySpec},
+		{"wildcard covering a permission requiring MFA", NewAPIKey{Name: "lab", Module: "healthcare", Permissions: []string{"healthcare:*"}}, &Claims{Permissions: []string{Wildcard}}, ErrInvalidAPIKeySpec},
+		{"healthcare permission without MFA", NewAPIKey{Name: "lab", Module: "healthcare", Permissions: []string{"healthcare:appointments:read"}}, &Claims{Permissions: []string{Wildcard}}, ErrUnavailable},
+		{"negative rate limit", NewAPIKey{Name: "till", Module: "kiosk", Permissions: []string{"kiosk:orders:read"}, RateLimit: -1}, clerk, ErrInvalidAPIKeySpec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.CreateAPIKey(context.Background(), tt.req, tt.creator); !errors.Is(err, tt.want) {
+				t.Errorf("CreateAPIKey error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKeyRequiringMFA(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, "admin", "Lantern-Orbit-4711")
+	s.MFA = DefaultMFAPolicy
+	owner := &Claims{Username: "admin", Permissions: []string{Wildcard}}
+
+	created, err := s.CreateAPIKey(ctx, NewAPIKey{Name: "lab", Module: "healthcare", Permissions: []string{"healthcare:patients:read"}}, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateAPIKey(ctx, created.Key, ClientInfo{}); err != nil {
+		t.Fatalf("key without MFA permissions: %v", err)
+	}
+
+	// The key was granted medical records before they came to require MFA
+	_, err = s.DB.Collection("api_keys").UpdateByID(ctx, created.ID, bson.M{
+		"$set": bson.M{"permissions": []string{"healthcare:medical-records:read"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateAPIKey(ctx, created.Key, ClientInfo{}); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("key with a permission requiring MFA = %v, want %v", err, ErrInvalidAPIKey)
+	}
+}
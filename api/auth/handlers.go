@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/response"
+)
+
+// AuthRequest represents login credentials
+type AuthRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RefreshRequest carries a refresh token to rotate or revoke
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// LogoutRequest selects which sessions to end. With a refresh token only that
+// token's session is ended; with All set, every session of the bearer is.
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+	All          bool   `json:"all"`
+}
+
+// MFAVerifyRequest completes a login that returned mfaRequired
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// AuthHandlers exposes the shared /auth endpoints
+type AuthHandlers struct {
+	Service *Service
+}
+
+// NewAuthHandlers creates a new auth handlers instance
+func NewAuthHandlers(service *Service) *AuthHandlers {
+	return &AuthHandlers{Service: service}
+}
+
+// Login authenticates an admin and returns an access token
+func (ah *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
+	var authReq AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&authReq); err != nil {
+		response.WriteError(w, r, response.BodyError(err))
+		return
+	}
+
+	result, err := ah.Service.Login(r.Context(), authReq.Username, authReq.Password, ClientInfoFromRequest(r))
+	if err != nil {
+		RespondWithLoginError(w, r, err)
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, result)
+}
+
+// VerifyMFA exchanges an MFA challenge token and a TOTP or recovery code for tokens
+func (ah *AuthHandlers) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, r, response.BodyError(err))
+		return
+	}
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		response.Fail(w, r, http.StatusBadRequest, "MFA token and code are required")
+		return
+	}
+
+	result, err := ah.Service.VerifyMFA(r.Context(), req.MFAToken, req.Code, req.RecoveryCode, ClientInfoFromRequest(r))
+	if err != nil {
+		RespondWithLoginError(w, r, err)
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, result)
+}
+
+// Refresh exchanges a refresh token for a new access/refresh token pair
+func (ah *AuthHandlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.WriteError(w, r, response.BodyError(err))
+		return
+	}
+	if req.RefreshToken == "" {
+		response.Fail(w, r, http.StatusBadRequest, "Refresh token is required")
+		return
+	}
+
+	result, err := ah.Service.Refresh(r.Context(), req.RefreshToken, ClientInfoFromRequest(r))
+	if err != nil {
+		RespondWithLoginError(w, r, err)
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, result)
+}
+
+// Logout revokes the caller's session
+func (ah *AuthHandlers) Logout(w http.ResponseWriter, r *http.Request) {
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response.WriteError(w, r, response.BodyError(err))
+		return
+	}
+
+	ctx := r.Context()
+	var err error
+
+	switch {
+	case req.RefreshToken != "" && !req.All:
+		err = ah.Service.Logout(ctx, req.RefreshToken)
+	default:
+		tokenString, ok := bearerToken(r)
+		if !ok {
+			response.Fail(w, r, http.StatusBadRequest, "Refresh token or bearer token is required")
+			return
+		}
+		claims, parseErr := ah.Service.Signer.Parse(tokenString)
+		// Single-use tokens such as the MFA challenge are not sessions
+		if parseErr == nil && claims.Purpose != "" {
+			parseErr = ErrInvalidToken
+		}
+		if parseErr != nil {
+			response.WriteError(w, r, errInvalidToken)
+			return
+		}
+
+		if req.All {
+			adminID, idErr := primitive.ObjectIDFromHex(claims.Subject)
+			if idErr != nil {
+				response.WriteError(w, r, errInvalidToken)
+				return
+			}
+			err = ah.Service.RevokeAdminSessions(ctx, adminID, "logout all")
+		} else {
+			err = ah.Service.RevokeFamily(ctx, claims.SessionID, "logout")
+		}
+	}
+
+	if err != nil && !errors.Is(err, ErrSessionRevoked) {
+		RespondWithLoginError(w, r, err)
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+// RespondWithLoginError maps a Service.Login or Service.Refresh error to an
+// HTTP response so every module's login endpoint reports failures the same way
+func RespondWithLoginError(w http.ResponseWriter, r *http.Request, err error) {
+	var lockedErr *LockedError
+	switch {
+	case errors.As(err, &lockedErr):
+		retryAfter := int(math.Ceil(lockedErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		response.WriteError(w, r, response.New(http.StatusTooManyRequests, CodeAccountLocked, "Too many failed login attempts").
+			WithDetails(map[string]interface{}{"retryAfterSeconds": retryAfter}))
+	case errors.Is(err, ErrUnavailable):
+		response.WriteError(w, r, response.ErrUnavailable)
+	case errors.Is(err, ErrInvalidCredentials):
+		response.WriteError(w, r, response.New(http.StatusUnauthorized, CodeInvalidCredentials, "Invalid credentials"))
+	case errors.Is(err, ErrAccountDisabled):
+		response.WriteError(w, r, response.New(http.StatusForbidden, CodeAccountDisabled, "Account disabled"))
+	case errors.Is(err, ErrTokenReuse), errors.Is(err, ErrSessionRevoked):
+		response.WriteError(w, r, errSessionRevoked)
+	case errors.Is(err, ErrInvalidMFACode):
+		response.WriteError(w, r, response.New(http.StatusUnauthorized, CodeInvalidMFACode, "Invalid MFA code"))
+	case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrUnknownKey), errors.Is(err, ErrMFANotEnabled):
+		response.WriteError(w, r, errInvalidToken)
+	default:
+		response.Fail(w, r, http.StatusInternalServerError, "Authentication failed")
+	}
+}
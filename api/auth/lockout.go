@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrLocked is wrapped by *LockedError
+var ErrLocked = errors.New("too many failed login attempts")
+
+// LockedError is returned while a username or client IP is locked out
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrLocked, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// LockoutPolicy controls how failed logins are throttled. Once a key reaches
+// its threshold every further failure doubles the lockout, starting at
+// BaseLockout and capped at MaxLockout. Counters are forgotten after Window
+// without failures.
+type LockoutPolicy struct {
+	UserThreshold int
+	IPThreshold   int
+	BaseLockout   time.Duration
+	MaxLockout    time.Duration
+	Window        time.Duration
+}
+
+// DefaultLockoutPolicy is used by NewService
+var DefaultLockoutPolicy = LockoutPolicy{
+	UserThreshold: 5,
+	IPThreshold:   20,
+	BaseLockout:   time.Minute,
+	MaxLockout:    time.Hour,
+	Window:        24 * time.Hour,
+}
+
+// LoginAttempt represents a failure counter in the login_attempts collection.
+// The ID is "user:<username>" or "ip:<address>" so both kinds share one
+// collection and one atomic upsert path.
+type LoginAttempt struct {
+	ID            string     `bson:"_id" json:"id"`
+	Kind          string     `bson:"kind" json:"kind"`
+	Value         string     `bson:"value" json:"value"`
+	Failures      int        `bson:"failures" json:"failures"`
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+	LastFailureAt time.Time  `bson:"lastFailureAt" json:"lastFailureAt"`
+	ExpiresAt     time.Time  `bson:"expiresAt" json:"expiresAt"`
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummy spends the same bcrypt work as a real password check so
+// unknown usernames cannot be told apart by response time
+func compareDummy(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("isy-api-dummy-password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+func userAttemptKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLockout returns a *LockedError if the username or IP is locked
+func (s *Service) checkLockout(ctx context.Context, username, ip string) error {
+	cursor, err := s.DB.Collection("login_attempts").Find(ctx, bson.M{
+		"_id":         bson.M{"$in": []string{userAttemptKey(username), ipAttemptKey(ip)}},
+		"lockedUntil": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var attempts []LoginAttempt
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return err
+	}
+
+	var retryAfter time.Duration
+	for _, attempt := range attempts {
+		if wait := time.Until(*attempt.LockedUntil); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// recordFailure bumps the username and IP counters and locks whichever
+// crossed its threshold
+func (s *Service) recordFailure(ctx context.Context, username, ip string) error {
+	if err := s.bumpAttempt(ctx, userAttemptKey(username), "user", username, s.Lockout.UserThreshold); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return s.bumpAttempt(ctx, ipAttemptKey(ip), "ip", ip, s.Lockout.IPThreshold)
+}
+
+func (s *Service) bumpAttempt(ctx context.Context, key, kind, value string, threshold int) error {
+	collection := s.DB.Collection("login_attempts")
+	now := time.Now()
+
+	var attempt LoginAttempt
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{
+				"kind":          kind,
+				"value":         value,
+				"lastFailureAt": now,
+				"expiresAt":     now.Add(s.Lockout.Window),
+			},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return err
+	}
+
+	if threshold <= 0 || attempt.Failures < threshold {
+		return nil
+	}
+
+	lockedUntil := now.Add(s.lockoutDuration(attempt.Failures - threshold))
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{
+		"$set": bson.M{"lockedUntil": lockedUntil},
+	})
+	return err
+}
+
+// lockoutDuration returns BaseLockout * 2^excess, capped at MaxLockout
+func (s *Service) lockoutDuration(excess int) time.Duration {
+	if excess > 30 {
+		return s.Lockout.MaxLockout
+	}
+	d := time.Duration(float64(s.Lockout.BaseLockout) * math.Pow(2, float64(excess)))
+	if d > s.Lockout.MaxLockout || d <= 0 {
+		return s.Lockout.MaxLockout
+	}
+	return d
+}
+
+// clearFailures resets the username counter after a successful login. The IP
+// counter is left to expire so one valid account can't mask a spraying attack.
+func (s *Service) clearFailures(ctx context.Context, username string) error {
+	_, err := s.DB.Collection("login_attempts").DeleteOne(ctx, bson.M{"_id": userAttemptKey(username)})
+	return err
+}
+
+// Unlock clears the failure counter of a username
+func (s *Service) Unlock(ctx context.Context, username string) error {
+	if s.DB == nil {
+		return ErrUnavailable
+	}
+	return s.clearFailures(ctx, username)
+}
+
+// UnlockKey clears a counter by its login_attempts ID, e.g. "ip:203.0.113.7"
+func (s *Service) UnlockKey(ctx context.Context, key string) (bool, error) {
+	if s.DB == nil {
+		return false, ErrUnavailable
+	}
+	result, err := s.DB.Collection("login_attempts").DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// Lockouts lists the usernames and IPs that are currently locked
+func (s *Service) Lockouts(ctx context.Context) ([]LoginAttempt, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	cursor, err := s.DB.Collection("login_attempts").Find(ctx,
+		bson.M{"lockedUntil": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "lockedUntil", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	attempts := []LoginAttempt{}
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	s := &Service{Lockout: DefaultLockoutPolicy}
+	tests := []struct {
+		excess int
+		want   time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{5, 32 * time.Minute},
+		{6, time.Hour},
+		{30, time.Hour},
+		{31, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := s.lockoutDuration(tt.excess); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", tt.excess, got, tt.want)
+		}
+	}
+}
+
+func TestLockoutExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, "cashier", "Lantern-Orbit-4711")
+	s.Lockout = LockoutPolicy{
+		UserThreshold: 3,
+		BaseLockout:   300 * time.Millisecond,
+		MaxLockout:    time.Second,
+		Window:        time.Hour,
+	}
+	client := ClientInfo{IP: "203.0.113.7"}
+	login := func(password string) error {
+		_, err := s.Login(ctx, "cashier", password, client)
+		return err
+	}
+
+	for i := 0; i < s.Lockout.UserThreshold; i++ {
+		if err := login("wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failure %d = %v, want %v", i+1, err, ErrInvalidCredentials)
+		}
+	}
+	// Locked, even with the right password
+	var locked *LockedError
+	if err := login("Lantern-Orbit-4711"); !errors.As(err, &locked) {
+		t.Fatalf("login while locked = %v, want a lockout", err)
+	}
+	if locked.RetryAfter <= 0 || locked.RetryAfter > s.Lockout.BaseLockout {
+		t.Errorf("retry after %s, want at most %s", locked.RetryAfter, s.Lockout.BaseLockout)
+	}
+
+	time.Sleep(s.Lockout.BaseLockout + 50*time.Millisecond)
+	if err := login("Lantern-Orbit-4711"); err != nil {
+		t.Fatalf("login after the lockout expired: %v", err)
+	}
+	// The successful login forgot the failures, so one more slip does not
+	// lock the account again
+	if err := login("wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("failure after unlocking = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if err := login("Lantern-Orbit-4711"); err != nil {
+		t.Errorf("login after one failure: %v", err)
+	}
+}
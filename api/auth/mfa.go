@@ -0,0 +1,397 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// mfaPurpose marks the short-lived token handed out between the password
+	// step and the TOTP step; Middleware refuses it as an access token
+	mfaPurpose      = "mfa"
+	mfaChallengeTTL = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrInvalidMFACode is returned for wrong, replayed or expired codes
+	ErrInvalidMFACode = errors.New("invalid MFA code")
+	// ErrMFANotPending is returned when activating without starting enrollment
+	ErrMFANotPending = errors.New("MFA enrollment has not been started")
+	// ErrMFAAlreadyEnabled is returned when enrolling an account that already has MFA
+	ErrMFAAlreadyEnabled = errors.New("MFA is already enabled")
+	// ErrMFANotEnabled is returned when managing MFA on an account without it
+	ErrMFANotEnabled = errors.New("MFA is not enabled")
+	// ErrMFAEnforced is returned when an admin tries to turn off mandatory MFA
+	ErrMFAEnforced = errors.New("MFA is required for this account")
+)
+
+// MFASettings is embedded in an admin document. Only the enabled flag is
+// ever serialized to clients.
+type MFASettings struct {
+	Enabled       bool       `bson:"enabled" json:"enabled"`
+	EnabledAt     *time.Time `bson:"enabledAt,omitempty" json:"enabledAt,omitempty"`
+	Secret        string     `bson:"secret,omitempty" json:"-"`
+	PendingSecret string     `bson:"pendingSecret,omitempty" json:"-"`
+	RecoveryCodes []string   `bson:"recoveryCodes,omitempty" json:"-"`
+	LastStep      int64      `bson:"lastStep" json:"-"`
+}
+
+// MFAPolicy decides who has to use MFA. An admin holding any of the
+// RequiredFor permissions must enroll before using the API.
+type MFAPolicy struct {
+	Issuer      string
+	RequiredFor []string
+}
+
+// DefaultMFAPolicy enforces MFA for everyone who can read medical records
+var DefaultMFAPolicy = MFAPolicy{
+	Issuer:      "ISY",
+	RequiredFor: []string{"healthcare:medical-records:read"},
+}
+
+// Required reports whether an admin with permissions must use MFA
+func (p MFAPolicy) Required(permissions []string) bool {
+	for _, required := range p.RequiredFor {
+		if HasPermission(permissions, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// MFAEnabled reports whether the admin has completed TOTP enrollment
+func (a *Admin) MFAEnabled() bool {
+	return a.MFA != nil && a.MFA.Enabled
+}
+
+// MFAEnrollment is returned when enrollment starts
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// mfaChallenge is the first half of a login for accounts with MFA enabled
+func (s *Service) mfaChallenge(admin *Admin) (*LoginResult, error) {
+	claims := Claims{
+		Username: admin.Username,
+		Purpose:  mfaPurpose,
+	}
+	claims.Subject = admin.ID.Hex()
+
+	token, expiresAt, err := s.Signer.IssueWithTTL(claims, mfaChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		Username:     admin.Username,
+		MFARequired:  true,
+		MFAToken:     token,
+		MFAExpiresAt: &expiresAt,
+	}, nil
+}
+
+// VerifyMFA completes a login started with a password. Either a TOTP code or
+// one of the admin's unused recovery codes is accepted. Wrong codes count
+// towards the same lockout as wrong passwords.
+func (s *Service) VerifyMFA(ctx context.Context, mfaToken, code, recoveryCode string, client ClientInfo) (*LoginResult, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	claims, err := s.Signer.Parse(mfaToken)
+	if err != nil || claims.Purpose != mfaPurpose {
+		return nil, ErrInvalidToken
+	}
+
+	adminID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := s.checkLockout(ctx, claims.Username, client.IP); err != nil {
+		return nil, err
+	}
+
+	admin, err := s.findAdmin(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin.Active() {
+		return nil, ErrAccountDisabled
+	}
+	if !admin.MFAEnabled() {
+		return nil, ErrMFANotEnabled
+	}
+
+	var ok bool
+	if recoveryCode != "" {
+		ok, err = s.consumeRecoveryCode(ctx, admin.ID, recoveryCode)
+	} else {
+		ok, err = s.consumeTOTP(ctx, admin, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := s.recordFailure(ctx, admin.Username, client.IP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidMFACode
+	}
+
+	if err := s.clearFailures(ctx, admin.Username); err != nil {
+		return nil, err
+	}
+	return s.completeLogin(ctx, admin, client)
+}
+
+// consumeTOTP validates code and records its step so it cannot be reused
+func (s *Service) consumeTOTP(ctx context.Context, admin *Admin, code string) (bool, error) {
+	step, ok := ValidateTOTP(admin.MFA.Secret, code, time.Now(), admin.MFA.LastStep)
+	if !ok {
+		return false, nil
+	}
+
+	// Conditional on lastStep so two concurrent requests can't both use the code
+	result, err := s.DB.Collection("admins").UpdateOne(ctx,
+		bson.M{"_id": admin.ID, "mfa.lastStep": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"mfa.lastStep": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// consumeRecoveryCode removes a matching recovery code, which makes it single use
+func (s *Service) consumeRecoveryCode(ctx context.Context, adminID primitive.ObjectID, code string) (bool, error) {
+	hash := hashToken(normalizeRecoveryCode(code))
+	result, err := s.DB.Collection("admins").UpdateOne(ctx,
+		bson.M{"_id": adminID, "mfa.recoveryCodes": hash},
+		bson.M{"$pull": bson.M{"mfa.recoveryCodes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// BeginMFAEnrollment generates a pending secret. It only becomes active once
+// ActivateMFA confirms the authenticator app produces matching codes.
+func (s *Service) BeginMFAEnrollment(ctx context.Context, adminID primitive.ObjectID) (*MFAEnrollment, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	admin, err := s.findAdmin(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if admin.MFAEnabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.DB.Collection("admins").UpdateOne(ctx, bson.M{"_id": adminID}, bson.M{
+		"$set": bson.M{
+			"mfa.enabled":       false,
+			"mfa.pendingSecret": secret,
+			"updatedAt":         time.Now(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: TOTPProvisioningURI(s.MFA.Issuer, admin.Username, secret),
+	}, nil
+}
+
+// ActivateMFA verifies a code against the pending secret, enables MFA and
+// returns the recovery codes. The plain codes are only ever shown here.
+func (s *Service) ActivateMFA(ctx context.Context, adminID primitive.ObjectID, code string) ([]string, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	admin, err := s.findAdmin(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if admin.MFAEnabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if admin.MFA == nil || admin.MFA.PendingSecret == "" {
+		return nil, ErrMFANotPending
+	}
+
+	step, ok := ValidateTOTP(admin.MFA.PendingSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = s.DB.Collection("admins").UpdateOne(ctx, bson.M{"_id": adminID}, bson.M{
+		"$set": bson.M{
+			"mfa": MFASettings{
+				Enabled:       true,
+				EnabledAt:     &now,
+				Secret:        admin.MFA.PendingSecret,
+				RecoveryCodes: hashes,
+				LastStep:      step,
+			},
+			"updatedAt": now,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after checking a current TOTP code
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, adminID primitive.ObjectID, code string) ([]string, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	admin, err := s.findAdmin(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin.MFAEnabled() {
+		return nil, ErrMFANotEnabled
+	}
+
+	ok, err := s.consumeTOTP(ctx, admin, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.DB.Collection("admins").UpdateOne(ctx, bson.M{"_id": adminID}, bson.M{
+		"$set": bson.M{"mfa.recoveryCodes": hashes, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableMFA turns MFA off for an admin after checking a current TOTP code.
+// Admins the policy requires MFA for cannot turn it off themselves.
+func (s *Service) DisableMFA(ctx context.Context, adminID primitive.ObjectID, code string) error {
+	if s.DB == nil {
+		return ErrUnavailable
+	}
+
+	admin, err := s.findAdmin(ctx, adminID)
+	if err != nil {
+		return err
+	}
+	if !admin.MFAEnabled() {
+		return ErrMFANotEnabled
+	}
+
+	permissions, err := s.LoadPermissions(ctx, admin)
+	if err != nil {
+		return err
+	}
+	if s.MFA.Required(permissions) {
+		return ErrMFAEnforced
+	}
+
+	ok, err := s.consumeTOTP(ctx, admin, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return s.ResetMFA(ctx, adminID)
+}
+
+// ResetMFA removes an admin's MFA enrollment, e.g. after a lost phone. The
+// admin's sessions are revoked; if MFA is mandatory for them they will have
+// to enroll again at next login.
+func (s *Service) ResetMFA(ctx context.Context, adminID primitive.ObjectID) error {
+	if s.DB == nil {
+		return ErrUnavailable
+	}
+
+	result, err := s.DB.Collection("admins").UpdateOne(ctx, bson.M{"_id": adminID}, bson.M{
+		"$unset": bson.M{"mfa": ""},
+		"$set":   bson.M{"updatedAt": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return s.RevokeAdminSessions(ctx, adminID, "MFA reset")
+}
+
+func (s *Service) findAdmin(ctx context.Context, adminID primitive.ObjectID) (*Admin, error) {
+	var admin Admin
+	if err := s.DB.Collection("admins").FindOne(ctx, bson.M{"_id": adminID}).Decode(&admin); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return &admin, nil
+}
+
+// generateRecoveryCodes returns plain codes formatted xxxx-xxxx-xxxx-xxxx and
+// their hashes. The codes carry 80 random bits, so a plain SHA-256 is enough.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		code := encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16]
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"isy-api/observability"
+	"isy-api/response"
+)
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	clientIPKey
+)
+
+// Error codes specific to authentication
+const (
+	CodeInvalidCredentials     response.Code = "invalid_credentials"
+	CodeInvalidAPIKey          response.Code = "invalid_api_key"
+	CodeSessionRevoked         response.Code = "session_revoked"
+	CodeAccountDisabled        response.Code = "account_disabled"
+	CodeAccountLocked          response.Code = "account_locked"
+	CodeInvalidMFACode         response.Code = "invalid_mfa_code"
+	CodeWeakPassword           response.Code = "weak_password"
+	CodePasswordChangeRequired response.Code = "password_change_required"
+	CodeMFAEnrollmentRequired  response.Code = "mfa_enrollment_required"
+	CodePermissionDenied       response.Code = "permission_denied"
+)
+
+var (
+	errInvalidToken   = response.New(http.StatusUnauthorized, response.CodeInvalidToken, "Invalid or expired token")
+	errSessionRevoked = response.New(http.StatusUnauthorized, CodeSessionRevoked, "Session has been revoked")
+)
+
+// Middleware rejects requests without a valid bearer token or whose session
+// has been revoked. Machine clients may instead send "Authorization: ApiKey
+// <key>". It has the mux.MiddlewareFunc signature so it can be attached with
+// Router.Use.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return s.middleware(next, false)
+}
+
+// AccountSetupMiddleware is Middleware for the routes an admin may still use
+// while their account setup is incomplete: a pending password change or a
+// mandatory MFA enrollment
+func (s *Service) AccountSetupMiddleware(next http.Handler) http.Handler {
+	return s.middleware(next, true)
+}
+
+func (s *Service) middleware(next http.Handler, allowAccountSetup bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := apiKeyFromRequest(r); ok {
+			// Account setup routes act on an admin account, which a key doesn't have
+			if allowAccountSetup {
+				response.Fail(w, r, http.StatusForbidden, "API keys cannot access account settings")
+				return
+			}
+			s.serveAPIKey(w, r, next, key)
+			return
+		}
+
+		tokenString, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="isy-api"`)
+			response.Fail(w, r, http.StatusUnauthorized, "Authorization required")
+			return
+		}
+
+		claims, err := s.Signer.Parse(tokenString)
+		if err == nil && claims.Purpose != "" {
+			err = ErrInvalidToken
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="isy-api", error="invalid_token"`)
+			response.WriteError(w, r, errInvalidToken)
+			return
+		}
+
+		admin, err := s.CheckSession(r.Context(), claims)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrUnavailable):
+				response.WriteError(w, r, response.ErrUnavailable)
+			case errors.Is(err, ErrSessionRevoked), errors.Is(err, ErrAccountDisabled):
+				w.Header().Set("WWW-Authenticate", `Bearer realm="isy-api", error="invalid_token"`)
+				response.WriteError(w, r, errSessionRevoked)
+			default:
+				log.Printf("Session check failed: %v", err)
+				response.Fail(w, r, http.StatusInternalServerError, "Failed to verify session")
+			}
+			return
+		}
+
+		if !allowAccountSetup {
+			if admin.MustChangePassword {
+				response.WriteError(w, r, response.New(http.StatusForbidden, CodePasswordChangeRequired, "Password change required"))
+				return
+			}
+			if !admin.MFAEnabled() && s.MFA.Required(claims.Permissions) {
+				response.WriteError(w, r, response.New(http.StatusForbidden, CodeMFAEnrollmentRequired, "MFA enrollment required"))
+				return
+			}
+		}
+
+		observability.SetUser(r.Context(), claims.Username)
+		next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+	})
+}
+
+func (s *Service) serveAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	claims, err := s.AuthenticateAPIKey(r.Context(), key, ClientInfoFromRequest(r))
+	if err != nil {
+		var rateErr *RateLimitError
+		switch {
+		case errors.As(err, &rateErr):
+			retryAfter := int(math.Ceil(rateErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			response.WriteError(w, r, response.New(http.StatusTooManyRequests, response.CodeRateLimited, "Rate limit exceeded").
+				WithDetails(map[string]interface{}{"retryAfterSeconds": retryAfter}))
+		case errors.Is(err, ErrUnavailable):
+			response.WriteError(w, r, response.ErrUnavailable)
+		case errors.Is(err, ErrInvalidAPIKey):
+			response.WriteError(w, r, response.New(http.StatusUnauthorized, CodeInvalidAPIKey, "Invalid API key"))
+		default:
+			log.Printf("API key check failed: %v", err)
+			response.Fail(w, r, http.StatusInternalServerError, "Failed to verify API key")
+		}
+		return
+	}
+
+	observability.SetUser(r.Context(), claims.Username)
+	next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+}
+
+// WithClaims returns a copy of ctx carrying the authenticated claims
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext returns the claims stored by Middleware, if any
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password ValidatePassword accepts
+const MinPasswordLength = 10
+
+// ErrWeakPassword wraps every password strength failure
+var ErrWeakPassword = errors.New("password does not meet strength requirements")
+
+// commonPasswords are rejected outright, including the defaults the kiosk
+// migration tool used to seed accounts with
+var commonPasswords = map[string]bool{
+	"admin123":    true,
+	"changeme123": true,
+	"password":    true,
+	"password1":   true,
+	"password123": true,
+	"1234567890":  true,
+	"qwertyuiop":  true,
+	"letmein123":  true,
+}
+
+// CommonPassword reports whether hash is the bcrypt hash of one of the
+// common passwords, such as a default the kiosk migration tool seeded
+func CommonPassword(hash string) bool {
+	for password := range commonPasswords {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// PasswordError lists every rule a password failed
+type PasswordError struct {
+	Problems []string
+}
+
+func (e *PasswordError) Error() string {
+	return ErrWeakPassword.Error() + ": " + strings.Join(e.Problems, ", ")
+}
+
+func (e *PasswordError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// ValidatePassword checks password against the strength rules. The returned
+// error is a *PasswordError listing every failed rule.
+func ValidatePassword(password, username string) error {
+	var problems []string
+
+	if len(password) < MinPasswordLength {
+		problems = append(problems, "must be at least 10 characters")
+	}
+
+	var hasUpper, hasLower, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasUpper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if !hasLower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if !hasDigit {
+		problems = append(problems, "must contain a digit")
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		problems = append(problems, "is too common")
+	}
+	if username != "" && strings.Contains(lower, strings.ToLower(username)) {
+		problems = append(problems, "must not contain the username")
+	}
+
+	if len(problems) > 0 {
+		return &PasswordError{Problems: problems}
+	}
+	return nil
+}
+
+// HashPassword returns the bcrypt hash stored in the admins collection
+func HashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// ChangePassword replaces an admin's own password after checking the current
+// one. All existing sessions are revoked and a fresh session is returned so
+// the caller stays signed in on the device that made the change.
+func (s *Service) ChangePassword(ctx context.Context, adminID primitive.ObjectID, currentPassword, newPassword string, client ClientInfo) (*LoginResult, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	var admin Admin
+	if err := s.DB.Collection("admins").FindOne(ctx, bson.M{"_id": adminID}).Decode(&admin); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if err := s.checkLockout(ctx, admin.Username, client.IP); err != nil {
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(currentPassword)); err != nil {
+		if err := s.recordFailure(ctx, admin.Username, client.IP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if currentPassword == newPassword {
+		return nil, &PasswordError{Problems: []string{"must differ from the current password"}}
+	}
+
+	if err := s.SetPassword(ctx, adminID, admin.Username, newPassword, false); err != nil {
+		return nil, err
+	}
+	admin.MustChangePassword = false
+
+	session, refreshToken, err := s.startSession(ctx, &admin, client)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, &admin, session, refreshToken)
+}
+
+// SetPassword validates and stores a new password for an admin and revokes
+// their sessions. mustChange flags the account so the admin has to pick a
+// new password at next login, which is what an administrator reset wants.
+func (s *Service) SetPassword(ctx context.Context, adminID primitive.ObjectID, username, password string, mustChange bool) error {
+	if s.DB == nil {
+		return ErrUnavailable
+	}
+	if err := ValidatePassword(password, username); err != nil {
+		return err
+	}
+
+	hashed, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := s.DB.Collection("admins").UpdateOne(ctx, bson.M{"_id": adminID}, bson.M{
+		"$set": bson.M{
+			"password":           hashed,
+			"mustChangePassword": mustChange,
+			"passwordChangedAt":  now,
+			"updatedAt":          now,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return s.RevokeAdminSessions(ctx, adminID, "password changed")
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCommonPassword(t *testing.T) {
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"admin123", true},
+		{"changeme123", true},
+		{"password123", true},
+		{"Admin123", false},
+		{"Lantern-Orbit-4711", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			hash, err := bcrypt.GenerateFromPassword([]byte(tt.password), bcrypt.MinCost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := CommonPassword(string(hash)); got != tt.want {
+				t.Errorf("CommonPassword(hash of %q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Proxies lists the reverse proxies, such as nginx, whose X-Real-IP and
+// X-Forwarded-For headers are believed. Requests from anywhere else are
+// attributed to their connection address, since a client can send those
+// headers itself.
+type Proxies []netip.Prefix
+
+// ParseProxies parses addresses and CIDR ranges like 10.0.0.1 or
+// 172.16.0.0/12
+func ParseProxies(entries []string) (Proxies, error) {
+	proxies := make(Proxies, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := ParseProxy(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies, nil
+}
+
+// ParseProxy parses one entry of a Proxies list
+func ParseProxy(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%q is not an address or CIDR range", entry)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not an address or CIDR range", entry)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// trusts reports whether addr is one of the proxies
+func (p Proxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client behind r. Forwarding headers
+// are only read when the connection comes from a proxy; X-Forwarded-For is
+// then walked from the right, skipping proxies, since every hop appends to
+// it and only the entries added by proxies can be believed. X-Real-IP is
+// used when the header holds nothing but proxies.
+func (p Proxies) ClientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !p.trusts(addr) {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Whoever wrote this entry was not a proxy, so nothing to
+			// its left can be believed
+			break
+		}
+		if !p.trusts(hop) {
+			return hop.Unmap().String()
+		}
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+	return remote
+}
+
+// Middleware resolves the client address once for ClientInfoFromRequest
+func (p Proxies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey, p.ClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// remoteIP is the address of the connection r arrived on
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxiesClientIP(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.0/8", "192.168.1.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "", "203.0.113.7"},
+		{"untrusted headers", "203.0.113.7:4000", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.7"},
+		{"through proxy", "10.1.2.3:4000", []string{"198.51.100.1"}, "198.51.100.1", "198.51.100.1"},
+		{"spoofed left entry", "10.1.2.3:4000", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1"},
+		{"proxy chain", "10.1.2.3:4000", []string{"198.51.100.1, 192.168.1.5", "10.9.9.9"}, "", "198.51.100.1"},
+		{"malformed hop", "10.1.2.3:4000", []string{"198.51.100.1, junk, 10.9.9.9"}, "198.51.100.9", "198.51.100.9"},
+		{"only proxies", "10.1.2.3:4000", []string{"10.9.9.9"}, "", "10.1.2.3"},
+		{"mapped address", "[::ffff:10.1.2.3]:4000", []string{"::ffff:198.51.100.1"}, "", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, header := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxiesRejectsJunk(t *testing.T) {
+	for _, entry := range []string{"", "nginx", "10.0.0.0/33"} {
+		if _, err := ParseProxies([]string{entry}); err == nil {
+			t.Errorf("ParseProxies(%q) succeeded", entry)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"isy-api/response"
+)
+
+// Permissions are written as module:resource:action, e.g.
+// "healthcare:medical-records:write". A "*" segment matches anything in that
+// position and a trailing "*" matches every remaining segment, so "kiosk:*"
+// grants everything in the kiosk module and "*" grants everything.
+const Wildcard = "*"
+
+// Role represents an entry in the roles collection
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description" json:"description"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// DefaultRoles are used when a role has no document in the roles collection.
+// "admin" is the role createDefaultAdmin assigns, so seeded accounts keep
+// full access until roles are configured.
+var DefaultRoles = map[string][]string{
+	"admin": {Wildcard},
+}
+
+// HasPermission reports whether any of the granted permissions covers required
+func HasPermission(granted []string, required string) bool {
+	for _, permission := range granted {
+		if permissionMatches(permission, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ungranted returns the permissions of requested that granted does not
+// cover. Admins may only hand out permissions they hold themselves.
+func Ungranted(granted, requested []string) []string {
+	var missing []string
+	for _, permission := range requested {
+		if !HasPermission(granted, permission) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing
+}
+
+// Can reports whether the caller authenticated on ctx holds permission
+func Can(ctx context.Context, permission string) bool {
+	claims, ok := ClaimsFromContext(ctx)
+	return ok && HasPermission(claims.Permissions, permission)
+}
+
+func permissionMatches(pattern, required string) bool {
+	patternParts := strings.Split(pattern, ":")
+	requiredParts := strings.Split(required, ":")
+
+	for i, part := range patternParts {
+		if part == Wildcard && i == len(patternParts)-1 {
+			return true
+		}
+		if i >= len(requiredParts) {
+			return false
+		}
+		if part != Wildcard && part != requiredParts[i] {
+			return false
+		}
+	}
+	return len(patternParts) == len(requiredParts)
+}
+
+// LoadPermissions resolves the effective permissions of an admin: the
+// permissions of their role plus any granted to them individually
+func (s *Service) LoadPermissions(ctx context.Context, admin *Admin) ([]string, error) {
+	permissions := []string{}
+	seen := map[string]bool{}
+	add := func(list []string) {
+		for _, p := range list {
+			if p != "" && !seen[p] {
+				seen[p] = true
+				permissions = append(permissions, p)
+			}
+		}
+	}
+
+	if admin.Role != "" {
+		var role Role
+		err := s.DB.Collection("roles").FindOne(ctx, bson.M{"name": admin.Role}).Decode(&role)
+		switch {
+		case err == nil:
+			add(role.Permissions)
+		case err == mongo.ErrNoDocuments:
+			add(DefaultRoles[admin.Role])
+		default:
+			return nil, err
+		}
+	}
+
+	add(admin.Permissions)
+	return permissions, nil
+}
+
+// Require wraps a handler so it only runs when the authenticated caller holds
+// permission. It must be mounted behind Middleware.
+func Require(permission string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok {
+			response.Fail(w, r, http.StatusUnauthorized, "Authorization required")
+			return
+		}
+
+		if !HasPermission(claims.Permissions, permission) {
+			response.WriteError(w, r, response.New(http.StatusForbidden, CodePermissionDenied, "Insufficient permissions").
+				WithDetails(map[string]interface{}{
+					"required": permission,
+					"role":     claims.Role,
+				}))
+			return
+		}
+
+		next(w, r)
+	})
+}
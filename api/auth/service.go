@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+
+	"isy-api/config"
+)
+
+var (
+	// ErrInvalidCredentials is returned for unknown users and wrong passwords alike
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUnavailable is returned when the database is not connected
+	ErrUnavailable = errors.New("database not available")
+)
+
+// Admin represents an entry in the admins collection
+type Admin struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Username    string             `bson:"username" json:"username"`
+	Password    string             `bson:"password" json:"-"`
+	Email       string             `bson:"email" json:"email"`
+	Role        string             `bson:"role" json:"role"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	IsActive    *bool              `bson:"isActive,omitempty" json:"isActive,omitempty"`
+	Tenant      string             `bson:"tenant,omitempty" json:"tenant,omitempty"`
+
+	MFA *MFASettings `bson:"mfa,omitempty" json:"mfa,omitempty"`
+
+	MustChangePassword bool       `bson:"mustChangePassword" json:"mustChangePassword"`
+	PasswordChangedAt  *time.Time `bson:"passwordChangedAt,omitempty" json:"passwordChangedAt,omitempty"`
+	LastLoginAt        *time.Time `bson:"lastLoginAt,omitempty" json:"lastLoginAt,omitempty"`
+
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Active reports whether the admin may sign in. Accounts imported without an
+// isActive field are treated as active; only an explicit false disables them.
+func (a *Admin) Active() bool {
+	return a.IsActive == nil || *a.IsActive
+}
+
+// LoginResult is returned after a successful login. For accounts with MFA
+// the password step only yields MFARequired and an MFAToken, which has to be
+// exchanged at /auth/mfa/verify together with a TOTP code.
+type LoginResult struct {
+	Token            string    `json:"token,omitempty"`
+	ExpiresAt        time.Time `json:"expiresAt,omitzero"`
+	RefreshToken     string    `json:"refreshToken,omitempty"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt,omitzero"`
+	Username         string    `json:"username"`
+	Role             string    `json:"role,omitempty"`
+	Permissions      []string  `json:"permissions,omitempty"`
+
+	MFARequired  bool       `json:"mfaRequired"`
+	MFAToken     string     `json:"mfaToken,omitempty"`
+	MFAExpiresAt *time.Time `json:"mfaExpiresAt,omitempty"`
+
+	// PasswordChangeRequired and MFAEnrollmentRequired tell the client to
+	// send the user to account setup; every other route answers 403 until
+	// they have finished it
+	PasswordChangeRequired bool `json:"passwordChangeRequired"`
+	MFAEnrollmentRequired  bool `json:"mfaEnrollmentRequired"`
+}
+
+// Service authenticates admins and issues tokens
+type Service struct {
+	DB         *mongo.Database
+	Signer     *Signer
+	RefreshTTL time.Duration
+	Lockout    LockoutPolicy
+	MFA        MFAPolicy
+
+	apiKeyLimiter *rateLimiter
+}
+
+// NewService creates a new auth service with the session and MFA settings
+// from cfg
+func NewService(db *mongo.Database, signer *Signer, cfg config.Auth) *Service {
+	mfa := DefaultMFAPolicy
+	mfa.RequiredFor = cfg.MFARequiredPermissions
+
+	refreshTTL := cfg.RefreshTokenTTL
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTTL
+	}
+
+	return &Service{
+		DB:         db,
+		Signer:     signer,
+		RefreshTTL: refreshTTL,
+		Lockout:    DefaultLockoutPolicy,
+		MFA:        mfa,
+
+		apiKeyLimiter: newRateLimiter(),
+	}
+}
+
+// Login checks the credentials against the admins collection, opens a new
+// session and issues an access/refresh token pair. Failed attempts are
+// counted per username and per client IP; a locked key yields *LockedError.
+func (s *Service) Login(ctx context.Context, username, password string, client ClientInfo) (*LoginResult, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	if err := s.checkLockout(ctx, username, client.IP); err != nil {
+		return nil, err
+	}
+
+	var admin Admin
+	err := s.DB.Collection("admins").FindOne(ctx, bson.M{"username": username}).Decode(&admin)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		// Unknown usernames cost the same as wrong passwords and are counted
+		// the same way, so neither timing nor lockouts reveal which exist
+		compareDummy(password)
+		if err := s.recordFailure(ctx, username, client.IP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(password)); err != nil {
+		if err := s.recordFailure(ctx, username, client.IP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := s.clearFailures(ctx, username); err != nil {
+		return nil, err
+	}
+
+	if !admin.Active() {
+		return nil, ErrAccountDisabled
+	}
+
+	if admin.MFAEnabled() {
+		return s.mfaChallenge(&admin)
+	}
+	return s.completeLogin(ctx, &admin, client)
+}
+
+// completeLogin opens a session once every login factor has been checked
+func (s *Service) completeLogin(ctx context.Context, admin *Admin, client ClientInfo) (*LoginResult, error) {
+	session, refreshToken, err := s.startSession(ctx, admin, client)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := s.DB.Collection("admins").UpdateOne(ctx, bson.M{"_id": admin.ID}, bson.M{"$set": bson.M{"lastLoginAt": now}}); err != nil {
+		return nil, err
+	}
+
+	return s.issue(ctx, admin, session, refreshToken)
+}
+
+// issue signs an access token bound to session with the admin's current permissions
+func (s *Service) issue(ctx context.Context, admin *Admin, session *Session, refreshToken string) (*LoginResult, error) {
+	permissions, err := s.LoadPermissions(ctx, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := Claims{
+		Username:    admin.Username,
+		Role:        admin.Role,
+		Permissions: permissions,
+		SessionID:   session.FamilyID,
+		Tenant:      admin.Tenant,
+	}
+	claims.Subject = admin.ID.Hex()
+
+	token, expiresAt, err := s.Signer.Issue(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+		Username:         admin.Username,
+		Role:             admin.Role,
+		Permissions:      permissions,
+
+		PasswordChangeRequired: admin.MustChangePassword,
+		MFAEnrollmentRequired:  s.MFA.Required(permissions) && !admin.MFAEnabled(),
+	}, nil
+}
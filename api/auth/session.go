@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultRefreshTTL is the lifetime of a refresh token when none is configured
+const DefaultRefreshTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrSessionRevoked is returned when a token belongs to a revoked or expired session
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrTokenReuse is returned when an already rotated refresh token is presented again
+	ErrTokenReuse = errors.New("refresh token reuse detected")
+	// ErrAccountDisabled is returned for admins with isActive=false
+	ErrAccountDisabled = errors.New("account disabled")
+)
+
+// Session represents one refresh token in the sessions collection. Every
+// rotation inserts a new document with the same FamilyID and marks the
+// previous one as used, so presenting a used token again reveals theft and
+// the whole family is revoked.
+type Session struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	FamilyID      string             `bson:"familyId" json:"familyId"`
+	AdminID       primitive.ObjectID `bson:"adminId" json:"adminId"`
+	Username      string             `bson:"username" json:"username"`
+	TokenHash     string             `bson:"tokenHash" json:"-"`
+	UserAgent     string             `bson:"userAgent" json:"userAgent"`
+	IP            string             `bson:"ip" json:"ip"`
+	UsedAt        *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	RevokedAt     *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	RevokedReason string             `bson:"revokedReason,omitempty" json:"revokedReason,omitempty"`
+	ExpiresAt     time.Time          `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// ClientInfo describes the device a session was opened from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// ClientInfoFromRequest extracts the client address and user agent. The
+// address is the one resolved by Proxies.Middleware, which only believes
+// forwarding headers from trusted proxies; without the middleware it is the
+// connection address.
+func ClientInfoFromRequest(r *http.Request) ClientInfo {
+	ip, ok := r.Context().Value(clientIPKey).(string)
+	if !ok {
+		ip = remoteIP(r)
+	}
+	return ClientInfo{IP: ip, UserAgent: r.UserAgent()}
+}
+
+// startSession opens a new session family for admin and returns its refresh token
+func (s *Service) startSession(ctx context.Context, admin *Admin, client ClientInfo) (*Session, string, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.insertSession(ctx, admin.ID, admin.Username, familyID, client)
+}
+
+func (s *Service) insertSession(ctx context.Context, adminID primitive.ObjectID, username, familyID string, client ClientInfo) (*Session, string, error) {
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &Session{
+		FamilyID:  familyID,
+		AdminID:   adminID,
+		Username:  username,
+		TokenHash: hashToken(refreshToken),
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		ExpiresAt: now.Add(s.RefreshTTL),
+		CreatedAt: now,
+	}
+
+	result, err := s.DB.Collection("sessions").InsertOne(ctx, session)
+	if err != nil {
+		return nil, "", err
+	}
+	session.ID = result.InsertedID.(primitive.ObjectID)
+
+	return session, refreshToken, nil
+}
+
+// Refresh rotates a refresh token and issues a new access token. Presenting a
+// token that was already rotated revokes every session in its family.
+func (s *Service) Refresh(ctx context.Context, refreshToken string, client ClientInfo) (*LoginResult, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	collection := s.DB.Collection("sessions")
+	tokenHash := hashToken(refreshToken)
+	now := time.Now()
+
+	var session Session
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{
+			"tokenHash": tokenHash,
+			"usedAt":    bson.M{"$exists": false},
+			"revokedAt": bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&session)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+
+		// Distinguish a replayed token from an unknown or expired one
+		if findErr := collection.FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&session); findErr == nil && session.UsedAt != nil {
+			if err := s.RevokeFamily(ctx, session.FamilyID, "refresh token reuse"); err != nil {
+				return nil, err
+			}
+			return nil, ErrTokenReuse
+		}
+		return nil, ErrSessionRevoked
+	}
+
+	var admin Admin
+	if err := s.DB.Collection("admins").FindOne(ctx, bson.M{"_id": session.AdminID}).Decode(&admin); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+	if !admin.Active() {
+		if err := s.RevokeAdminSessions(ctx, admin.ID, "admin deactivated"); err != nil {
+			return nil, err
+		}
+		return nil, ErrAccountDisabled
+	}
+
+	next, nextToken, err := s.insertSession(ctx, admin.ID, admin.Username, session.FamilyID, client)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, &admin, next, nextToken)
+}
+
+// Logout revokes the session family the refresh token belongs to
+func (s *Service) Logout(ctx context.Context, refreshToken string) error {
+	if s.DB == nil {
+		return ErrUnavailable
+	}
+
+	var session Session
+	err := s.DB.Collection("sessions").FindOne(ctx, bson.M{"tokenHash": hashToken(refreshToken)}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrSessionRevoked
+		}
+		return err
+	}
+	return s.RevokeFamily(ctx, session.FamilyID, "logout")
+}
+
+// RevokeFamily revokes every refresh token that shares familyID
+func (s *Service) RevokeFamily(ctx context.Context, familyID, reason string) error {
+	return s.revokeSessions(ctx, bson.M{"familyId": familyID}, reason)
+}
+
+// RevokeAdminSessions revokes every session belonging to an admin
+func (s *Service) RevokeAdminSessions(ctx context.Context, adminID primitive.ObjectID, reason string) error {
+	return s.revokeSessions(ctx, bson.M{"adminId": adminID}, reason)
+}
+
+func (s *Service) revokeSessions(ctx context.Context, filter bson.M, reason string) error {
+	if s.DB == nil {
+		return ErrUnavailable
+	}
+
+	filter["revokedAt"] = bson.M{"$exists": false}
+	_, err := s.DB.Collection("sessions").UpdateMany(ctx, filter, bson.M{
+		"$set": bson.M{
+			"revokedAt":     time.Now(),
+			"revokedReason": reason,
+		},
+	})
+	return err
+}
+
+// CheckSession verifies that the session behind an access token is still
+// live and its admin has not been deactivated. The admin is returned so
+// callers can inspect account flags such as MustChangePassword.
+func (s *Service) CheckSession(ctx context.Context, claims *Claims) (*Admin, error) {
+	if s.DB == nil {
+		return nil, ErrUnavailable
+	}
+	if claims.SessionID == "" {
+		return nil, ErrSessionRevoked
+	}
+
+	adminID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return nil, ErrSessionRevoked
+	}
+
+	var admin Admin
+	err = s.DB.Collection("admins").FindOne(ctx, bson.M{"_id": adminID},
+		options.FindOne().SetProjection(bson.M{"password": 0})).Decode(&admin)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+	if !admin.Active() {
+		return nil, ErrAccountDisabled
+	}
+
+	err = s.DB.Collection("sessions").FindOne(ctx, bson.M{
+		"familyId":  claims.SessionID,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+	return &admin, nil
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"isy-api/config"
+	"isy-api/mongodb/mongotest"
+)
+
+// newTestService returns a service on a throwaway database holding one admin
+// who logs in with username and password
+func newTestService(t *testing.T, username, password string) *Service {
+	t.Helper()
+	db := mongotest.Database(t)
+	signer, err := NewSigner("test", map[string][]byte{"test": []byte("test-secret")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Collection("admins").InsertOne(context.Background(), Admin{
+		Username:    username,
+		Password:    hash,
+		Permissions: []string{"kiosk:*"},
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(db, signer, config.Auth{})
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, "cashier", "Lantern-Orbit-4711")
+	client := ClientInfo{IP: "203.0.113.7", UserAgent: "test"}
+
+	login, err := s.Login(ctx, "cashier", "Lantern-Orbit-4711", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second login opens a family of its own, untouched by the reuse below
+	other, err := s.Login(ctx, "cashier", "Lantern-Orbit-4711", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := s.Refresh(ctx, login.RefreshToken, client)
+	if err != nil {
+		t.Fatalf("first refresh: %v", err)
+	}
+	claims, err := s.Signer.Parse(rotated.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CheckSession(ctx, claims); err != nil {
+		t.Fatalf("session after rotation: %v", err)
+	}
+
+	// Presenting the rotated token again gives the theft away
+	if _, err := s.Refresh(ctx, login.RefreshToken, client); !errors.Is(err, ErrTokenReuse) {
+		t.Fatalf("reused refresh = %v, want %v", err, ErrTokenReuse)
+	}
+
+	tests := []struct {
+		name  string
+		check func() error
+		want  error
+	}{
+		{"rotated refresh token", func() error {
+			_, err := s.Refresh(ctx, rotated.RefreshToken, client)
+			return err
+		}, ErrSessionRevoked},
+		{"access token of the family", func() error {
+			_, err := s.CheckSession(ctx, claims)
+			return err
+		}, ErrSessionRevoked},
+		{"reused refresh token again", func() error {
+			_, err := s.Refresh(ctx, login.RefreshToken, client)
+			return err
+		}, ErrTokenReuse},
+		{"unknown refresh token", func() error {
+			_, err := s.Refresh(ctx, "not-a-token", client)
+			return err
+		}, ErrSessionRevoked},
+		{"refresh token of another family", func() error {
+			_, err := s.Refresh(ctx, other.RefreshToken, client)
+			return err
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	live, err := s.DB.Collection("sessions").CountDocuments(ctx, bson.M{
+		"familyId":  claims.SessionID,
+		"revokedAt": bson.M{"$exists": false},
+	})
+	if err != nil || live != 0 {
+		t.Errorf("live sessions in the family = %d (%v), want 0", live, err)
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"isy-api/config"
+)
+
+const (
+	// Issuer is written into the iss claim of every token we mint
+	Issuer = "isy-api"
+
+	// DefaultTokenTTL is the lifetime of an access token when none is configured
+	DefaultTokenTTL = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidToken is returned when a token cannot be parsed or verified
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrUnknownKey is returned when a token references a kid we don't hold
+	ErrUnknownKey = errors.New("unknown signing key")
+)
+
+// Claims represents the JWT claims issued by the API
+type Claims struct {
+	Username    string   `json:"username"`
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
+	// Tenant binds the caller to one shop or clinic; empty for platform
+	// admins, who may pick a tenant per request
+	Tenant string `json:"tid,omitempty"`
+	// Purpose is set on single-use tokens such as the MFA challenge; only
+	// tokens without a purpose are access tokens
+	Purpose string `json:"pur,omitempty"`
+	// APIKeyID is set by Middleware for requests authenticated with an API
+	// key instead of a token; it is never part of a signed token
+	APIKeyID string `json:"-"`
+	jwt.RegisteredClaims
+}
+
+// Signer issues and verifies HS256 tokens. New tokens are always signed with
+// the active key; older keys are kept for verification so secrets can be
+// rotated without logging everyone out.
+type Signer struct {
+	activeKID string
+	keys      map[string][]byte
+	ttl       time.Duration
+}
+
+// NewSigner creates a signer that signs with keys[activeKID]
+func NewSigner(activeKID string, keys map[string][]byte, ttl time.Duration) (*Signer, error) {
+	if _, ok := keys[activeKID]; !ok {
+		return nil, fmt.Errorf("active key %q not found in key set", activeKID)
+	}
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	return &Signer{activeKID: activeKID, keys: keys, ttl: ttl}, nil
+}
+
+// NewSignerFromConfig builds a signer from the JWT settings. Without a
+// secret it signs with an ephemeral key; config.Validate refuses that in
+// production.
+func NewSignerFromConfig(cfg config.JWT) (*Signer, error) {
+	secret := cfg.Secret
+	if secret == "" {
+		log.Println("Warning: JWT_SECRET not set, using an ephemeral key (tokens will not survive a restart)")
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		secret = string(random)
+	}
+
+	keys := map[string][]byte{cfg.KeyID: []byte(secret)}
+	for kid, previous := range cfg.PreviousKeys {
+		if kid == cfg.KeyID {
+			return nil, fmt.Errorf("previous keys redefine active key %q", kid)
+		}
+		keys[kid] = []byte(previous)
+	}
+
+	return NewSigner(cfg.KeyID, keys, cfg.TTL)
+}
+
+// Issue signs a new access token. The registered time and issuer claims are
+// filled in here; callers supply the subject and the application claims.
+func (s *Signer) Issue(claims Claims) (string, time.Time, error) {
+	return s.IssueWithTTL(claims, s.ttl)
+}
+
+// IssueWithTTL is Issue with an explicit lifetime
+func (s *Signer) IssueWithTTL(claims Claims, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	claims.Issuer = Issuer
+	claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = s.activeKID
+
+	signed, err := token.SignedString(s.keys[s.activeKID])
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Parse verifies a token and returns its claims
+func (s *Signer) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, s.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(Issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			return nil, ErrUnknownKey
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+func (s *Signer) keyFunc(token *jwt.Token) (interface{}, error) {
+	// Tokens minted before kid support carry no header; treat them as the active key
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = s.activeKID
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"isy-api/config"
+)
+
+func TestSignerRotation(t *testing.T) {
+	before, err := NewSigner("2025", map[string][]byte{"2025": []byte("old-secret")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// After rotation the old key only verifies
+	after, err := NewSigner("2026", map[string][]byte{"2026": []byte("new-secret"), "2025": []byte("old-secret")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Once the old key is dropped its tokens are refused
+	retired, err := NewSigner("2026", map[string][]byte{"2026": []byte("new-secret")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(s *Signer) string {
+		token, _, err := s.Issue(Claims{Username: "admin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	// sign builds a token by hand, for headers and claims Issue never writes
+	sign := func(kid string, key []byte, expiresIn time.Duration) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+			Username: "admin",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    Issuer,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			},
+		})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		token  string
+		want   error
+	}{
+		{"new token after rotation", after, issue(after), nil},
+		{"old token after rotation", after, issue(before), nil},
+		{"new token before rotation", before, issue(after), ErrUnknownKey},
+		{"old token after the old key is dropped", retired, issue(before), ErrUnknownKey},
+		{"token without kid signed with the active key", after, sign("", []byte("new-secret"), time.Hour), nil},
+		{"token without kid signed with an old key", after, sign("", []byte("old-secret"), time.Hour), ErrInvalidToken},
+		{"kid naming the wrong key", after, sign("2026", []byte("old-secret"), time.Hour), ErrInvalidToken},
+		{"expired token", after, sign("2026", []byte("new-secret"), -time.Minute), ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.signer.Parse(tt.token)
+			if tt.want == nil {
+				if err != nil || claims.Username != "admin" {
+					t.Fatalf("Parse = %+v, %v", claims, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Parse error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSignerFromConfigKeepsPreviousKeys(t *testing.T) {
+	cfg := config.JWT{Secret: "new-secret", KeyID: "2026", PreviousKeys: map[string]string{"2025": "old-secret"}}
+	signer, err := NewSignerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := NewSigner("2025", map[string][]byte{"2025": []byte("old-secret")}, time.Hour)
+	token, _, err := old.Issue(Claims{Username: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Parse(token); err != nil {
+		t.Errorf("token signed with a previous key: %v", err)
+	}
+
+	cfg.PreviousKeys = map[string]string{"2026": "old-secret"}
+	if _, err := NewSignerFromConfig(cfg); err == nil {
+		t.Error("previous keys redefining the active key were accepted")
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// limitBody returns middleware that stops reading request bodies after limit
+// bytes. Decoders then fail with *http.MaxBytesError, which
+// response.BodyError answers with 413.
+func limitBody(limit int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"isy-api/apitest"
+	"isy-api/auth"
+	"isy-api/config"
+	"isy-api/response"
+)
+
+func TestLimitBody(t *testing.T) {
+	// Without a database a body that decodes gets as far as the login itself
+	login := limitBody(64)(http.HandlerFunc(auth.NewAuthHandlers(auth.NewService(nil, nil, config.Auth{})).Login))
+
+	tests := []struct {
+		name string
+		body string
+		want int
+		code response.Code
+	}{
+		{"within the limit", `{"username":"admin","password":"secret"}`, http.StatusServiceUnavailable, response.CodeUnavailable},
+		{"over the limit", `{"username":"admin","password":"` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge, response.CodePayloadTooLarge},
+		{"malformed", `{"username":`, http.StatusBadRequest, response.CodeInvalidBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := apitest.Serve(t, login.ServeHTTP, apitest.Request("POST", "/auth/login", tt.body))
+			if status != tt.want || body.Code != string(tt.code) {
+				t.Errorf("login = %d %s, want %d %s", status, body.Code, tt.want, tt.code)
+			}
+		})
+	}
+}
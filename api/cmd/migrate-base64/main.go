@@ -35,7 +35,12 @@ func main() {
 	}
 	defer client.Disconnect(context.Background())
 
-	db := client.Database("isy_api")
+	// DB_NAME selects the shared database or a tenant database
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "isy_api"
+	}
+	db := client.Database(dbName)
 	collection := db.Collection("metadata")
 
 	// Create uploads directory
@@ -0,0 +1,419 @@
+// Package config loads the API settings from defaults, an optional YAML
+// file, a .env file and the environment, in increasing order of precedence.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Environments accepted in APP_ENV
+const (
+	Development = "development"
+	Staging     = "staging"
+	Production  = "production"
+)
+
+// DefaultFile is read when CONFIG_FILE is not set and the file exists
+const DefaultFile = "config.yaml"
+
+// Config holds every setting the API reads at startup
+type Config struct {
+	Environment   string        `yaml:"environment"`
+	Server        Server        `yaml:"server"`
+	Mongo         Mongo         `yaml:"mongo"`
+	Uploads       Uploads       `yaml:"uploads"`
+	Auth          Auth          `yaml:"auth"`
+	Tenants       Tenants       `yaml:"tenants"`
+	CORS          CORS          `yaml:"cors"`
+	Observability Observability `yaml:"observability"`
+	Kiosk         Kiosk         `yaml:"kiosk"`
+}
+
+// Server configures the HTTP listener
+type Server struct {
+	Port            string        `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TrustedProxies lists the addresses and CIDR ranges of the reverse
+	// proxies whose X-Real-IP and X-Forwarded-For headers are believed;
+	// empty attributes every request to its connection address
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// MaxBodySize caps the bytes read from a request body; uploads are
+	// capped by Uploads.MaxFileSize instead
+	MaxBodySize int64 `yaml:"max_body_size"`
+}
+
+// Mongo locates the shared database
+type Mongo struct {
+	URI      string `yaml:"uri"`
+	Database string `yaml:"database"`
+	// MigrateOnBoot applies pending migrations when the database connects;
+	// without it they are applied with the migrate subcommand
+	MigrateOnBoot bool `yaml:"migrate_on_boot"`
+}
+
+// Uploads configures where uploaded files are stored
+type Uploads struct {
+	Dir         string `yaml:"dir"`
+	MaxFileSize int64  `yaml:"max_file_size"`
+}
+
+// Auth configures tokens, sessions and MFA
+type Auth struct {
+	JWT             JWT           `yaml:"jwt"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// MFARequiredPermissions lists the permissions whose holders must enroll
+	// TOTP; empty makes MFA optional for everyone
+	MFARequiredPermissions []string `yaml:"mfa_required_permissions"`
+}
+
+// JWT configures the token signing keys
+type JWT struct {
+	Secret string `yaml:"secret"`
+	KeyID  string `yaml:"key_id"`
+	// PreviousKeys maps retired kids to their secrets; tokens signed with
+	// them are still accepted
+	PreviousKeys map[string]string `yaml:"previous_keys"`
+	TTL          time.Duration     `yaml:"ttl"`
+}
+
+// Tenants configures tenant resolution
+type Tenants struct {
+	Required bool `yaml:"required"`
+}
+
+// CORS lists the browser origins allowed to call each module. A module
+// without origins answers no cross-origin requests.
+type CORS struct {
+	Healthcare []string `yaml:"healthcare"`
+	Retail     []string `yaml:"retail"`
+	Kiosk      []string `yaml:"kiosk"`
+	Site       []string `yaml:"site"`
+	// Admin lists extra origins for the admin and auth routes, which accept
+	// the origins of every module as well
+	Admin []string `yaml:"admin"`
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Observability configures metrics and tracing
+type Observability struct {
+	MetricsToken   string `yaml:"metrics_token"`
+	TracesExporter string `yaml:"traces_exporter"`
+}
+
+// Kiosk configures the kiosk module
+type Kiosk struct {
+	Pricing Pricing `yaml:"pricing"`
+	Points  Points  `yaml:"points"`
+}
+
+// Pricing configures how kiosk carts are priced
+type Pricing struct {
+	// RoundTo is the increment the amount due is rounded to, e.g. 0.25 when
+	// the smallest coin is 25 satang. Line amounts are rounded to cents.
+	RoundTo float64 `yaml:"round_to"`
+	// Discounts are taken off after member prices. A line gets the largest
+	// discount it qualifies for; discounts do not stack.
+	Discounts []Discount `yaml:"discounts"`
+}
+
+// Discount takes a percentage off the cart lines it matches
+type Discount struct {
+	Name    string  `yaml:"name"`
+	Percent float64 `yaml:"percent"`
+	// Categories limits the discount to products of these category IDs;
+	// empty matches every product
+	Categories []string `yaml:"categories"`
+	// MinQuantity is the smallest line quantity that qualifies
+	MinQuantity int  `yaml:"min_quantity"`
+	MembersOnly bool `yaml:"members_only"`
+}
+
+// Points configures the kiosk loyalty ledger
+type Points struct {
+	// EarnRate is the points a member earns per currency unit paid
+	EarnRate float64 `yaml:"earn_rate"`
+	// CategoryRates overrides EarnRate for products of a category ID
+	CategoryRates map[string]float64 `yaml:"category_rates"`
+	// Value is what one point is worth when redeemed against an order
+	Value float64 `yaml:"value"`
+	// Expiry is how long earned points can be redeemed; zero keeps them
+	// forever
+	Expiry time.Duration `yaml:"expiry"`
+	// RequireApproval holds the points completed orders earn as pending
+	// awards until an admin approves them
+	RequireApproval bool `yaml:"require_approval"`
+}
+
+// Default returns the settings used when nothing else is configured
+func Default() *Config {
+	return &Config{
+		Environment: Development,
+		Server: Server{
+			Port:            "8080",
+			ReadTimeout:     time.Minute,
+			WriteTimeout:    time.Minute,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+			MaxBodySize:     1 << 20,
+		},
+		Mongo: Mongo{
+			URI:           "mongodb://localhost:27017",
+			Database:      "isy_api",
+			MigrateOnBoot: true,
+		},
+		Uploads: Uploads{
+			// Uploaded files live on a Docker volume mount by default
+			Dir:         "/root/uploads",
+			MaxFileSize: 20 << 20,
+		},
+		Auth: Auth{
+			JWT: JWT{
+				KeyID: "default",
+				TTL:   24 * time.Hour,
+			},
+			RefreshTokenTTL:        30 * 24 * time.Hour,
+			MFARequiredPermissions: []string{"healthcare:medical-records:read"},
+		},
+		CORS: CORS{
+			Healthcare: []string{"https://health.isy.software"},
+			Retail:     []string{"https://retail.isy.software"},
+			Kiosk:      []string{"https://kiosk.isy.software"},
+			Site:       []string{"https://isy.software", "https://www.isy.software"},
+			MaxAge:     10 * time.Minute,
+		},
+		Observability: Observability{
+			TracesExporter: "none",
+		},
+		Kiosk: Kiosk{
+			Pricing: Pricing{RoundTo: 0.01},
+			// One point per 100 spent, worth 1 when redeemed
+			Points: Points{EarnRate: 0.01, Value: 1},
+		},
+	}
+}
+
+// Load reads the configuration and validates it. Variables from .env never
+// override the real environment, and the environment overrides the YAML
+// file named by CONFIG_FILE.
+func Load() (*Config, error) {
+	if err := godotenv.Load(); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read .env: %w", err)
+		}
+		log.Println("No .env file found, using environment variables")
+	}
+
+	cfg := Default()
+
+	path, explicit := os.LookupEnv("CONFIG_FILE")
+	if !explicit {
+		path = DefaultFile
+	}
+	if path != "" {
+		if err := cfg.loadFile(path, explicit); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// IsProduction reports whether the API runs in production
+func (c *Config) IsProduction() bool {
+	return c.Environment == Production
+}
+
+// loadFile overlays the YAML file at path. A missing file is only an error
+// when it was named explicitly.
+func (c *Config) loadFile(path string, required bool) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overlays the environment variables that are set
+func (c *Config) loadEnv() error {
+	var errs []error
+
+	str := func(dst *string, key string) {
+		if v := os.Getenv(key); v != "" {
+			*dst = v
+		}
+	}
+	duration := func(dst *time.Duration, key string) {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	size := func(dst *int64, key string) {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+
+	// Set but empty clears a list
+	list := func(dst *[]string, key string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = splitList(v)
+		}
+	}
+
+	str(&c.Environment, "APP_ENV")
+
+	str(&c.Server.Port, "PORT")
+	duration(&c.Server.ReadTimeout, "HTTP_READ_TIMEOUT")
+	duration(&c.Server.WriteTimeout, "HTTP_WRITE_TIMEOUT")
+	duration(&c.Server.IdleTimeout, "HTTP_IDLE_TIMEOUT")
+	duration(&c.Server.ShutdownTimeout, "SHUTDOWN_TIMEOUT")
+	list(&c.Server.TrustedProxies, "TRUSTED_PROXIES")
+	size(&c.Server.MaxBodySize, "MAX_BODY_SIZE")
+
+	str(&c.Mongo.URI, "MONGO_URI")
+	str(&c.Mongo.Database, "DB_NAME")
+	if v := os.Getenv("MIGRATE_ON_BOOT"); v != "" {
+		migrate, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("MIGRATE_ON_BOOT: %w", err))
+		} else {
+			c.Mongo.MigrateOnBoot = migrate
+		}
+	}
+
+	str(&c.Uploads.Dir, "UPLOADS_DIR")
+	size(&c.Uploads.MaxFileSize, "MAX_FILE_SIZE")
+
+	str(&c.Auth.JWT.Secret, "JWT_SECRET")
+	str(&c.Auth.JWT.KeyID, "JWT_KEY_ID")
+	duration(&c.Auth.JWT.TTL, "JWT_TTL")
+	if v := os.Getenv("JWT_PREVIOUS_KEYS"); v != "" {
+		keys, err := parseKeys(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("JWT_PREVIOUS_KEYS: %w", err))
+		} else {
+			c.Auth.JWT.PreviousKeys = keys
+		}
+	}
+	duration(&c.Auth.RefreshTokenTTL, "REFRESH_TOKEN_TTL")
+	// Empty makes MFA optional for everyone
+	list(&c.Auth.MFARequiredPermissions, "MFA_REQUIRED_PERMISSIONS")
+
+	if v := os.Getenv("TENANT_REQUIRED"); v != "" {
+		required, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("TENANT_REQUIRED: %w", err))
+		} else {
+			c.Tenants.Required = required
+		}
+	}
+
+	list(&c.CORS.Healthcare, "CORS_HEALTHCARE_ORIGINS")
+	list(&c.CORS.Retail, "CORS_RETAIL_ORIGINS")
+	list(&c.CORS.Kiosk, "CORS_KIOSK_ORIGINS")
+	list(&c.CORS.Admin, "CORS_ADMIN_ORIGINS")
+	list(&c.CORS.Site, "CORS_SITE_ORIGINS")
+	duration(&c.CORS.MaxAge, "CORS_MAX_AGE")
+
+	str(&c.Observability.MetricsToken, "METRICS_TOKEN")
+	str(&c.Observability.TracesExporter, "OTEL_TRACES_EXPORTER")
+
+	if v := os.Getenv("KIOSK_PRICE_ROUND_TO"); v != "" {
+		roundTo, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("KIOSK_PRICE_ROUND_TO: %w", err))
+		} else {
+			c.Kiosk.Pricing.RoundTo = roundTo
+		}
+	}
+	if v := os.Getenv("POINTS_EARN_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("POINTS_EARN_RATE: %w", err))
+		} else {
+			c.Kiosk.Points.EarnRate = rate
+		}
+	}
+	if v := os.Getenv("POINTS_VALUE"); v != "" {
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("POINTS_VALUE: %w", err))
+		} else {
+			c.Kiosk.Points.Value = value
+		}
+	}
+	duration(&c.Kiosk.Points.Expiry, "POINTS_EXPIRY")
+	if v := os.Getenv("POINTS_REQUIRE_APPROVAL"); v != "" {
+		approval, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("POINTS_REQUIRE_APPROVAL: %w", err))
+		} else {
+			c.Kiosk.Points.RequireApproval = approval
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseKeys parses comma separated kid:secret pairs
+func parseKeys(raw string) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		kid, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || kid == "" || secret == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected kid:secret", pair)
+		}
+		keys[kid] = secret
+	}
+	return keys, nil
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minSecretLength is the shortest JWT secret accepted outside development
+const minSecretLength = 32
+
+// placeholderSecrets are the example secrets shipped in this repository's
+// compose files, scripts and .env.example
+var placeholderSecrets = []string{
+	"your-secret-key",
+	"DEV_JWT_SECRET_FOR_LOCAL_TESTING",
+}
+
+// Validate checks every setting and reports all problems at once
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if !slices.Contains([]string{Development, Staging, Production}, c.Environment) {
+		fail("APP_ENV: must be %s, %s or %s, got %q", Development, Staging, Production, c.Environment)
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		fail("PORT: must be a TCP port number, got %q", c.Server.Port)
+	}
+	positive := func(key string, d time.Duration) {
+		if d <= 0 {
+			fail("%s: must be positive, got %s", key, d)
+		}
+	}
+	positive("HTTP_READ_TIMEOUT", c.Server.ReadTimeout)
+	positive("HTTP_WRITE_TIMEOUT", c.Server.WriteTimeout)
+	positive("HTTP_IDLE_TIMEOUT", c.Server.IdleTimeout)
+	positive("SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout)
+	for _, proxy := range c.Server.TrustedProxies {
+		if err := checkProxy(proxy); err != nil {
+			fail("TRUSTED_PROXIES: %v", err)
+		}
+	}
+	if c.Server.MaxBodySize <= 0 {
+		fail("MAX_BODY_SIZE: must be positive, got %d", c.Server.MaxBodySize)
+	}
+
+	if !strings.HasPrefix(c.Mongo.URI, "mongodb://") && !strings.HasPrefix(c.Mongo.URI, "mongodb+srv://") {
+		fail("MONGO_URI: must start with mongodb:// or mongodb+srv://")
+	}
+	if c.Mongo.Database == "" {
+		fail("DB_NAME: is required")
+	}
+
+	if c.Uploads.Dir == "" {
+		fail("UPLOADS_DIR: is required")
+	}
+	if c.Uploads.MaxFileSize <= 0 {
+		fail("MAX_FILE_SIZE: must be positive, got %d", c.Uploads.MaxFileSize)
+	}
+
+	if c.Auth.JWT.KeyID == "" {
+		fail("JWT_KEY_ID: is required")
+	}
+	if _, ok := c.Auth.JWT.PreviousKeys[c.Auth.JWT.KeyID]; ok {
+		fail("JWT_PREVIOUS_KEYS: redefines active key %q", c.Auth.JWT.KeyID)
+	}
+	positive("JWT_TTL", c.Auth.JWT.TTL)
+	positive("REFRESH_TOKEN_TTL", c.Auth.RefreshTokenTTL)
+	if err := c.checkSecret("JWT_SECRET", c.Auth.JWT.Secret); err != nil {
+		errs = append(errs, err)
+	}
+	for kid, secret := range c.Auth.JWT.PreviousKeys {
+		if err := c.checkSecret(fmt.Sprintf("JWT_PREVIOUS_KEYS[%s]", kid), secret); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	origins := func(key string, list []string) {
+		for _, origin := range list {
+			if err := checkOrigin(origin); err != nil {
+				fail("%s: %v", key, err)
+			}
+		}
+	}
+	origins("CORS_HEALTHCARE_ORIGINS", c.CORS.Healthcare)
+	origins("CORS_RETAIL_ORIGINS", c.CORS.Retail)
+	origins("CORS_KIOSK_ORIGINS", c.CORS.Kiosk)
+	origins("CORS_ADMIN_ORIGINS", c.CORS.Admin)
+	origins("CORS_SITE_ORIGINS", c.CORS.Site)
+	if c.CORS.MaxAge < 0 {
+		fail("CORS_MAX_AGE: must not be negative, got %s", c.CORS.MaxAge)
+	}
+
+	switch c.Observability.TracesExporter {
+	case "", "none", "otlp", "stdout", "console":
+	default:
+		fail("OTEL_TRACES_EXPORTER: must be otlp, stdout or none, got %q", c.Observability.TracesExporter)
+	}
+
+	if c.Kiosk.Pricing.RoundTo <= 0 {
+		fail("KIOSK_PRICE_ROUND_TO: must be positive, got %g", c.Kiosk.Pricing.RoundTo)
+	}
+	for i, discount := range c.Kiosk.Pricing.Discounts {
+		if discount.Name == "" {
+			fail("kiosk.pricing.discounts[%d].name: is required", i)
+		}
+		if discount.Percent <= 0 || discount.Percent > 100 {
+			fail("kiosk.pricing.discounts[%d].percent: must be above 0 and at most 100, got %g", i, discount.Percent)
+		}
+		if discount.MinQuantity < 0 {
+			fail("kiosk.pricing.discounts[%d].min_quantity: must not be negative, got %d", i, discount.MinQuantity)
+		}
+	}
+
+	if c.Kiosk.Points.EarnRate < 0 {
+		fail("POINTS_EARN_RATE: must not be negative, got %g", c.Kiosk.Points.EarnRate)
+	}
+	for category, rate := range c.Kiosk.Points.CategoryRates {
+		if rate < 0 {
+			fail("kiosk.points.category_rates[%s]: must not be negative, got %g", category, rate)
+		}
+	}
+	if c.Kiosk.Points.Value <= 0 {
+		fail("POINTS_VALUE: must be positive, got %g", c.Kiosk.Points.Value)
+	}
+	if c.Kiosk.Points.Expiry < 0 {
+		fail("POINTS_EXPIRY: must not be negative, got %s", c.Kiosk.Points.Expiry)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// checkOrigin accepts a bare scheme://host[:port] origin. Wildcards are
+// refused so every allowed site is listed explicitly.
+func checkOrigin(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("%q is not an origin like https://example.com", origin)
+	}
+	if strings.Contains(u.Host, "*") {
+		return fmt.Errorf("wildcard origin %q is not allowed", origin)
+	}
+	return nil
+}
+
+// checkProxy accepts an IP address or CIDR range
+func checkProxy(proxy string) error {
+	if _, err := netip.ParsePrefix(proxy); err == nil {
+		return nil
+	}
+	if _, err := netip.ParseAddr(proxy); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%q is not an address or CIDR range", proxy)
+}
+
+// checkSecret refuses missing, short and placeholder secrets outside
+// development, where it only warns so local setups keep working
+func (c *Config) checkSecret(key, secret string) error {
+	var problem string
+	switch {
+	case secret == "":
+		problem = "is not set"
+	case isPlaceholder(secret):
+		problem = "is an example value"
+	case len(secret) < minSecretLength:
+		problem = fmt.Sprintf("is shorter than %d characters", minSecretLength)
+	default:
+		return nil
+	}
+
+	if c.Environment != Development {
+		return fmt.Errorf("%s: %s, refusing to start in %s", key, problem, c.Environment)
+	}
+	// An unset secret is reported by the signer, which falls back to an
+	// ephemeral key
+	if secret != "" {
+		log.Printf("Warning: %s %s; this is refused outside development", key, problem)
+	}
+	return nil
+}
+
+// isPlaceholder matches the example secrets, including the
+// "...change-in-production" family used across the deployment scripts
+func isPlaceholder(secret string) bool {
+	normalized := strings.ToLower(strings.ReplaceAll(secret, "_", "-"))
+	if strings.Contains(normalized, "change-in-production") {
+		return true
+	}
+	for _, placeholder := range placeholderSecrets {
+		if strings.EqualFold(secret, placeholder) {
+			return true
+		}
+	}
+	return false
+}
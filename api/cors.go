@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+
+	"isy-api/config"
+	"isy-api/observability"
+	"isy-api/tenant"
+)
+
+// corsPolicy returns middleware that lets browsers on origins call the routes
+// of one subrouter. Tokens travel in the Authorization header, never in
+// cookies, so credentialed requests are not allowed.
+func corsPolicy(origins []string, maxAge time.Duration) mux.MiddlewareFunc {
+	if len(origins) == 0 {
+		return denyCORS
+	}
+
+	c := cors.New(cors.Options{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders: []string{
+			"Authorization", "Content-Type", tenant.HeaderName,
+			observability.RequestIDHeader, "traceparent", "tracestate", "baggage",
+		},
+		ExposedHeaders: []string{observability.RequestIDHeader, "Retry-After"},
+		MaxAge:         int(maxAge.Seconds()),
+	})
+	return c.Handler
+}
+
+// denyCORS refuses preflight requests and adds no CORS headers to others, so
+// browsers block every cross-origin call
+func denyCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowPreflight gives OPTIONS requests a route in router, so its CORS
+// middleware runs for them instead of mux answering 405
+func allowPreflight(router *mux.Router) {
+	router.Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// sharedOrigins are the origins of the admin and auth routes, which every
+// front-end uses to sign in
+func sharedOrigins(cfg config.CORS) []string {
+	var origins []string
+	for _, list := range [][]string{cfg.Admin, cfg.Healthcare, cfg.Retail, cfg.Kiosk, cfg.Site} {
+		for _, origin := range list {
+			if !slices.Contains(origins, origin) {
+				origins = append(origins, origin)
+			}
+		}
+	}
+	return origins
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,6 +19,14 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+
+	"isy-api/mongodb"
+	"isy-api/observability"
+	"isy-api/response"
+	"isy-api/search"
+	"isy-api/tenant"
+	"isy-api/validate"
 )
 
 // SiteContent represents the structure of site content
@@ -26,43 +36,120 @@ type SiteContent struct {
 	UpdatedAt time.Time             `json:"updatedAt" bson:"updatedAt"`
 }
 
-// APIResponse represents a standard API response
-type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+// errNotReady is the readiness answer while a dependency is down; its
+// details list the state of every check
+var errNotReady = response.New(http.StatusServiceUnavailable, "not_ready", "Service not ready")
+
+// database returns the tenant database the request is bound to, or the
+// shared database
+func (a *App) database(r *http.Request) *mongo.Database {
+	return tenant.Database(r.Context(), a.DB)
 }
 
-// healthCheck handles health check requests
+// healthCheck handles health check requests. The API keeps serving without
+// MongoDB, so a missing connection is reported as degraded rather than down.
 func (a *App) healthCheck(w http.ResponseWriter, r *http.Request) {
-	response := APIResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"status":    "ok",
-			"timestamp": time.Now().Format(time.RFC3339),
-			"service":   "isy-api",
-		},
+	database := a.Mongo.Status()
+	status := "ok"
+	if database.State != mongodb.StateConnected {
+		status = "degraded"
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":    status,
+		"timestamp": time.Now().Format(time.RFC3339),
+		"service":   "isy-api",
+		"database":  database,
+	})
+}
+
+// liveness reports that the process is up and serving. It deliberately
+// checks nothing else so an orchestrator only restarts a wedged process.
+func (a *App) liveness(w http.ResponseWriter, r *http.Request) {
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// readiness reports whether this instance can take traffic: MongoDB answers
+// a ping and the uploads directory is writable
+func (a *App) readiness(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if client := a.Mongo.Client(); client == nil {
+		checks["mongodb"] = "not connected"
+		ready = false
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx, nil); err != nil {
+			checks["mongodb"] = "ping failed"
+			ready = false
+		} else {
+			checks["mongodb"] = "ok"
+		}
+	}
+
+	if err := checkWritable(a.Config.Uploads.Dir); err != nil {
+		log.Printf("Readiness: uploads directory %s not writable: %v", a.Config.Uploads.Dir, err)
+		checks["uploads"] = "not writable"
+		ready = false
+	} else {
+		checks["uploads"] = "ok"
+	}
+
+	body := map[string]interface{}{"ready": ready, "checks": checks}
+	if !ready {
+		response.WriteError(w, r, errNotReady.WithDetails(body))
+		return
+	}
+	response.JSON(w, r, http.StatusOK, body)
+}
+
+// reindexSearch creates the search indexes of the tenant's database and
+// recomputes the trigrams of every searchable document, for data imported
+// by other applications
+func (a *App) reindexSearch(w http.ResponseWriter, r *http.Request) {
+	if a.DB == nil {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+
+	db := a.database(r)
+	updated := map[string]int64{}
+	for _, ix := range search.Indexes {
+		if err := ix.EnsureIndexes(r.Context(), db); err != nil {
+			log.Printf("Warning: Failed to create search indexes on %s: %v", ix.Collection, err)
+		}
+		count, err := ix.Reindex(r.Context(), db)
+		if err != nil {
+			response.WriteError(w, r, response.Internal("Failed to reindex "+ix.Collection, err))
+			return
+		}
+		updated[ix.Collection] += count
+	}
+
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"updated": updated})
+}
+
+// checkWritable creates and removes a temporary file in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".ready-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
 }
 
 // getContent retrieves site content from MongoDB
 func (a *App) getContent(w http.ResponseWriter, r *http.Request) {
 	if a.DB == nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Database not available",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		json.NewEncoder(w).Encode(response)
+		response.WriteError(w, r, response.ErrUnavailable)
 		return
 	}
 
 	ctx := r.Context()
-	collection := a.DB.Collection("contents")
+	collection := a.database(r).Collection("contents")
 
 	var content SiteContent
 	err := collection.FindOne(ctx, bson.M{"type": "site"}).Decode(&content)
@@ -70,46 +157,29 @@ func (a *App) getContent(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			// Return default content if none exists
-			response := APIResponse{
-				Success: true,
-				Data: map[string]interface{}{
-					"type": "site",
-					"content": getDefaultContent(),
-				},
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
+			response.JSON(w, r, http.StatusOK, map[string]interface{}{
+				"type": "site",
+				"content": getDefaultContent(),
+			})
 			return
 		}
-		response := APIResponse{
-			Success: false,
-			Error:   "Failed to fetch content",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to fetch content")
 		return
 	}
 
-	response := APIResponse{
-		Success: true,
-		Data:    content,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	response.JSON(w, r, http.StatusOK, content)
 }
 
+// contentSchema is the body of saveContent. The site content is edited
+// freely by the front-end, so only its keys are checked.
+var contentSchema = validate.Schema{Fields: []validate.Field{
+	{Name: "content", Type: validate.Object, Required: true, Open: true},
+}}
+
 // saveContent saves site content to MongoDB
 func (a *App) saveContent(w http.ResponseWriter, r *http.Request) {
 	if a.DB == nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Database not available",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		json.NewEncoder(w).Encode(response)
+		response.WriteError(w, r, response.ErrUnavailable)
 		return
 	}
 
@@ -119,29 +189,12 @@ func (a *App) saveContent(w http.ResponseWriter, r *http.Request) {
 		Content map[string]interface{} `json:"content"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Invalid JSON",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	if requestData.Content == nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Content is required",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+	if err := validate.DecodeInto(r, contentSchema, &requestData); err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
-	collection := a.DB.Collection("contents")
+	collection := a.database(r).Collection("contents")
 
 	content := SiteContent{
 		Type:      "site",
@@ -157,48 +210,74 @@ func (a *App) saveContent(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err != nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Failed to save content",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to save content")
 		return
 	}
 
-	response := APIResponse{
-		Success: true,
-		Data:    map[string]string{"message": "Content saved successfully"},
+	response.JSON(w, r, http.StatusOK, map[string]string{"message": "Content saved successfully"})
+}
+
+// uploadDir returns the folder holding the uploads of the tenant resolved
+// on ctx. The shared database keeps the top folder, where files were stored
+// before tenants existed; every tenant has its own folder below tenants/.
+func (a *App) uploadDir(ctx context.Context) string {
+	id := tenant.ID(ctx)
+	if id == "" {
+		return a.Config.Uploads.Dir
+	}
+	return filepath.Join(a.Config.Uploads.Dir, "tenants", id)
+}
+
+// uploadPath joins name to dir. It refuses anything but a plain file name,
+// so no name reaches outside dir or into the tenant folders below it.
+func uploadPath(dir, name string) (string, bool) {
+	if name == "" || name != filepath.Base(name) || !filepath.IsLocal(name) {
+		return "", false
 	}
+	return filepath.Join(dir, name), true
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// serveUpload serves a file from the uploads of the tenant the request
+// resolves to
+func (a *App) serveUpload(w http.ResponseWriter, r *http.Request) {
+	filePath, ok := uploadPath(a.uploadDir(r.Context()), strings.TrimPrefix(r.URL.Path, "/uploads/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
 }
 
 // uploadFile handles file uploads
 func (a *App) uploadFile(w http.ResponseWriter, r *http.Request) {
-	// Parse multipart form with max 20MB
-	if err := r.ParseMultipartForm(20 << 20); err != nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "File too large (max 20MB) or invalid form data",
+	// Parse multipart form, capping the body at the configured file size
+	maxSize := a.Config.Uploads.MaxFileSize
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	if err := r.ParseMultipartForm(maxSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			response.Fail(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("File too large (max %dMB)", maxSize>>20))
+			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusBadRequest, "Invalid form data")
 		return
 	}
 
 	file, handler, err := r.FormFile("file")
 	if err != nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "No file provided",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusBadRequest, "No file provided")
 		return
 	}
 	defer file.Close()
@@ -206,13 +285,7 @@ func (a *App) uploadFile(w http.ResponseWriter, r *http.Request) {
 	// Validate file type (images only)
 	contentType := handler.Header.Get("Content-Type")
 	if !strings.HasPrefix(contentType, "image/") {
-		response := APIResponse{
-			Success: false,
-			Error:   "Only image files are allowed",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusBadRequest, "Only image files are allowed")
 		return
 	}
 
@@ -222,16 +295,14 @@ func (a *App) uploadFile(w http.ResponseWriter, r *http.Request) {
 	rand.Read(randomBytes)
 	uniqueFilename := hex.EncodeToString(randomBytes) + ext
 
+	// Trace the file system work apart from the rest of the request
+	_, span := observability.StartSpan(r.Context(), "uploads.save", attribute.String("file.name", uniqueFilename))
+	defer span.End()
+
 	// Ensure uploads directory exists
-	uploadDir := "/root/uploads"
+	uploadDir := a.uploadDir(r.Context())
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Failed to create upload directory",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to create upload directory")
 		return
 	}
 
@@ -239,44 +310,29 @@ func (a *App) uploadFile(w http.ResponseWriter, r *http.Request) {
 	filePath := filepath.Join(uploadDir, uniqueFilename)
 	dst, err := os.Create(filePath)
 	if err != nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Failed to save file",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to save file")
 		return
 	}
 	defer dst.Close()
 
 	// Copy file content
-	if _, err := io.Copy(dst, file); err != nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Failed to save file",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+	written, err := io.Copy(dst, file)
+	if err != nil {
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to save file")
 		return
 	}
+	span.SetAttributes(attribute.Int64("file.size", written))
+	observability.ObserveUpload("upload", written)
 
 	// Return the public URL
 	publicURL := fmt.Sprintf("/uploads/%s", uniqueFilename)
 
-	response := APIResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"url":      publicURL,
-			"filename": handler.Filename,
-			"size":     handler.Size,
-			"type":     contentType,
-		},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{
+		"url":      publicURL,
+		"filename": handler.Filename,
+		"size":     handler.Size,
+		"type":     contentType,
+	})
 }
 
 // deleteFile handles file deletion
@@ -285,80 +341,48 @@ func (a *App) deleteFile(w http.ResponseWriter, r *http.Request) {
 	filename := vars["filename"]
 
 	if filename == "" {
-		response := APIResponse{
-			Success: false,
-			Error:   "Filename is required",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusBadRequest, "Filename is required")
 		return
 	}
 
-	// Sanitize filename to prevent directory traversal
-	filename = filepath.Base(filename)
-
-	// Delete file
-	uploadDir := "/root/uploads"
-	filePath := filepath.Join(uploadDir, filename)
+	// Only plain names inside the tenant's own folder can be deleted
+	filePath, ok := uploadPath(a.uploadDir(r.Context()), filename)
+	if !ok {
+		response.Fail(w, r, http.StatusBadRequest, "Invalid filename")
+		return
+	}
+	if info, err := os.Lstat(filePath); err == nil && !info.Mode().IsRegular() {
+		response.Fail(w, r, http.StatusNotFound, "File not found")
+		return
+	}
 
 	if err := os.Remove(filePath); err != nil {
 		if os.IsNotExist(err) {
-			response := APIResponse{
-				Success: false,
-				Error:   "File not found",
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(response)
+			response.Fail(w, r, http.StatusNotFound, "File not found")
 			return
 		}
 
-		response := APIResponse{
-			Success: false,
-			Error:   "Failed to delete file",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to delete file")
 		return
 	}
 
-	response := APIResponse{
-		Success: true,
-		Data:    map[string]string{"message": "File deleted successfully"},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	response.JSON(w, r, http.StatusOK, map[string]string{"message": "File deleted successfully"})
 }
 
 // migrateBase64ToFiles migrates base64 images in metadata to file storage
 func (a *App) migrateBase64ToFiles(w http.ResponseWriter, r *http.Request) {
 	if a.DB == nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Database not available",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		json.NewEncoder(w).Encode(response)
+		response.WriteError(w, r, response.ErrUnavailable)
 		return
 	}
 
 	ctx := r.Context()
-	collection := a.DB.Collection("metadata")
+	collection := a.database(r).Collection("metadata")
 
 	// Find metadata with base64 images
 	cursor, err := collection.Find(ctx, bson.M{})
 	if err != nil {
-		response := APIResponse{
-			Success: false,
-			Error:   "Failed to fetch metadata",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		response.Fail(w, r, http.StatusInternalServerError, "Failed to fetch metadata")
 		return
 	}
 	defer cursor.Close(ctx)
@@ -369,7 +393,7 @@ func (a *App) migrateBase64ToFiles(w http.ResponseWriter, r *http.Request) {
 		"errors":    []string{},
 	}
 
-	uploadDir := "/root/uploads"
+	uploadDir := a.uploadDir(ctx)
 	os.MkdirAll(uploadDir, 0755)
 
 	for cursor.Next(ctx) {
@@ -427,6 +451,7 @@ func (a *App) migrateBase64ToFiles(w http.ResponseWriter, r *http.Request) {
 								fmt.Sprintf("Failed to save file for %s: %v", key, err))
 							continue
 						}
+						observability.ObserveUpload("migration", int64(len(imageData)))
 
 						// Update with public URL
 						images[key] = fmt.Sprintf("/uploads/%s", filename)
@@ -456,6 +481,7 @@ func (a *App) migrateBase64ToFiles(w http.ResponseWriter, r *http.Request) {
 									filePath := filepath.Join(uploadDir, filename)
 
 									if err := os.WriteFile(filePath, imageData, 0644); err == nil {
+										observability.ObserveUpload("migration", int64(len(imageData)))
 										featMap["image"] = fmt.Sprintf("/uploads/%s", filename)
 										updated = true
 									}
@@ -487,6 +513,7 @@ func (a *App) migrateBase64ToFiles(w http.ResponseWriter, r *http.Request) {
 									filePath := filepath.Join(uploadDir, filename)
 
 									if err := os.WriteFile(filePath, imageData, 0644); err == nil {
+										observability.ObserveUpload("migration", int64(len(imageData)))
 										prodMap["image"] = fmt.Sprintf("/uploads/%s", filename)
 										updated = true
 									}
@@ -510,13 +537,7 @@ func (a *App) migrateBase64ToFiles(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	response := APIResponse{
-		Success: true,
-		Data:    migrationStats,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	response.JSON(w, r, http.StatusOK, migrationStats)
 }
 
 // getDefaultContent returns default site content
@@ -1,239 +1,210 @@
 package healthcare
 
 import (
-	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"isy-api/listing"
+	"isy-api/response"
+	"isy-api/search"
+	"isy-api/validate"
 )
 
-// APIResponse represents a standard API response
-type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
-}
+// List parameters clients may use on each collection
+var (
+	patientListing = listing.Spec{Hidden: []string{search.GramsField}, Fields: []listing.Field{
+		{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+		{Name: "patientId", Type: listing.String, Sortable: true},
+		{Name: "firstName", Type: listing.String, Sortable: true},
+		{Name: "lastName", Type: listing.String, Sortable: true},
+		{Name: "email", Type: listing.String},
+		{Name: "phoneNumber", Type: listing.String},
+		{Name: "gender", Type: listing.String},
+		{Name: "isActive", Type: listing.Bool},
+		{Name: "createdAt", Type: listing.Time, Sortable: true},
+		{Name: "updatedAt", Type: listing.Time, Sortable: true},
+	}}
+	appointmentListing = listing.Spec{Fields: []listing.Field{
+		{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+		{Name: "patientId", Type: listing.ObjectID},
+		{Name: "status", Type: listing.String},
+		{Name: "type", Type: listing.String},
+		{Name: "appointmentDate", Type: listing.Time, Sortable: true},
+		{Name: "createdAt", Type: listing.Time, Sortable: true},
+	}}
+	medicalRecordListing = listing.Spec{
+		Fields: []listing.Field{
+			{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+			{Name: "patientId", Type: listing.ObjectID},
+			{Name: "createdAt", Type: listing.Time, Sortable: true},
+			{Name: "updatedAt", Type: listing.Time, Sortable: true},
+		},
+		// Clinicians read the latest records first
+		DefaultSort: "-createdAt",
+	}
+)
 
 // HealthcareHandlers contains all healthcare-related handlers
 type HealthcareHandlers struct {
-	DB *mongo.Database
+	Patients       PatientRepository
+	Appointments   AppointmentRepository
+	MedicalRecords MedicalRecordRepository
 }
 
-// NewHealthcareHandlers creates a new healthcare handlers instance
+// NewHealthcareHandlers creates a new healthcare handlers instance backed by MongoDB
 func NewHealthcareHandlers(db *mongo.Database) *HealthcareHandlers {
-	return &HealthcareHandlers{DB: db}
+	return &HealthcareHandlers{
+		Patients:       NewMongoPatientRepository(db),
+		Appointments:   NewMongoAppointmentRepository(db),
+		MedicalRecords: NewMongoMedicalRecordRepository(db),
+	}
 }
 
-// GetPatients retrieves all patients
+// NewMemoryHealthcareHandlers creates handlers on empty memory repositories,
+// for tests and local development without MongoDB
+func NewMemoryHealthcareHandlers() *HealthcareHandlers {
+	return &HealthcareHandlers{
+		Patients:       NewMemoryPatientRepository(),
+		Appointments:   NewMemoryAppointmentRepository(),
+		MedicalRecords: NewMemoryMedicalRecordRepository(),
+	}
+}
+
+// GetPatients lists patients a page at a time
 func (h *HealthcareHandlers) GetPatients(w http.ResponseWriter, r *http.Request) {
-	if h.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
+	query, err := listing.Parse(r, patientListing)
+	if err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	result, err := h.Patients.List(r.Context(), query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch patients")
 		return
 	}
 
-	ctx := r.Context()
-	collection := h.DB.Collection("patients")
+	response.List(w, r, result.Items, result.Page())
+}
 
-	cursor, err := collection.Find(ctx, bson.M{})
+// SearchPatients finds patients by name, patient ID, phone number or email
+func (h *HealthcareHandlers) SearchPatients(w http.ResponseWriter, r *http.Request) {
+	query, limit, err := search.Parse(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch patients")
+		response.WriteError(w, r, err)
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var patients []bson.M
-	if err := cursor.All(ctx, &patients); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to parse patients")
+	hits, err := h.Patients.Search(r.Context(), query, limit)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Search failed")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    patients,
-	})
+	response.JSON(w, r, http.StatusOK, hits)
 }
 
 // CreatePatient creates a new patient
 func (h *HealthcareHandlers) CreatePatient(w http.ResponseWriter, r *http.Request) {
-	if h.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	var patient map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&patient); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	patient, err := validate.Decode(r, patientSchema)
+	if err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
 	patient["createdAt"] = time.Now()
 	patient["updatedAt"] = time.Now()
 
-	ctx := r.Context()
-	collection := h.DB.Collection("patients")
-
-	result, err := collection.InsertOne(ctx, patient)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create patient")
+	if err := h.Patients.Create(r.Context(), patient); err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to create patient")
 		return
 	}
 
-	patient["_id"] = result.InsertedID
-
-	respondWithJSON(w, http.StatusCreated, APIResponse{
-		Success: true,
-		Data:    patient,
-	})
+	response.JSON(w, r, http.StatusCreated, patient)
 }
 
-// GetAppointments retrieves appointments
+// GetAppointments lists appointments a page at a time
 func (h *HealthcareHandlers) GetAppointments(w http.ResponseWriter, r *http.Request) {
-	if h.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	ctx := r.Context()
-	collection := h.DB.Collection("appointments")
-
-	cursor, err := collection.Find(ctx, bson.M{})
+	query, err := listing.Parse(r, appointmentListing)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch appointments")
+		response.WriteError(w, r, err)
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var appointments []bson.M
-	if err := cursor.All(ctx, &appointments); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to parse appointments")
+	result, err := h.Appointments.List(r.Context(), query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch appointments")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    appointments,
-	})
+	response.List(w, r, result.Items, result.Page())
 }
 
 // CreateAppointment creates a new appointment
 func (h *HealthcareHandlers) CreateAppointment(w http.ResponseWriter, r *http.Request) {
-	if h.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	var appointment map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&appointment); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	appointment, err := validate.Decode(r, appointmentSchema)
+	if err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
 	appointment["createdAt"] = time.Now()
 	appointment["updatedAt"] = time.Now()
 
-	ctx := r.Context()
-	collection := h.DB.Collection("appointments")
-
-	result, err := collection.InsertOne(ctx, appointment)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create appointment")
+	if err := h.Appointments.Create(r.Context(), appointment); err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to create appointment")
 		return
 	}
 
-	appointment["_id"] = result.InsertedID
-
-	respondWithJSON(w, http.StatusCreated, APIResponse{
-		Success: true,
-		Data:    appointment,
-	})
+	response.JSON(w, r, http.StatusCreated, appointment)
 }
 
-// GetMedicalRecords retrieves medical records
+// GetMedicalRecords lists medical records, newest first unless sorted
+// otherwise. Filter by patient with ?patientId=.
 func (h *HealthcareHandlers) GetMedicalRecords(w http.ResponseWriter, r *http.Request) {
-	if h.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	// Get patient ID from query params
-	patientID := r.URL.Query().Get("patientId")
-	
-	ctx := r.Context()
-	collection := h.DB.Collection("medicalrecords")
-
-	filter := bson.M{}
-	if patientID != "" {
-		objID, err := primitive.ObjectIDFromHex(patientID)
-		if err == nil {
-			filter["patientId"] = objID
-		}
-	}
-
-	cursor, err := collection.Find(ctx, filter)
+	query, err := listing.Parse(r, medicalRecordListing)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch medical records")
+		response.WriteError(w, r, err)
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var records []bson.M
-	if err := cursor.All(ctx, &records); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to parse medical records")
+	result, err := h.MedicalRecords.List(r.Context(), query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch medical records")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    records,
-	})
+	response.List(w, r, result.Items, result.Page())
 }
 
 // CreateMedicalRecord creates a new medical record
 func (h *HealthcareHandlers) CreateMedicalRecord(w http.ResponseWriter, r *http.Request) {
-	if h.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	var record map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	record, err := validate.Decode(r, medicalRecordSchema)
+	if err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
 	record["createdAt"] = time.Now()
 	record["updatedAt"] = time.Now()
 
-	ctx := r.Context()
-	collection := h.DB.Collection("medicalrecords")
-
-	result, err := collection.InsertOne(ctx, record)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create medical record")
+	if err := h.MedicalRecords.Create(r.Context(), record); err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to create medical record")
 		return
 	}
 
-	record["_id"] = result.InsertedID
-
-	respondWithJSON(w, http.StatusCreated, APIResponse{
-		Success: true,
-		Data:    record,
-	})
-}
-
-// Helper functions
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, APIResponse{
-		Success: false,
-		Error:   message,
-	})
+	response.JSON(w, r, http.StatusCreated, record)
 }
 
-func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(payload)
+// respondWithRepositoryError reports err from a repository, using message
+// for unexpected failures
+func respondWithRepositoryError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, ErrUnavailable) {
+		response.WriteError(w, r, response.ErrUnavailable)
+		return
+	}
+	response.WriteError(w, r, response.Internal(message, err))
 }
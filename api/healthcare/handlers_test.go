@@ -0,0 +1,111 @@
+package healthcare
+
+import (
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/apitest"
+)
+
+const testPatient = `{"firstName":"Ana","lastName":"Putri","phoneNumber":"+62 812 3456 7890"}`
+
+func testAppointment() string {
+	return `{"patientId":"` + primitive.NewObjectID().Hex() + `","appointmentDate":"2026-01-05T09:00:00Z","type":"checkup"}`
+}
+
+func TestCreateAndListPatients(t *testing.T) {
+	h := NewMemoryHealthcareHandlers()
+
+	status, body := apitest.Serve(t, h.CreatePatient, apitest.Request("POST", "/patients", testPatient))
+	if status != http.StatusCreated {
+		t.Fatalf("CreatePatient = %d %s", status, body.Code)
+	}
+
+	status, body = apitest.Serve(t, h.GetPatients, apitest.Request("GET", "/patients", ""))
+	if status != http.StatusOK || body.Count(t) != 1 || body.Page.Total != 1 {
+		t.Fatalf("GetPatients = %d %s", status, body.Data)
+	}
+
+	for _, q := range []string{"putri", "Putri", "ana", "81234567890"} {
+		status, body = apitest.Serve(t, h.SearchPatients, apitest.Request("GET", "/search?q="+q, ""))
+		if status != http.StatusOK || body.Count(t) != 1 {
+			t.Errorf("SearchPatients(%s) = %d %s", q, status, body.Data)
+		}
+	}
+}
+
+func TestPatientsPerTenant(t *testing.T) {
+	h := NewMemoryHealthcareHandlers()
+	if status, body := apitest.Serve(t, h.CreatePatient, apitest.ForTenant(apitest.Request("POST", "/patients", testPatient), "north")); status != http.StatusCreated {
+		t.Fatalf("CreatePatient = %d %s", status, body.Code)
+	}
+
+	for tenantID, want := range map[string]int{"north": 1, "south": 0, "": 0} {
+		if _, body := apitest.Serve(t, h.GetPatients, apitest.ForTenant(apitest.Request("GET", "/patients", ""), tenantID)); body.Count(t) != want {
+			t.Errorf("tenant %q patients = %s, want %d", tenantID, body.Data, want)
+		}
+		if _, body := apitest.Serve(t, h.SearchPatients, apitest.ForTenant(apitest.Request("GET", "/search?q=putri", ""), tenantID)); body.Count(t) != want {
+			t.Errorf("tenant %q search = %s, want %d hits", tenantID, body.Data, want)
+		}
+	}
+}
+
+func TestAppointmentsPerTenant(t *testing.T) {
+	h := NewMemoryHealthcareHandlers()
+	if status, body := apitest.Serve(t, h.CreateAppointment, apitest.ForTenant(apitest.Request("POST", "/appointments", testAppointment()), "north")); status != http.StatusCreated {
+		t.Fatalf("CreateAppointment = %d %s", status, body.Code)
+	}
+
+	for tenantID, want := range map[string]int{"north": 1, "south": 0, "": 0} {
+		if _, body := apitest.Serve(t, h.GetAppointments, apitest.ForTenant(apitest.Request("GET", "/appointments", ""), tenantID)); body.Count(t) != want {
+			t.Errorf("tenant %q appointments = %s, want %d", tenantID, body.Data, want)
+		}
+	}
+}
+
+func TestMedicalRecordsByPatient(t *testing.T) {
+	h := NewMemoryHealthcareHandlers()
+	patients := []string{primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()}
+	for _, patient := range append(patients, patients[0]) {
+		record := `{"patientId":"` + patient + `","chiefComplaint":"Cough"}`
+		if status, body := apitest.Serve(t, h.CreateMedicalRecord, apitest.Request("POST", "/medical-records", record)); status != http.StatusCreated {
+			t.Fatalf("CreateMedicalRecord = %d %s", status, body.Code)
+		}
+	}
+
+	for patient, want := range map[string]int{patients[0]: 2, patients[1]: 1, primitive.NewObjectID().Hex(): 0} {
+		status, body := apitest.Serve(t, h.GetMedicalRecords, apitest.Request("GET", "/medical-records?patientId="+patient, ""))
+		if status != http.StatusOK || body.Count(t) != want {
+			t.Errorf("GetMedicalRecords(%s) = %d %s, want %d records", patient, status, body.Data, want)
+		}
+	}
+}
+
+func TestCreateRejectsInvalidBody(t *testing.T) {
+	h := NewMemoryHealthcareHandlers()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"patient without name", h.CreatePatient, `{"firstName":"Ana"}`},
+		{"appointment without patient", h.CreateAppointment, `{"appointmentDate":"2026-01-05T09:00:00Z","type":"checkup"}`},
+		{"appointment of unknown type", h.CreateAppointment, `{"patientId":"` + primitive.NewObjectID().Hex() + `","appointmentDate":"2026-01-05T09:00:00Z","type":"surgery"}`},
+		{"record with malformed patient", h.CreateMedicalRecord, `{"patientId":"nope"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := apitest.Serve(t, tt.handler, apitest.Request("POST", "/", tt.body)); status != http.StatusBadRequest {
+				t.Errorf("status = %d %s, want 400", status, body.Code)
+			}
+		})
+	}
+
+	// Nothing was stored
+	if _, body := apitest.Serve(t, h.GetAppointments, apitest.Request("GET", "/appointments", "")); body.Count(t) != 0 {
+		t.Errorf("GetAppointments = %s, want none", body.Data)
+	}
+}
@@ -0,0 +1,89 @@
+package healthcare
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/listing"
+	"isy-api/search"
+	"isy-api/tenant"
+)
+
+// memoryDocuments keeps documents in process memory, in insertion order and
+// apart per tenant
+type memoryDocuments struct {
+	mu        sync.RWMutex
+	documents map[string][]bson.M
+}
+
+func (docs *memoryDocuments) List(ctx context.Context, query listing.Query) (listing.Result[bson.M], error) {
+	docs.mu.RLock()
+	defer docs.mu.RUnlock()
+
+	result, err := listing.Apply(docs.documents[tenant.ID(ctx)], query)
+	for i, document := range result.Items {
+		result.Items[i] = maps.Clone(document)
+	}
+	return result, err
+}
+
+func (docs *memoryDocuments) Create(ctx context.Context, document bson.M) error {
+	docs.mu.Lock()
+	defer docs.mu.Unlock()
+
+	if _, ok := document["_id"]; !ok {
+		document["_id"] = primitive.NewObjectID()
+	}
+	if docs.documents == nil {
+		docs.documents = map[string][]bson.M{}
+	}
+	key := tenant.ID(ctx)
+	docs.documents[key] = append(docs.documents[key], maps.Clone(document))
+	return nil
+}
+
+// MemoryPatientRepository keeps patients in process memory. It is meant for
+// tests and local development without MongoDB.
+type MemoryPatientRepository struct {
+	memoryDocuments
+}
+
+// NewMemoryPatientRepository creates an empty patient repository
+func NewMemoryPatientRepository() *MemoryPatientRepository {
+	return &MemoryPatientRepository{}
+}
+
+func (repo *MemoryPatientRepository) Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	hits, err := search.Rank(search.Patients, query, repo.documents[tenant.ID(ctx)], limit)
+	for i := range hits {
+		hits[i].Item = maps.Clone(hits[i].Item.(bson.M))
+	}
+	return hits, err
+}
+
+// MemoryAppointmentRepository keeps appointments in process memory
+type MemoryAppointmentRepository struct {
+	memoryDocuments
+}
+
+// NewMemoryAppointmentRepository creates an empty appointment repository
+func NewMemoryAppointmentRepository() *MemoryAppointmentRepository {
+	return &MemoryAppointmentRepository{}
+}
+
+// MemoryMedicalRecordRepository keeps medical records in process memory
+type MemoryMedicalRecordRepository struct {
+	memoryDocuments
+}
+
+// NewMemoryMedicalRecordRepository creates an empty medical record repository
+func NewMemoryMedicalRecordRepository() *MemoryMedicalRecordRepository {
+	return &MemoryMedicalRecordRepository{}
+}
@@ -0,0 +1,117 @@
+package healthcare
+
+import (
+	"context"
+	"errors"
+	"maps"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"isy-api/listing"
+	"isy-api/search"
+	"isy-api/tenant"
+)
+
+// ErrUnavailable is returned when the database is not connected
+var ErrUnavailable = errors.New("database not available")
+
+// PatientRepository stores patient records
+type PatientRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[bson.M], error)
+	Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error)
+	// Create stores patient and sets its "_id"
+	Create(ctx context.Context, patient bson.M) error
+}
+
+// AppointmentRepository stores appointments
+type AppointmentRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[bson.M], error)
+	Create(ctx context.Context, appointment bson.M) error
+}
+
+// MedicalRecordRepository stores medical records
+type MedicalRecordRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[bson.M], error)
+	Create(ctx context.Context, record bson.M) error
+}
+
+// mongoDocuments implements the repositories on top of one collection of the
+// clinic database bound to the request context
+type mongoDocuments struct {
+	db         *mongo.Database
+	collection string
+	// search is set for searchable collections, whose documents are stored
+	// with their trigrams
+	search *search.Index
+}
+
+func (docs mongoDocuments) List(ctx context.Context, query listing.Query) (listing.Result[bson.M], error) {
+	db := tenant.Database(ctx, docs.db)
+	if db == nil {
+		return listing.Result[bson.M]{}, ErrUnavailable
+	}
+	return listing.Find[bson.M](ctx, db.Collection(docs.collection), query)
+}
+
+func (docs mongoDocuments) Create(ctx context.Context, document bson.M) error {
+	db := tenant.Database(ctx, docs.db)
+	if db == nil {
+		return ErrUnavailable
+	}
+
+	stored := document
+	if docs.search != nil {
+		grams, err := docs.search.Grams(document)
+		if err != nil {
+			return err
+		}
+		stored = maps.Clone(document)
+		stored[search.GramsField] = grams
+	}
+
+	result, err := db.Collection(docs.collection).InsertOne(ctx, stored)
+	if err != nil {
+		return err
+	}
+	document["_id"] = result.InsertedID
+	return nil
+}
+
+// MongoPatientRepository stores patients in the patients collection
+type MongoPatientRepository struct {
+	mongoDocuments
+}
+
+// NewMongoPatientRepository creates a patient repository backed by db
+func NewMongoPatientRepository(db *mongo.Database) *MongoPatientRepository {
+	return &MongoPatientRepository{mongoDocuments{db: db, collection: "patients", search: &search.Patients}}
+}
+
+func (repo *MongoPatientRepository) Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error) {
+	db := tenant.Database(ctx, repo.db)
+	if db == nil {
+		return nil, ErrUnavailable
+	}
+	return search.Find[bson.M](ctx, db.Collection(repo.collection), search.Patients, query, limit)
+}
+
+// MongoAppointmentRepository stores appointments in the appointments collection
+type MongoAppointmentRepository struct {
+	mongoDocuments
+}
+
+// NewMongoAppointmentRepository creates an appointment repository backed by db
+func NewMongoAppointmentRepository(db *mongo.Database) *MongoAppointmentRepository {
+	return &MongoAppointmentRepository{mongoDocuments{db: db, collection: "appointments"}}
+}
+
+// MongoMedicalRecordRepository stores records in the medicalrecords collection
+type MongoMedicalRecordRepository struct {
+	mongoDocuments
+}
+
+// NewMongoMedicalRecordRepository creates a medical record repository backed by db
+func NewMongoMedicalRecordRepository(db *mongo.Database) *MongoMedicalRecordRepository {
+	return &MongoMedicalRecordRepository{mongoDocuments{db: db, collection: "medicalrecords"}}
+}
@@ -0,0 +1,137 @@
+package healthcare
+
+import "isy-api/validate"
+
+// Shared field shapes
+var (
+	nameText     = validate.Field{Type: validate.String, MaxLength: 100}
+	shortText    = validate.Field{Type: validate.String, MaxLength: 200}
+	clinicalText = validate.Field{Type: validate.String, MaxLength: 10000}
+	fileURL      = validate.Field{Type: validate.String, MaxLength: 2048, Format: validate.URL}
+	phone        = validate.Field{Type: validate.String, MaxLength: 30, Format: validate.Phone}
+)
+
+// Bodies of the create endpoints. IDs of other documents are stored as
+// ObjectIds and dates as BSON dates so the list filters match them.
+var (
+	patientSchema = validate.Schema{Fields: []validate.Field{
+		{Name: "patientId", Type: validate.String, MaxLength: 40},
+		nameText.Named("firstName").Require(),
+		nameText.Named("lastName").Require(),
+		{Name: "dateOfBirth", Type: validate.Time},
+		{Name: "gender", Type: validate.String, Enum: []string{"Male", "Female", "Other", "male", "female", "other"}},
+		{Name: "email", Type: validate.String, MaxLength: 254, Format: validate.Email},
+		phone.Named("phoneNumber"),
+		{Name: "address", Type: validate.Object, Fields: []validate.Field{
+			shortText.Named("street"),
+			nameText.Named("city"),
+			nameText.Named("state"),
+			nameText.Named("country"),
+			{Name: "postalCode", Type: validate.String, MaxLength: 20},
+		}},
+		{Name: "emergencyContact", Type: validate.Object, Fields: []validate.Field{
+			nameText.Named("name"),
+			nameText.Named("relationship"),
+			phone.Named("phoneNumber"),
+		}},
+		{Name: "insuranceDetails", Type: validate.Object, Fields: []validate.Field{
+			{Name: "providerId", Type: validate.ObjectID},
+			nameText.Named("provider"),
+			nameText.Named("policyNumber"),
+			nameText.Named("groupNumber"),
+			{Name: "expiryDate", Type: validate.Time},
+			{Name: "copayAmount", Type: validate.Number, Min: validate.Limit(0)},
+			{Name: "copayPercentage", Type: validate.Number, Min: validate.Limit(0), Max: validate.Limit(100)},
+		}},
+		fileURL.Named("photo"),
+		fileURL.Named("passportScan"),
+		{Name: "category", Type: validate.String, Enum: []string{"Local", "Local_Insurance", "Tourist", "Tourist_Insurance"}},
+		{Name: "primaryClinic", Type: validate.ObjectID},
+		{Name: "visitedClinics", Type: validate.Array, MaxLength: 100, Items: &validate.Field{Type: validate.ObjectID}},
+		{Name: "isActive", Type: validate.Bool},
+		{Name: "medicalAlerts", Type: validate.Object, Fields: []validate.Field{
+			{Name: "allergies", Type: validate.Array, MaxLength: 100, Items: &shortText},
+			{Name: "chronicConditions", Type: validate.Array, MaxLength: 100, Items: &shortText},
+			{Name: "currentMedications", Type: validate.Array, MaxLength: 100, Items: &shortText},
+		}},
+	}}
+
+	appointmentSchema = validate.Schema{Fields: []validate.Field{
+		{Name: "appointmentId", Type: validate.String, MaxLength: 40},
+		{Name: "patientId", Type: validate.ObjectID, Required: true},
+		{Name: "practitionerId", Type: validate.ObjectID},
+		{Name: "clinicId", Type: validate.ObjectID},
+		{Name: "appointmentDate", Type: validate.Time, Required: true},
+		{Name: "startTime", Type: validate.String, Format: validate.Clock},
+		{Name: "endTime", Type: validate.String, Format: validate.Clock},
+		{Name: "duration", Type: validate.Integer, Min: validate.Limit(15), Max: validate.Limit(480)},
+		{Name: "type", Type: validate.String, Required: true, Enum: []string{"consultation", "follow-up", "procedure", "checkup", "emergency"}},
+		{Name: "status", Type: validate.String, Enum: []string{"scheduled", "confirmed", "checked-in", "in-progress", "completed", "cancelled", "no-show"}},
+		{Name: "reason", Type: validate.String, MaxLength: 500},
+		{Name: "notes", Type: validate.String, MaxLength: 5000},
+	}}
+
+	medicalRecordSchema = validate.Schema{Fields: []validate.Field{
+		{Name: "patientId", Type: validate.ObjectID, Required: true},
+		{Name: "clinicId", Type: validate.ObjectID},
+		{Name: "appointmentId", Type: validate.ObjectID},
+		clinicalText.Named("chiefComplaint"),
+		clinicalText.Named("medicalHistory"),
+		clinicalText.Named("familyHistory"),
+		clinicalText.Named("socialHistory"),
+		clinicalText.Named("reviewOfSystems"),
+		{Name: "soapNotes", Type: validate.Array, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+			clinicalText.Named("subjective").Require(),
+			clinicalText.Named("objective").Require(),
+			clinicalText.Named("assessment").Require(),
+			clinicalText.Named("plan").Require(),
+		}}},
+		// Physiological ranges; anything outside is a typo
+		{Name: "vitals", Type: validate.Array, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+			{Name: "temperature", Type: validate.Number, Min: validate.Limit(30), Max: validate.Limit(45)},
+			{Name: "bloodPressureSystolic", Type: validate.Number, Min: validate.Limit(60), Max: validate.Limit(250)},
+			{Name: "bloodPressureDiastolic", Type: validate.Number, Min: validate.Limit(40), Max: validate.Limit(150)},
+			{Name: "heartRate", Type: validate.Number, Min: validate.Limit(30), Max: validate.Limit(220)},
+			{Name: "respiratoryRate", Type: validate.Number, Min: validate.Limit(8), Max: validate.Limit(60)},
+			{Name: "oxygenSaturation", Type: validate.Number, Min: validate.Limit(50), Max: validate.Limit(100)},
+			{Name: "weight", Type: validate.Number, Min: validate.Limit(0.5), Max: validate.Limit(500)},
+			{Name: "height", Type: validate.Number, Min: validate.Limit(30), Max: validate.Limit(250)},
+			{Name: "bloodGlucose", Type: validate.Number, Min: validate.Limit(20), Max: validate.Limit(600)},
+			{Name: "recordedAt", Type: validate.Time},
+		}}},
+		{Name: "allergies", Type: validate.Array, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+			shortText.Named("allergen").Require(),
+			{Name: "type", Type: validate.String, Required: true, Enum: []string{"medication", "food", "environmental", "other"}},
+			shortText.Named("reaction").Require(),
+			{Name: "severity", Type: validate.String, Required: true, Enum: []string{"mild", "moderate", "severe", "life-threatening"}},
+		}}},
+		{Name: "diagnoses", Type: validate.Array, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+			// ICD-10
+			{Name: "code", Type: validate.String, Required: true, MaxLength: 20},
+			shortText.Named("description").Require(),
+			{Name: "type", Type: validate.String, Required: true, Enum: []string{"primary", "secondary", "differential"}},
+			{Name: "status", Type: validate.String, Enum: []string{"active", "resolved", "ruled-out"}},
+		}}},
+		{Name: "prescriptions", Type: validate.Array, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+			shortText.Named("medicationName").Require(),
+			nameText.Named("dosage").Require(),
+			nameText.Named("frequency").Require(),
+			nameText.Named("route"),
+			nameText.Named("duration").Require(),
+			{Name: "quantity", Type: validate.Number, Required: true, Min: validate.Limit(0)},
+			{Name: "refills", Type: validate.Integer, Min: validate.Limit(0)},
+			clinicalText.Named("instructions").Require(),
+			{Name: "startDate", Type: validate.Time, Required: true},
+			{Name: "endDate", Type: validate.Time},
+			{Name: "status", Type: validate.String, Enum: []string{"active", "completed", "discontinued", "on-hold"}},
+		}}},
+		{Name: "documents", Type: validate.Array, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+			{Name: "type", Type: validate.String, Required: true, Enum: []string{"lab-result", "imaging", "report", "consent", "referral", "other"}},
+			shortText.Named("title").Require(),
+			fileURL.Named("fileUrl").Require(),
+			shortText.Named("fileName").Require(),
+			{Name: "fileSize", Type: validate.Integer, Required: true, Min: validate.Limit(0)},
+			{Name: "mimeType", Type: validate.String, Required: true, MaxLength: 100},
+		}}},
+	}}
+)
@@ -0,0 +1,392 @@
+package kiosk
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/auth"
+	"isy-api/listing"
+	"isy-api/response"
+	"isy-api/validate"
+)
+
+// Award statuses. An award waits as pending until an admin approves or
+// discards it; both decisions are final.
+const (
+	AwardPending   = "pending"
+	AwardApproved  = "approved"
+	AwardDiscarded = "discarded"
+)
+
+// Error codes of the award endpoints
+const (
+	// CodeAlreadyAwarded is returned for an order whose points were already
+	// credited or are awaiting approval
+	CodeAlreadyAwarded response.Code = "already_awarded"
+	// CodeAlreadyDecided is returned for an award that was already approved
+	// or discarded
+	CodeAlreadyDecided response.Code = "already_decided"
+)
+
+var (
+	errAlreadyAwarded = response.New(http.StatusConflict, CodeAlreadyAwarded, "Points for this order were already awarded or are awaiting approval")
+	errInvalidAwardID = response.New(http.StatusBadRequest, response.CodeInvalidID, "Invalid award ID")
+	// errApproverRequired keeps terminals, which sign in with API keys, from
+	// approving the awards they create
+	errApproverRequired = response.New(http.StatusForbidden, response.CodeForbidden, "Points must be approved by a signed-in admin")
+)
+
+// maxAwardBatch bounds the awards one batch decision can cover
+const maxAwardBatch = 100
+
+// PointsAward holds the points an order earned until an admin decides on
+// them. Only an approval credits the customer's ledger.
+type PointsAward struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	CustomerID    string             `bson:"customerId" json:"customerId"`
+	CustomerName  string             `bson:"customerName" json:"customerName"`
+	OrderID       string             `bson:"orderId" json:"orderId"`
+	TransactionID string             `bson:"transactionId" json:"transactionId"`
+	Points        float64            `bson:"points" json:"points"`
+	Reason        string             `bson:"reason" json:"reason"`
+	CreatedBy     string             `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+	AwardDecision `bson:",inline"`
+}
+
+// AwardDecision is the status of an award and who decided it
+type AwardDecision struct {
+	Status      string     `bson:"status" json:"status"`
+	ProcessedAt *time.Time `bson:"processedAt" json:"processedAt"`
+	ProcessedBy string     `bson:"processedBy" json:"processedBy"`
+	Note        string     `bson:"note" json:"note"`
+	// EntryID is the ledger entry an approval posts
+	EntryID *primitive.ObjectID `bson:"entryId" json:"entryId"`
+	// CreditPending is set with an approval until its entry is posted
+	CreditPending bool `bson:"creditPending,omitempty" json:"creditPending,omitempty"`
+}
+
+// Propose records the points a completed order earns its customer as an
+// award for an admin to approve. An order gets one award, and none if its
+// points were already credited.
+func (p *Points) Propose(ctx context.Context, order Order, customer Customer, by string) (PointsAward, error) {
+	if order.Status != StatusCompleted {
+		return PointsAward{}, response.New(http.StatusConflict, response.CodeConflict, "Only completed orders earn points")
+	}
+	// Walk-in customers have no member ID
+	if customer.MemberID == "" {
+		return PointsAward{}, response.New(http.StatusConflict, response.CodeConflict, "Only members earn points")
+	}
+	points := p.Earned(order)
+	if points <= 0 {
+		return PointsAward{}, response.New(http.StatusConflict, response.CodeConflict, "The order earns no points")
+	}
+
+	credited, err := p.posted(ctx, order.CustomerID, order.ID.Hex(), PointsEarn)
+	if err != nil {
+		return PointsAward{}, err
+	}
+	if credited {
+		return PointsAward{}, errAlreadyAwarded
+	}
+
+	award := PointsAward{
+		CustomerID:    order.CustomerID,
+		CustomerName:  strings.TrimSpace(customer.Name + " " + customer.LastName),
+		OrderID:       order.ID.Hex(),
+		TransactionID: order.TransactionID,
+		Points:        points,
+		Reason:        "Order " + order.TransactionID,
+		CreatedBy:     by,
+		CreatedAt:     time.Now(),
+		AwardDecision: AwardDecision{Status: AwardPending},
+	}
+	if err := p.Awards.Create(ctx, &award); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return PointsAward{}, errAlreadyAwarded
+		}
+		return PointsAward{}, err
+	}
+	return award, nil
+}
+
+// Decide approves or discards a pending award on behalf of admin by. The
+// award is claimed before an approval posts to the ledger, so two admins
+// cannot both credit it. If posting fails the approval stands and keeps
+// CreditPending for CreditPointsAwards to post it again.
+func (p *Points) Decide(ctx context.Context, award PointsAward, status, by, note string) (PointsAward, error) {
+	if award.Status != AwardPending {
+		return award, response.New(http.StatusConflict, CodeAlreadyDecided, "The award was already "+award.Status).
+			WithDetails(map[string]interface{}{"status": award.Status, "processedBy": award.ProcessedBy})
+	}
+
+	now := time.Now()
+	decision := AwardDecision{Status: status, ProcessedAt: &now, ProcessedBy: by, Note: note}
+	if status == AwardApproved {
+		entryID := primitive.NewObjectID()
+		decision.EntryID = &entryID
+		decision.CreditPending = true
+	}
+	if err := p.Awards.Decide(ctx, award.ID, AwardPending, decision); err != nil {
+		return award, err
+	}
+
+	award.AwardDecision = decision
+	if status == AwardApproved {
+		if err := p.Credit(ctx, &award); err != nil {
+			return award, err
+		}
+	}
+	return award, nil
+}
+
+// Credit posts the entry of an approved award and clears its CreditPending
+// mark. The entry keeps the ID assigned on approval and is posted once per
+// order, so crediting an award again never credits it twice.
+func (p *Points) Credit(ctx context.Context, award *PointsAward) error {
+	if award.Status != AwardApproved || award.EntryID == nil {
+		return response.New(http.StatusConflict, response.CodeConflict, "Only approved awards are credited")
+	}
+
+	err := p.postOnce(ctx, award.CustomerID, PointsEntry{
+		ID:      *award.EntryID,
+		Type:    PointsEarn,
+		Points:  award.Points,
+		OrderID: award.OrderID,
+		Reason:  award.Reason,
+		By:      award.ProcessedBy,
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.Awards.Credited(ctx, award.ID); err != nil {
+		return err
+	}
+	award.CreditPending = false
+	return nil
+}
+
+var awardListing = listing.Spec{
+	Fields: []listing.Field{
+		{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+		{Name: "status", Type: listing.String},
+		{Name: "customerId", Type: listing.String},
+		{Name: "orderId", Type: listing.String},
+		{Name: "transactionId", Type: listing.String},
+		{Name: "points", Type: listing.Number, Sortable: true},
+		{Name: "createdAt", Type: listing.Time, Sortable: true},
+		{Name: "processedAt", Type: listing.Time, Sortable: true},
+		{Name: "creditPending", Type: listing.Bool},
+	},
+	DefaultSort: "-createdAt",
+}
+
+// AwardDecisionRequest is the body of DecidePointsAward
+type AwardDecisionRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// AwardBatchRequest is the body of DecidePointsAwards
+type AwardBatchRequest struct {
+	IDs    []primitive.ObjectID `json:"ids"`
+	Status string               `json:"status"`
+	Note   string               `json:"note"`
+}
+
+// AwardBatchResult reports the decision on one award of a batch
+type AwardBatchResult struct {
+	ID     primitive.ObjectID `json:"id"`
+	Status string             `json:"status,omitempty"`
+	Code   response.Code      `json:"code,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// GetPointsAwards lists points awards a page at a time, newest first unless
+// sorted otherwise; filter by status=pending for the approval queue
+func (kh *KioskHandlers) GetPointsAwards(w http.ResponseWriter, r *http.Request) {
+	query, err := listing.Parse(r, awardListing)
+	if err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	result, err := kh.Points.Awards.List(r.Context(), query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch points awards")
+		return
+	}
+
+	response.List(w, r, result.Items, result.Page())
+}
+
+// GetPointsAward retrieves a single points award by ID
+func (kh *KioskHandlers) GetPointsAward(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidAwardID)
+		return
+	}
+
+	award, err := kh.Points.Awards.Get(r.Context(), objID)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch points award")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, award)
+}
+
+// CreatePointsAward proposes the points a completed order earned for
+// approval. The points are computed from the order, never sent by the
+// client.
+func (kh *KioskHandlers) CreatePointsAward(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidOrderID)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := kh.Orders.Get(ctx, objID)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch order")
+		return
+	}
+	if order.CustomerID == "" {
+		response.WriteError(w, r, response.New(http.StatusConflict, response.CodeConflict, "Only members earn points"))
+		return
+	}
+	customer, err := kh.Customers.Get(ctx, order.CustomerID)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch customer")
+		return
+	}
+
+	award, err := kh.Points.Propose(ctx, order, customer, actor(ctx))
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to create points award")
+		return
+	}
+
+	response.JSON(w, r, http.StatusCreated, award)
+}
+
+// DecidePointsAward approves or discards one pending award
+func (kh *KioskHandlers) DecidePointsAward(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidAwardID)
+		return
+	}
+
+	var req AwardDecisionRequest
+	if err := validate.DecodeInto(r, awardDecisionSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	by, ok := approver(ctx)
+	if !ok {
+		response.WriteError(w, r, errApproverRequired)
+		return
+	}
+
+	award, err := kh.decideAward(ctx, objID, req.Status, by, req.Note)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to decide points award")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, award)
+}
+
+// DecidePointsAwards approves or discards a batch of pending awards. Each
+// award is decided on its own; the response reports every one, and a
+// failure does not stop the rest.
+func (kh *KioskHandlers) DecidePointsAwards(w http.ResponseWriter, r *http.Request) {
+	var req AwardBatchRequest
+	if err := validate.DecodeInto(r, awardBatchSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	by, ok := approver(ctx)
+	if !ok {
+		response.WriteError(w, r, errApproverRequired)
+		return
+	}
+
+	results := make([]AwardBatchResult, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		award, err := kh.decideAward(ctx, id, req.Status, by, req.Note)
+		if err != nil {
+			failure := repositoryError(err, "Failed to decide points award")
+			if failure.Cause != nil {
+				log.Printf("Failed to decide points award %s: %v", id.Hex(), failure.Cause)
+			}
+			results = append(results, AwardBatchResult{ID: id, Code: failure.Code, Error: failure.Message})
+			continue
+		}
+		results = append(results, AwardBatchResult{ID: id, Status: award.Status})
+	}
+
+	response.JSON(w, r, http.StatusOK, results)
+}
+
+func (kh *KioskHandlers) decideAward(ctx context.Context, id primitive.ObjectID, status, by, note string) (PointsAward, error) {
+	award, err := kh.Points.Awards.Get(ctx, id)
+	if err != nil {
+		return PointsAward{}, err
+	}
+	return kh.Points.Decide(ctx, award, status, by, note)
+}
+
+// CreditPointsAwards posts again the approved awards whose points could not
+// be credited when they were approved, up to maxAwardBatch at a time,
+// oldest first
+func (kh *KioskHandlers) CreditPointsAwards(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	pending, err := kh.Points.Awards.List(ctx, listing.Query{
+		Conditions: []listing.Condition{{Path: "creditPending", Op: listing.Eq, Value: true}},
+		SortPath:   "_id",
+		Limit:      maxAwardBatch,
+	})
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch points awards")
+		return
+	}
+
+	results := make([]AwardBatchResult, 0, len(pending.Items))
+	for _, award := range pending.Items {
+		if err := kh.Points.Credit(ctx, &award); err != nil {
+			failure := repositoryError(err, "Failed to credit points award")
+			if failure.Cause != nil {
+				log.Printf("Failed to credit points award %s: %v", award.ID.Hex(), failure.Cause)
+			}
+			results = append(results, AwardBatchResult{ID: award.ID, Code: failure.Code, Error: failure.Message})
+			continue
+		}
+		results = append(results, AwardBatchResult{ID: award.ID, Status: award.Status})
+	}
+
+	response.JSON(w, r, http.StatusOK, results)
+}
+
+// approver names the admin signed in on ctx. API keys cannot approve.
+func approver(ctx context.Context) (string, bool) {
+	claims, ok := auth.ClaimsFromContext(ctx)
+	if !ok || claims.APIKeyID != "" {
+		return "", false
+	}
+	return claims.Username, true
+}
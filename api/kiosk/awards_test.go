@@ -0,0 +1,142 @@
+package kiosk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/apitest"
+	"isy-api/config"
+	"isy-api/listing"
+	"isy-api/response"
+)
+
+// flakyLedger fails Append while err is set
+type flakyLedger struct {
+	PointsRepository
+	err error
+}
+
+func (l *flakyLedger) Append(ctx context.Context, balance PointsBalance, entry PointsEntry) (PointsEntry, PointsBalance, error) {
+	if l.err != nil {
+		return PointsEntry{}, PointsBalance{}, l.err
+	}
+	return l.PointsRepository.Append(ctx, balance, entry)
+}
+
+// testAward stores a pending award of 5 points for customer C
+func testAward(t *testing.T, p *Points) PointsAward {
+	t.Helper()
+	award := PointsAward{CustomerID: "C", OrderID: primitive.NewObjectID().Hex(), Points: 5, AwardDecision: AwardDecision{Status: AwardPending}}
+	if err := p.Awards.Create(context.Background(), &award); err != nil {
+		t.Fatal(err)
+	}
+	return award
+}
+
+func TestApprovalSurvivesLedgerFailure(t *testing.T) {
+	ctx := context.Background()
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, nil, nil)
+	ledger := &flakyLedger{PointsRepository: kh.Points.Ledger, err: ErrUnavailable}
+	kh.Points.Ledger = ledger
+	award := testAward(t, kh.Points)
+
+	if _, err := kh.Points.Decide(ctx, award, AwardApproved, "admin", ""); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("approving while the ledger is down = %v, want ErrUnavailable", err)
+	}
+	stored, err := kh.Points.Awards.Get(ctx, award.ID)
+	if err != nil || stored.Status != AwardApproved || !stored.CreditPending || stored.EntryID == nil {
+		t.Fatalf("stored award = %+v (%v), want approved and pending credit", stored.AwardDecision, err)
+	}
+	var problem *response.Error
+	if _, err := kh.Points.Decide(ctx, stored, AwardApproved, "other", ""); !errors.As(err, &problem) || problem.Code != CodeAlreadyDecided {
+		t.Fatalf("approving again = %v, want %s", err, CodeAlreadyDecided)
+	}
+
+	credit := func() []AwardBatchResult {
+		t.Helper()
+		status, body := apitest.Serve(t, kh.CreditPointsAwards, apitest.Request("POST", "/pending-points/credits", ""))
+		if status != http.StatusOK {
+			t.Fatalf("CreditPointsAwards = %d %s", status, body.Code)
+		}
+		var results []AwardBatchResult
+		body.Decode(t, &results)
+		return results
+	}
+	if results := credit(); len(results) != 1 || results[0].Error == "" {
+		t.Fatalf("crediting while the ledger is down = %+v, want one failure", results)
+	}
+	ledger.err = nil
+	if results := credit(); len(results) != 1 || results[0].Status != AwardApproved || results[0].Error != "" {
+		t.Fatalf("crediting = %+v, want one success", results)
+	}
+	if results := credit(); len(results) != 0 {
+		t.Fatalf("crediting again = %+v, want nothing pending", results)
+	}
+
+	history, err := kh.Points.Ledger.History(ctx, "C", listing.Query{SortPath: "seq", Limit: 10})
+	if err != nil || history.Total != 1 || history.Items[0].ID != *stored.EntryID || history.Items[0].Points != 5 {
+		t.Errorf("ledger = %+v (%v), want the approved entry once", history.Items, err)
+	}
+}
+
+func TestCreditPostsOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		// prior is posted before the award is credited, standing in for an
+		// earlier attempt that failed after posting
+		prior func(award PointsAward) *PointsEntry
+	}{
+		{
+			name:  "nothing posted",
+			prior: func(PointsAward) *PointsEntry { return nil },
+		},
+		{
+			name: "approved entry posted",
+			prior: func(award PointsAward) *PointsEntry {
+				return &PointsEntry{ID: *award.EntryID, Type: PointsEarn, Points: award.Points, OrderID: award.OrderID}
+			},
+		},
+		{
+			name: "order already earned",
+			prior: func(award PointsAward) *PointsEntry {
+				return &PointsEntry{ID: primitive.NewObjectID(), Type: PointsEarn, Points: award.Points, OrderID: award.OrderID}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := NewMemoryKioskHandlers(config.Default().Kiosk, nil, nil).Points
+			award := testAward(t, p)
+			entryID := primitive.NewObjectID()
+			decision := AwardDecision{Status: AwardApproved, ProcessedBy: "admin", EntryID: &entryID, CreditPending: true}
+			if err := p.Awards.Decide(ctx, award.ID, AwardPending, decision); err != nil {
+				t.Fatal(err)
+			}
+			award.AwardDecision = decision
+			if entry := tt.prior(award); entry != nil {
+				if _, _, err := p.post(ctx, award.CustomerID, *entry, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for range 2 {
+				if err := p.Credit(ctx, &award); err != nil {
+					t.Fatal(err)
+				}
+			}
+			balance, err := p.Balance(ctx, award.CustomerID)
+			if err != nil || balance.Earned != 5 {
+				t.Errorf("earned %g (%v), want 5 once", balance.Earned, err)
+			}
+			stored, err := p.Awards.Get(ctx, award.ID)
+			if err != nil || stored.CreditPending {
+				t.Errorf("stored award pending credit = %v (%v), want credited", stored.CreditPending, err)
+			}
+		})
+	}
+}
@@ -2,57 +2,59 @@ package kiosk
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
-)
 
-// APIResponse represents a standard API response
-type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
-}
+	"isy-api/auth"
+	"isy-api/config"
+	"isy-api/listing"
+	"isy-api/response"
+	"isy-api/search"
+	"isy-api/validate"
+)
 
 // Product represents a kiosk product
 type Product struct {
-	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	ProductID      string            `bson:"productId" json:"productId"`
-	Name           string            `bson:"name" json:"name"`
-	Description    string            `bson:"description" json:"description"`
-	CategoryID     string            `bson:"categoryId" json:"categoryId"`
-	SubcategoryID  *string           `bson:"subcategoryId,omitempty" json:"subcategoryId,omitempty"`
-	HasVariants    bool              `bson:"hasVariants" json:"hasVariants"`
-	Variants       []Variant         `bson:"variants" json:"variants"`
-	Price          float64           `bson:"price" json:"price"`
-	MemberPrice    float64           `bson:"memberPrice" json:"memberPrice"`
-	MainImage      string            `bson:"mainImage" json:"mainImage"`
-	Images         []Image           `bson:"images" json:"images"`
-	SKU            string            `bson:"sku" json:"sku"`
-	BackgroundImage string           `bson:"backgroundImage" json:"backgroundImage"`
-	BackgroundFit  string            `bson:"backgroundFit" json:"backgroundFit"`
-	TextColor      string            `bson:"textColor" json:"textColor"`
-	ModelURL       string            `bson:"modelUrl" json:"modelUrl"`
-	IsActive       bool              `bson:"isActive" json:"isActive"`
-	IsFeatured     bool              `bson:"isFeatured" json:"isFeatured"`
-	Notes          string            `bson:"notes" json:"notes"`
-	CreatedAt      time.Time         `bson:"createdAt" json:"createdAt"`
-	UpdatedAt      time.Time         `bson:"updatedAt" json:"updatedAt"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ProductID       string             `bson:"productId" json:"productId"`
+	Name            string             `bson:"name" json:"name"`
+	Description     string             `bson:"description" json:"description"`
+	CategoryID      string             `bson:"categoryId" json:"categoryId"`
+	SubcategoryID   *string            `bson:"subcategoryId,omitempty" json:"subcategoryId,omitempty"`
+	HasVariants     bool               `bson:"hasVariants" json:"hasVariants"`
+	Variants        []Variant          `bson:"variants" json:"variants"`
+	Price           float64            `bson:"price" json:"price"`
+	MemberPrice     float64            `bson:"memberPrice" json:"memberPrice"`
+	MainImage       string             `bson:"mainImage" json:"mainImage"`
+	Images          []Image            `bson:"images" json:"images"`
+	SKU             string             `bson:"sku" json:"sku"`
+	BackgroundImage string             `bson:"backgroundImage" json:"backgroundImage"`
+	BackgroundFit   string             `bson:"backgroundFit" json:"backgroundFit"`
+	TextColor       string             `bson:"textColor" json:"textColor"`
+	ModelURL        string             `bson:"modelUrl" json:"modelUrl"`
+	IsActive        bool               `bson:"isActive" json:"isActive"`
+	IsFeatured      bool               `bson:"isFeatured" json:"isFeatured"`
+	Notes           string             `bson:"notes" json:"notes"`
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time          `bson:"updatedAt" json:"updatedAt"`
+	SearchGrams     []string           `bson:"searchGrams,omitempty" json:"-"`
 }
 
 // Variant represents a product variant
 type Variant struct {
-	ID       string  `bson:"id" json:"id"`
-	Name     string  `bson:"name" json:"name"`
-	Price    float64 `bson:"price" json:"price"`
+	ID          string  `bson:"id" json:"id"`
+	Name        string  `bson:"name" json:"name"`
+	Price       float64 `bson:"price" json:"price"`
 	MemberPrice float64 `bson:"memberPrice" json:"memberPrice"`
-	SKU      string  `bson:"sku" json:"sku"`
-	Stock    int     `bson:"stock" json:"stock"`
+	SKU         string  `bson:"sku" json:"sku"`
+	// Stock is the count from before the stock ledger, which opened with
+	// it; on-hand stock is computed from the movements
+	Stock int `bson:"stock" json:"stock"`
 }
 
 // Image represents a product image
@@ -64,23 +66,26 @@ type Image struct {
 
 // Customer represents a kiosk customer
 type Customer struct {
-	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	CustomerID       string            `bson:"customerId" json:"customerId"`
-	Name             string            `bson:"name" json:"name"`
-	LastName         string            `bson:"lastName" json:"lastName"`
-	Nickname         string            `bson:"nickname" json:"nickname"`
-	Email            string            `bson:"email" json:"email"`
-	Cell             string            `bson:"cell" json:"cell"`
-	MemberID         string            `bson:"memberId" json:"memberId"`
-	Points           []PointEntry      `bson:"points" json:"points"`
-	TotalSpent       float64           `bson:"totalSpent" json:"totalSpent"`
-	VisitCount       int               `bson:"visitCount" json:"visitCount"`
-	IsActive         bool              `bson:"isActive" json:"isActive"`
-	AllowedCategories []string         `bson:"allowedCategories" json:"allowedCategories"`
-	DateOfBirth      string            `bson:"dateOfBirth" json:"dateOfBirth"`
-	CustomPoints     float64           `bson:"customPoints" json:"customPoints"`
-	CreatedAt        time.Time         `bson:"createdAt" json:"createdAt"`
-	UpdatedAt        time.Time         `bson:"updatedAt" json:"updatedAt"`
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	CustomerID string             `bson:"customerId" json:"customerId"`
+	Name       string             `bson:"name" json:"name"`
+	LastName   string             `bson:"lastName" json:"lastName"`
+	Nickname   string             `bson:"nickname" json:"nickname"`
+	Email      string             `bson:"email" json:"email"`
+	Cell       string             `bson:"cell" json:"cell"`
+	MemberID   string             `bson:"memberId" json:"memberId"`
+	// Points and CustomPoints hold the balance from before the points
+	// ledger, which opened with it; they are no longer updated
+	Points            []PointEntry `bson:"points" json:"points"`
+	TotalSpent        float64      `bson:"totalSpent" json:"totalSpent"`
+	VisitCount        int          `bson:"visitCount" json:"visitCount"`
+	IsActive          bool         `bson:"isActive" json:"isActive"`
+	AllowedCategories []string     `bson:"allowedCategories" json:"allowedCategories"`
+	DateOfBirth       string       `bson:"dateOfBirth" json:"dateOfBirth"`
+	CustomPoints      float64      `bson:"customPoints" json:"customPoints"`
+	CreatedAt         time.Time    `bson:"createdAt" json:"createdAt"`
+	UpdatedAt         time.Time    `bson:"updatedAt" json:"updatedAt"`
+	SearchGrams       []string     `bson:"searchGrams,omitempty" json:"-"`
 }
 
 // PointEntry represents a points transaction
@@ -94,17 +99,35 @@ type PointEntry struct {
 // Order represents a kiosk order/transaction
 type Order struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	TransactionID string            `bson:"transactionId" json:"transactionId"`
-	CustomerID    string            `bson:"customerId" json:"customerId"`
-	Items         []OrderItem       `bson:"items" json:"items"`
-	Total         float64           `bson:"total" json:"total"`
-	Discount      float64           `bson:"discount" json:"discount"`
-	FinalTotal    float64           `bson:"finalTotal" json:"finalTotal"`
-	PaymentMethod string            `bson:"paymentMethod" json:"paymentMethod"`
-	Status        string            `bson:"status" json:"status"`
-	Notes         string            `bson:"notes" json:"notes"`
-	CreatedAt     time.Time         `bson:"createdAt" json:"createdAt"`
-	UpdatedAt     time.Time         `bson:"updatedAt" json:"updatedAt"`
+	TransactionID string             `bson:"transactionId" json:"transactionId"`
+	CustomerID    string             `bson:"customerId" json:"customerId"`
+	Items         []OrderItem        `bson:"items" json:"items"`
+	Total         float64            `bson:"total" json:"total"`
+	Discount      float64            `bson:"discount" json:"discount"`
+	// Rounding brings the amount due to the configured increment
+	Rounding float64 `bson:"rounding" json:"rounding"`
+	// PointsRedeemed were spent on the order, worth PointsValue
+	PointsRedeemed float64 `bson:"pointsRedeemed" json:"pointsRedeemed"`
+	PointsValue    float64 `bson:"pointsValue" json:"pointsValue"`
+	FinalTotal     float64 `bson:"finalTotal" json:"finalTotal"`
+	PaymentMethod  string  `bson:"paymentMethod" json:"paymentMethod"`
+	Status         string  `bson:"status" json:"status"`
+	// SettlementPending is set with a final status until the points and
+	// stock of the order are settled
+	SettlementPending bool           `bson:"settlementPending,omitempty" json:"settlementPending,omitempty"`
+	Notes             string         `bson:"notes" json:"notes"`
+	StatusHistory     []StatusChange `bson:"statusHistory" json:"statusHistory"`
+	CreatedAt         time.Time      `bson:"createdAt" json:"createdAt"`
+	UpdatedAt         time.Time      `bson:"updatedAt" json:"updatedAt"`
+}
+
+// StatusChange records an order entering a status
+type StatusChange struct {
+	Status string    `bson:"status" json:"status"`
+	At     time.Time `bson:"at" json:"at"`
+	// By is the username of the admin or the API key that made the change
+	By   string `bson:"by" json:"by"`
+	Note string `bson:"note,omitempty" json:"note,omitempty"`
 }
 
 // OrderItem represents an item in an order
@@ -112,21 +135,24 @@ type OrderItem struct {
 	ProductID   string  `bson:"productId" json:"productId"`
 	ProductName string  `bson:"productName" json:"productName"`
 	VariantID   string  `bson:"variantId,omitempty" json:"variantId,omitempty"`
+	VariantName string  `bson:"variantName,omitempty" json:"variantName,omitempty"`
+	CategoryID  string  `bson:"categoryId" json:"categoryId"`
 	Quantity    int     `bson:"quantity" json:"quantity"`
 	Price       float64 `bson:"price" json:"price"`
 	Total       float64 `bson:"total" json:"total"`
+	Discount    float64 `bson:"discount" json:"discount"`
 }
 
 // Category represents a product category
 type Category struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Name        string            `bson:"name" json:"name"`
-	Description string            `bson:"description" json:"description"`
-	ImageURL    string            `bson:"imageUrl" json:"imageUrl"`
-	IsActive    bool              `bson:"isActive" json:"isActive"`
-	Order       int               `bson:"order" json:"order"`
-	CreatedAt   time.Time         `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   time.Time         `bson:"updatedAt" json:"updatedAt"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description" json:"description"`
+	ImageURL    string             `bson:"imageUrl" json:"imageUrl"`
+	IsActive    bool               `bson:"isActive" json:"isActive"`
+	Order       int                `bson:"order" json:"order"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
 // AuthRequest represents login credentials
@@ -136,185 +162,237 @@ type AuthRequest struct {
 }
 
 // JWTClaims represents JWT claims
-type JWTClaims struct {
-	Username string `json:"username"`
-	jwt.RegisteredClaims
-}
+type JWTClaims = auth.Claims
+
+// List parameters clients may use on products and customers
+var (
+	productListing = listing.Spec{Fields: []listing.Field{
+		{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+		{Name: "productId", Type: listing.String},
+		{Name: "name", Type: listing.String, Sortable: true},
+		{Name: "categoryId", Type: listing.String},
+		{Name: "subcategoryId", Type: listing.String},
+		{Name: "sku", Type: listing.String},
+		{Name: "price", Type: listing.Number, Sortable: true},
+		{Name: "memberPrice", Type: listing.Number, Sortable: true},
+		{Name: "isActive", Type: listing.Bool},
+		{Name: "isFeatured", Type: listing.Bool},
+		{Name: "createdAt", Type: listing.Time, Sortable: true},
+		{Name: "updatedAt", Type: listing.Time, Sortable: true},
+	}}
+	customerListing = listing.Spec{Fields: []listing.Field{
+		{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+		{Name: "customerId", Type: listing.String},
+		{Name: "name", Type: listing.String, Sortable: true},
+		{Name: "lastName", Type: listing.String, Sortable: true},
+		{Name: "email", Type: listing.String},
+		{Name: "cell", Type: listing.String},
+		{Name: "memberId", Type: listing.String},
+		{Name: "isActive", Type: listing.Bool},
+		{Name: "allowedCategories", Type: listing.String},
+		{Name: "totalSpent", Type: listing.Number, Sortable: true},
+		{Name: "visitCount", Type: listing.Number, Sortable: true},
+		{Name: "createdAt", Type: listing.Time, Sortable: true},
+		{Name: "updatedAt", Type: listing.Time, Sortable: true},
+	}}
+)
 
 // KioskHandlers contains all kiosk-related handlers
 type KioskHandlers struct {
-	DB *mongo.Database
+	Products  ProductRepository
+	Customers CustomerRepository
+	Orders    OrderRepository
+	Pricing   *Pricer
+	Points    *Points
+	Stock     StockRepository
+	Auth      *auth.Service
 }
 
-// NewKioskHandlers creates a new kiosk handlers instance
-func NewKioskHandlers(db *mongo.Database) *KioskHandlers {
-	return &KioskHandlers{DB: db}
+// NewKioskHandlers creates a new kiosk handlers instance backed by MongoDB
+func NewKioskHandlers(db *mongo.Database, authService *auth.Service, cfg config.Kiosk) *KioskHandlers {
+	products := NewMongoProductRepository(db)
+	customers := NewMongoCustomerRepository(db)
+	return &KioskHandlers{
+		Products:  products,
+		Customers: customers,
+		Orders:    NewMongoOrderRepository(db),
+		Pricing:   &Pricer{Products: products, Customers: customers, Rules: cfg.Pricing},
+		Points:    &Points{Ledger: NewMongoPointsRepository(db), Awards: NewMongoPointsAwardRepository(db), Rules: cfg.Points},
+		Stock:     NewMongoStockRepository(db),
+		Auth:      authService,
+	}
 }
 
-// Authenticate handles user authentication
-func (kh *KioskHandlers) Authenticate(w http.ResponseWriter, r *http.Request) {
-	if kh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
+// NewMemoryKioskHandlers creates handlers on memory repositories holding
+// products and customers in the shared database, for tests and local
+// development without MongoDB
+func NewMemoryKioskHandlers(cfg config.Kiosk, products []Product, customers []Customer) *KioskHandlers {
+	productRepo := NewMemoryProductRepository(products...)
+	customerRepo := NewMemoryCustomerRepository(customers...)
+	return &KioskHandlers{
+		Products:  productRepo,
+		Customers: customerRepo,
+		Orders:    NewMemoryOrderRepository(),
+		Pricing:   &Pricer{Products: productRepo, Customers: customerRepo, Rules: cfg.Pricing},
+		Points:    &Points{Ledger: NewMemoryPointsRepository(), Awards: NewMemoryPointsAwardRepository(), Rules: cfg.Points},
+		Stock:     NewMemoryStockRepository(),
 	}
+}
 
-	var authReq AuthRequest
-	if err := json.NewDecoder(r.Body).Decode(&authReq); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+// Quote prices a cart for the kiosk UI exactly as CreateOrder will charge it
+func (kh *KioskHandlers) Quote(w http.ResponseWriter, r *http.Request) {
+	var cart Cart
+	if err := validate.DecodeInto(r, cartSchema, &cart); err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
-	// For now, check against a simple admin collection
-	// In production, this should be more secure
-	collection := kh.DB.Collection("admins")
-	var admin bson.M
-	err := collection.FindOne(r.Context(), bson.M{"username": authReq.Username}).Decode(&admin)
+	quote, err := kh.Pricing.Quote(r.Context(), cart)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "Authentication failed")
+		respondWithRepositoryError(w, r, err, "Failed to price cart")
 		return
 	}
 
-	// Check password
-	storedPassword, ok := admin["password"].(string)
-	if !ok {
-		respondWithError(w, http.StatusInternalServerError, "Invalid password format")
-		return
-	}
+	response.JSON(w, r, http.StatusOK, quote)
+}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(authReq.Password)); err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+// Authenticate handles user authentication
+func (kh *KioskHandlers) Authenticate(w http.ResponseWriter, r *http.Request) {
+	var authReq AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&authReq); err != nil {
+		response.WriteError(w, r, response.BodyError(err))
 		return
 	}
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
-		Username: authReq.Username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	})
-
-	tokenString, err := token.SignedString([]byte("your-secret-key")) // Use env var in production
+	result, err := kh.Auth.Login(r.Context(), authReq.Username, authReq.Password, auth.ClientInfoFromRequest(r))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		auth.RespondWithLoginError(w, r, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data: map[string]string{
-			"token": tokenString,
-		},
-	})
+	response.JSON(w, r, http.StatusOK, result)
 }
 
-// GetProducts retrieves all products
+// GetProducts lists products a page at a time
 func (kh *KioskHandlers) GetProducts(w http.ResponseWriter, r *http.Request) {
-	if kh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	ctx := r.Context()
-	collection := kh.DB.Collection("products")
-
-	cursor, err := collection.Find(ctx, bson.M{})
+	query, err := listing.Parse(r, productListing)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch products")
+		response.WriteError(w, r, err)
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var products []Product
-	if err := cursor.All(ctx, &products); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to parse products")
+	result, err := kh.Products.List(r.Context(), query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch products")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    products,
-	})
+	response.List(w, r, result.Items, result.Page())
 }
 
 // CreateProduct creates a new product
 func (kh *KioskHandlers) CreateProduct(w http.ResponseWriter, r *http.Request) {
-	if kh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
 	var product Product
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := validate.DecodeInto(r, productSchema, &product); err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
 	product.CreatedAt = time.Now()
 	product.UpdatedAt = time.Now()
 
-	ctx := r.Context()
-	collection := kh.DB.Collection("products")
-
-	result, err := collection.InsertOne(ctx, product)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create product")
+	if err := kh.Products.Create(r.Context(), &product); err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to create product")
 		return
 	}
 
-	product.ID = result.InsertedID.(primitive.ObjectID)
-
-	respondWithJSON(w, http.StatusCreated, APIResponse{
-		Success: true,
-		Data:    product,
-	})
+	response.JSON(w, r, http.StatusCreated, product)
 }
 
-// GetCustomers retrieves all customers
+// GetCustomers lists customers a page at a time
 func (kh *KioskHandlers) GetCustomers(w http.ResponseWriter, r *http.Request) {
-	if kh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
+	query, err := listing.Parse(r, customerListing)
+	if err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
-	ctx := r.Context()
-	collection := kh.DB.Collection("customers")
-
-	cursor, err := collection.Find(ctx, bson.M{})
+	result, err := kh.Customers.List(r.Context(), query)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch customers")
+		respondWithRepositoryError(w, r, err, "Failed to fetch customers")
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var customers []Customer
-	if err := cursor.All(ctx, &customers); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to parse customers")
+	response.List(w, r, result.Items, result.Page())
+}
+
+// Search finds products and customers by name, nickname, phone, member ID
+// or SKU. ?types=products,customers narrows the search; by default it covers
+// every type the caller may read.
+func (kh *KioskHandlers) Search(w http.ResponseWriter, r *http.Request) {
+	query, limit, err := search.Parse(r)
+	if err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    customers,
-	})
+	types := []string{"products", "customers"}
+	if raw := r.URL.Query().Get("types"); raw != "" {
+		types = strings.Split(raw, ",")
+	} else if !auth.Can(r.Context(), "kiosk:customers:read") {
+		types = []string{"products"}
+	}
+
+	var lists [][]search.Hit
+	for _, kind := range types {
+		var hits []search.Hit
+		switch strings.TrimSpace(kind) {
+		case "products":
+			hits, err = kh.Products.Search(r.Context(), query, limit)
+		case "customers":
+			if !auth.Can(r.Context(), "kiosk:customers:read") {
+				response.WriteError(w, r, response.New(http.StatusForbidden, auth.CodePermissionDenied, "Insufficient permissions").
+					WithDetails(map[string]interface{}{"required": "kiosk:customers:read"}))
+				return
+			}
+			hits, err = kh.Customers.Search(r.Context(), query, limit)
+		default:
+			response.WriteError(w, r, response.New(http.StatusBadRequest, response.CodeValidationFailed, "Invalid search parameters").
+				WithFields(response.FieldError{Field: "types", Code: "invalid_value", Message: "must list products or customers"}))
+			return
+		}
+		if err != nil {
+			respondWithRepositoryError(w, r, err, "Search failed")
+			return
+		}
+		lists = append(lists, hits)
+	}
+
+	response.JSON(w, r, http.StatusOK, search.Merge(limit, lists...))
 }
 
-// Helper functions
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, APIResponse{
-		Success: false,
-		Error:   message,
-	})
+// respondWithRepositoryError reports err from a repository, using message
+// for unexpected failures
+func respondWithRepositoryError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	response.WriteError(w, r, repositoryError(err, message))
 }
 
-func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.Marshal(payload)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	w.Write(response)
-}
\ No newline at end of file
+// repositoryError converts err from a repository to the error response
+// reporting it
+func repositoryError(err error, message string) *response.Error {
+	var apiErr *response.Error
+	switch {
+	case errors.Is(err, ErrUnavailable):
+		return response.ErrUnavailable
+	case errors.Is(err, ErrNotFound):
+		return response.New(http.StatusNotFound, response.CodeNotFound, "Not found")
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, ErrInsufficientPoints):
+		return response.New(http.StatusConflict, CodeInsufficientPoints, "The customer does not have enough points")
+	case errors.Is(err, ErrConflict):
+		return response.New(http.StatusConflict, response.CodeConflict, "The document was changed by another request; reload and retry")
+	default:
+		return response.Internal(message, err)
+	}
+}
@@ -0,0 +1,55 @@
+package kiosk
+
+import (
+	"net/http"
+	"testing"
+
+	"isy-api/apitest"
+	"isy-api/config"
+)
+
+func TestCreateAndListProducts(t *testing.T) {
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, nil, nil)
+
+	status, body := apitest.Serve(t, kh.CreateProduct, apitest.Request("POST", "/products", `{"name":"Latte","categoryId":"drinks","price":4.5}`))
+	if status != http.StatusCreated {
+		t.Fatalf("CreateProduct = %d %s, want 201", status, body.Code)
+	}
+	var created Product
+	body.Decode(t, &created)
+	if created.ID.IsZero() || created.Name != "Latte" {
+		t.Fatalf("created %+v", created)
+	}
+
+	status, body = apitest.Serve(t, kh.GetProducts, apitest.Request("GET", "/products", ""))
+	var products []Product
+	body.Decode(t, &products)
+	if status != http.StatusOK || len(products) != 1 || products[0].ID != created.ID || body.Page.Total != 1 {
+		t.Fatalf("GetProducts = %d %+v", status, products)
+	}
+}
+
+func TestCreateProductRejectsInvalidBody(t *testing.T) {
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, nil, nil)
+
+	for _, body := range []string{`{"categoryId":"drinks","price":4.5}`, `{"name":"Latte","categoryId":"drinks","price":-1}`, `not json`} {
+		status, _ := apitest.Serve(t, kh.CreateProduct, apitest.Request("POST", "/products", body))
+		if status != http.StatusBadRequest {
+			t.Errorf("CreateProduct(%s) = %d, want 400", body, status)
+		}
+	}
+}
+
+func TestProductsPerTenant(t *testing.T) {
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, nil, nil)
+	create := apitest.ForTenant(apitest.Request("POST", "/products", `{"name":"Latte","categoryId":"drinks","price":4.5,"isActive":true}`), "north")
+	if status, body := apitest.Serve(t, kh.CreateProduct, create); status != http.StatusCreated {
+		t.Fatalf("CreateProduct = %d %s, want 201", status, body.Code)
+	}
+
+	for tenantID, want := range map[string]int{"north": 1, "south": 0, "": 0} {
+		if _, body := apitest.Serve(t, kh.GetProducts, apitest.ForTenant(apitest.Request("GET", "/products", ""), tenantID)); body.Count(t) != want {
+			t.Errorf("tenant %q sees %s, want %d products", tenantID, body.Data, want)
+		}
+	}
+}
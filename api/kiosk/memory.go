@@ -0,0 +1,358 @@
+package kiosk
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/listing"
+	"isy-api/search"
+	"isy-api/tenant"
+)
+
+// MemoryProductRepository keeps products in process memory. It is meant for
+// tests and local development without MongoDB. Like every memory repository
+// it keeps each tenant's data apart; the items it is created with belong to
+// the shared database.
+type MemoryProductRepository struct {
+	mu       sync.RWMutex
+	products map[string][]Product
+}
+
+// NewMemoryProductRepository creates a repository holding products in the
+// shared database
+func NewMemoryProductRepository(products ...Product) *MemoryProductRepository {
+	return &MemoryProductRepository{products: map[string][]Product{"": products}}
+}
+
+func (repo *MemoryProductRepository) List(ctx context.Context, query listing.Query) (listing.Result[Product], error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return listing.Apply(repo.products[tenant.ID(ctx)], query)
+}
+
+func (repo *MemoryProductRepository) Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return search.Rank(search.Products, query, repo.products[tenant.ID(ctx)], limit)
+}
+
+func (repo *MemoryProductRepository) Get(ctx context.Context, id string) (Product, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, product := range repo.products[tenant.ID(ctx)] {
+		if product.ID.Hex() == id || product.ProductID == id {
+			return product, nil
+		}
+	}
+	return Product{}, ErrNotFound
+}
+
+func (repo *MemoryProductRepository) Create(ctx context.Context, product *Product) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if product.ID.IsZero() {
+		product.ID = primitive.NewObjectID()
+	}
+	key := tenant.ID(ctx)
+	repo.products[key] = append(repo.products[key], *product)
+	return nil
+}
+
+// MemoryCustomerRepository keeps customers in process memory
+type MemoryCustomerRepository struct {
+	mu        sync.RWMutex
+	customers map[string][]Customer
+}
+
+// NewMemoryCustomerRepository creates a repository holding customers
+func NewMemoryCustomerRepository(customers ...Customer) *MemoryCustomerRepository {
+	return &MemoryCustomerRepository{customers: map[string][]Customer{"": customers}}
+}
+
+func (repo *MemoryCustomerRepository) List(ctx context.Context, query listing.Query) (listing.Result[Customer], error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return listing.Apply(repo.customers[tenant.ID(ctx)], query)
+}
+
+func (repo *MemoryCustomerRepository) Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return search.Rank(search.Customers, query, repo.customers[tenant.ID(ctx)], limit)
+}
+
+func (repo *MemoryCustomerRepository) Get(ctx context.Context, id string) (Customer, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, customer := range repo.customers[tenant.ID(ctx)] {
+		if customer.ID.Hex() == id || customer.CustomerID == id {
+			return customer, nil
+		}
+	}
+	return Customer{}, ErrNotFound
+}
+
+// MemoryOrderRepository keeps orders in process memory
+type MemoryOrderRepository struct {
+	mu     sync.RWMutex
+	orders map[string][]Order
+	count  map[string]int
+}
+
+// NewMemoryOrderRepository creates a repository holding orders
+func NewMemoryOrderRepository(orders ...Order) *MemoryOrderRepository {
+	return &MemoryOrderRepository{
+		orders: map[string][]Order{"": orders},
+		count:  map[string]int{"": len(orders)},
+	}
+}
+
+func (repo *MemoryOrderRepository) List(ctx context.Context, query listing.Query) (listing.Result[Order], error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return listing.Apply(repo.orders[tenant.ID(ctx)], query)
+}
+
+func (repo *MemoryOrderRepository) Get(ctx context.Context, id primitive.ObjectID) (Order, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, order := range repo.orders[tenant.ID(ctx)] {
+		if order.ID == id {
+			return order, nil
+		}
+	}
+	return Order{}, ErrNotFound
+}
+
+func (repo *MemoryOrderRepository) Create(ctx context.Context, order *Order) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	key := tenant.ID(ctx)
+	repo.count[key]++
+	order.TransactionID = fmt.Sprintf("TRX-%05d", repo.count[key])
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
+	repo.orders[key] = append(repo.orders[key], *order)
+	return nil
+}
+
+func (repo *MemoryOrderRepository) SetStatus(ctx context.Context, id primitive.ObjectID, from string, change StatusChange) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	orders := repo.orders[tenant.ID(ctx)]
+	for i := range orders {
+		order := &orders[i]
+		if order.ID != id {
+			continue
+		}
+		if order.Status != from {
+			return ErrConflict
+		}
+		order.Status = change.Status
+		if settles(change.Status) {
+			order.SettlementPending = true
+		}
+		order.UpdatedAt = change.At
+		order.StatusHistory = append(order.StatusHistory, change)
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (repo *MemoryOrderRepository) Settled(ctx context.Context, id primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	orders := repo.orders[tenant.ID(ctx)]
+	for i := range orders {
+		if orders[i].ID == id {
+			orders[i].SettlementPending = false
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// MemoryPointsRepository keeps the points ledger in process memory
+type MemoryPointsRepository struct {
+	mu      sync.RWMutex
+	entries map[string][]PointsEntry
+}
+
+// NewMemoryPointsRepository creates a repository holding entries
+func NewMemoryPointsRepository(entries ...PointsEntry) *MemoryPointsRepository {
+	return &MemoryPointsRepository{entries: map[string][]PointsEntry{"": entries}}
+}
+
+func (repo *MemoryPointsRepository) Balance(ctx context.Context, customerID string) (PointsBalance, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	balance := PointsBalance{CustomerID: customerID}
+	for _, entry := range repo.entries[tenant.ID(ctx)] {
+		if entry.CustomerID == customerID {
+			balance = balance.apply(entry)
+		}
+	}
+	return balance, nil
+}
+
+func (repo *MemoryPointsRepository) History(ctx context.Context, customerID string, query listing.Query) (listing.Result[PointsEntry], error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	query.Conditions = append(query.Conditions, listing.Condition{Path: "customerId", Op: listing.Eq, Value: customerID})
+	return listing.Apply(repo.entries[tenant.ID(ctx)], query)
+}
+
+func (repo *MemoryPointsRepository) Append(ctx context.Context, balance PointsBalance, entry PointsEntry) (PointsEntry, PointsBalance, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	entry.Seq = balance.Seq + 1
+	for _, existing := range repo.entries[tenant.ID(ctx)] {
+		if existing.ID == entry.ID || existing.CustomerID == entry.CustomerID && existing.Seq == entry.Seq {
+			return PointsEntry{}, PointsBalance{}, ErrConflict
+		}
+	}
+	key := tenant.ID(ctx)
+	repo.entries[key] = append(repo.entries[key], entry)
+	return entry, balance.apply(entry), nil
+}
+
+// MemoryPointsAwardRepository keeps points awards in process memory
+type MemoryPointsAwardRepository struct {
+	mu     sync.RWMutex
+	awards map[string][]PointsAward
+}
+
+// NewMemoryPointsAwardRepository creates a repository holding awards
+func NewMemoryPointsAwardRepository(awards ...PointsAward) *MemoryPointsAwardRepository {
+	return &MemoryPointsAwardRepository{awards: map[string][]PointsAward{"": awards}}
+}
+
+func (repo *MemoryPointsAwardRepository) List(ctx context.Context, query listing.Query) (listing.Result[PointsAward], error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return listing.Apply(repo.awards[tenant.ID(ctx)], query)
+}
+
+func (repo *MemoryPointsAwardRepository) Get(ctx context.Context, id primitive.ObjectID) (PointsAward, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, award := range repo.awards[tenant.ID(ctx)] {
+		if award.ID == id {
+			return award, nil
+		}
+	}
+	return PointsAward{}, ErrNotFound
+}
+
+func (repo *MemoryPointsAwardRepository) Create(ctx context.Context, award *PointsAward) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, existing := range repo.awards[tenant.ID(ctx)] {
+		if existing.OrderID == award.OrderID {
+			return ErrConflict
+		}
+	}
+	if award.ID.IsZero() {
+		award.ID = primitive.NewObjectID()
+	}
+	key := tenant.ID(ctx)
+	repo.awards[key] = append(repo.awards[key], *award)
+	return nil
+}
+
+func (repo *MemoryPointsAwardRepository) Decide(ctx context.Context, id primitive.ObjectID, from string, decision AwardDecision) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	awards := repo.awards[tenant.ID(ctx)]
+	for i := range awards {
+		award := &awards[i]
+		if award.ID != id {
+			continue
+		}
+		if award.Status != from {
+			return ErrConflict
+		}
+		award.AwardDecision = decision
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (repo *MemoryPointsAwardRepository) Credited(ctx context.Context, id primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	awards := repo.awards[tenant.ID(ctx)]
+	for i := range awards {
+		if awards[i].ID == id {
+			awards[i].CreditPending = false
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// MemoryStockRepository keeps the stock ledger in process memory
+type MemoryStockRepository struct {
+	mu        sync.RWMutex
+	movements map[string][]StockMovement
+}
+
+// NewMemoryStockRepository creates a repository holding movements
+func NewMemoryStockRepository(movements ...StockMovement) *MemoryStockRepository {
+	return &MemoryStockRepository{movements: map[string][]StockMovement{"": movements}}
+}
+
+func (repo *MemoryStockRepository) List(ctx context.Context, query listing.Query) (listing.Result[StockMovement], error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return listing.Apply(repo.movements[tenant.ID(ctx)], query)
+}
+
+func (repo *MemoryStockRepository) Append(ctx context.Context, movements []StockMovement) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	key := tenant.ID(ctx)
+	for _, movement := range movements {
+		recorded := movement.Type == StockSale && slices.ContainsFunc(repo.movements[key], func(m StockMovement) bool {
+			return m.Type == StockSale && m.OrderID == movement.OrderID &&
+				m.ProductID == movement.ProductID && m.VariantID == movement.VariantID
+		})
+		if !recorded {
+			repo.movements[key] = append(repo.movements[key], movement)
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryStockRepository) Levels(ctx context.Context, productID string) ([]StockLevel, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	levels := []StockLevel{}
+	for _, movement := range repo.movements[tenant.ID(ctx)] {
+		if productID != "" && movement.ProductID != productID {
+			continue
+		}
+		i := slices.IndexFunc(levels, func(l StockLevel) bool {
+			return l.ProductID == movement.ProductID && l.VariantID == movement.VariantID
+		})
+		if i < 0 {
+			levels = append(levels, StockLevel{ProductID: movement.ProductID, VariantID: movement.VariantID})
+			i = len(levels) - 1
+		}
+		levels[i].add(movement)
+	}
+	slices.SortFunc(levels, func(a, b StockLevel) int {
+		return cmp.Or(strings.Compare(a.ProductName, b.ProductName), strings.Compare(a.VariantName, b.VariantName))
+	})
+	return levels, nil
+}
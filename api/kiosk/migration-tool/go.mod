@@ -1,6 +1,6 @@
 module kiosk-migration
 
-go 1.24
+go 1.24.0
 
 require (
 	go.mongodb.org/mongo-driver v1.12.1
@@ -0,0 +1,367 @@
+package kiosk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/auth"
+	"isy-api/listing"
+	"isy-api/response"
+	"isy-api/validate"
+)
+
+// Order statuses. An order starts pending and ends completed or cancelled.
+const (
+	StatusPending   = "pending"
+	StatusPreparing = "preparing"
+	StatusReady     = "ready"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+// CodeInvalidTransition is returned for a status change the order's current
+// status does not allow
+const CodeInvalidTransition response.Code = "invalid_transition"
+
+// orderTransitions lists the statuses each status may move to. Completed and
+// cancelled orders are final.
+var orderTransitions = map[string][]string{
+	StatusPending:   {StatusPreparing, StatusCancelled},
+	StatusPreparing: {StatusReady, StatusCancelled},
+	StatusReady:     {StatusCompleted, StatusCancelled},
+}
+
+// CanTransition reports whether an order in status from may move to to
+func CanTransition(from, to string) bool {
+	return slices.Contains(orderTransitions[from], to)
+}
+
+// settles reports whether an order entering status has points and stock to
+// settle
+func settles(status string) bool {
+	return status == StatusCompleted || status == StatusCancelled
+}
+
+// maxSettlementBatch bounds the orders one SettleOrders call retries
+const maxSettlementBatch = 100
+
+var orderListing = listing.Spec{
+	Fields: []listing.Field{
+		{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+		{Name: "transactionId", Type: listing.String},
+		{Name: "customerId", Type: listing.String},
+		{Name: "status", Type: listing.String},
+		{Name: "paymentMethod", Type: listing.String},
+		{Name: "finalTotal", Type: listing.Number, Sortable: true},
+		{Name: "createdAt", Type: listing.Time, Sortable: true},
+		{Name: "updatedAt", Type: listing.Time, Sortable: true},
+	},
+	// The counter staff works through the newest orders
+	DefaultSort: "-createdAt",
+}
+
+var errInvalidOrderID = response.New(http.StatusBadRequest, response.CodeInvalidID, "Invalid order ID")
+
+// OrderRequest is the body of CreateOrder. Its cart is priced by the
+// server; clients cannot send prices or totals.
+type OrderRequest struct {
+	Cart
+	PaymentMethod string `json:"paymentMethod"`
+	Notes         string `json:"notes"`
+	// Points is how many of the customer's points to redeem
+	Points float64 `json:"points"`
+}
+
+// StatusRequest is the body of UpdateOrderStatus
+type StatusRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// GetOrders lists orders a page at a time, newest first unless sorted
+// otherwise
+func (kh *KioskHandlers) GetOrders(w http.ResponseWriter, r *http.Request) {
+	query, err := listing.Parse(r, orderListing)
+	if err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	result, err := kh.Orders.List(r.Context(), query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch orders")
+		return
+	}
+
+	response.List(w, r, result.Items, result.Page())
+}
+
+// GetOrder retrieves a single order by ID
+func (kh *KioskHandlers) GetOrder(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidOrderID)
+		return
+	}
+
+	order, err := kh.Orders.Get(r.Context(), objID)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch order")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, order)
+}
+
+// CreateOrder prices the cart like Quote and records a pending order
+func (kh *KioskHandlers) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	var req OrderRequest
+	if err := validate.DecodeInto(r, orderSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	quote, err := kh.Pricing.Quote(r.Context(), req.Cart)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to price order")
+		return
+	}
+
+	ctx := r.Context()
+	order := orderFromQuote(quote)
+	if req.Points > 0 {
+		if !kh.redeemPoints(w, r, &order, quote.Member, req.Points) {
+			return
+		}
+	}
+
+	now := time.Now()
+	order.PaymentMethod = req.PaymentMethod
+	order.Notes = req.Notes
+	order.Status = StatusPending
+	order.StatusHistory = []StatusChange{{Status: StatusPending, At: now, By: actor(ctx)}}
+	order.CreatedAt = now
+	order.UpdatedAt = now
+
+	if err := kh.Orders.Create(ctx, &order); err != nil {
+		if order.PointsRedeemed > 0 {
+			if err := kh.Points.Refund(ctx, order.CustomerID, order.PointsRedeemed, order.ID, "Order was not saved", actor(ctx)); err != nil {
+				log.Printf("Failed to refund %g points to %s: %v", order.PointsRedeemed, order.CustomerID, err)
+			}
+		}
+		respondWithRepositoryError(w, r, err, "Failed to create order")
+		return
+	}
+
+	response.JSON(w, r, http.StatusCreated, order)
+}
+
+// redeemPoints spends points of the order's customer on the amount due.
+// The order gets its ID here so the ledger entry can refer to it. It
+// reports whether the order can go ahead; if not, the response is written.
+func (kh *KioskHandlers) redeemPoints(w http.ResponseWriter, r *http.Request, order *Order, member bool, points float64) bool {
+	invalid := func(message string) bool {
+		response.WriteError(w, r, response.New(http.StatusBadRequest, response.CodeValidationFailed, "Invalid order").
+			WithFields(response.FieldError{Field: "points", Code: validate.CodeInvalidValue, Message: message}))
+		return false
+	}
+	if !member {
+		return invalid("only members can redeem points")
+	}
+	value := roundCents(points * kh.Points.Rules.Value)
+	if value > order.FinalTotal {
+		return invalid(fmt.Sprintf("are worth %g, more than the %g due", value, order.FinalTotal))
+	}
+
+	order.ID = primitive.NewObjectID()
+	if err := kh.Points.Redeem(r.Context(), order.CustomerID, points, order.ID, actor(r.Context())); err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to redeem points")
+		return false
+	}
+	order.PointsRedeemed = points
+	order.PointsValue = value
+	order.FinalTotal = roundCents(order.FinalTotal - value)
+	return true
+}
+
+// UpdateOrderStatus moves an order to the next status of its lifecycle
+func (kh *KioskHandlers) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		response.WriteError(w, r, errInvalidOrderID)
+		return
+	}
+
+	var req StatusRequest
+	if err := validate.DecodeInto(r, orderStatusSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	order, err := kh.Orders.Get(ctx, objID)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch order")
+		return
+	}
+
+	if !CanTransition(order.Status, req.Status) {
+		allowed := orderTransitions[order.Status]
+		if allowed == nil {
+			allowed = []string{}
+		}
+		response.WriteError(w, r, response.New(http.StatusConflict, CodeInvalidTransition,
+			fmt.Sprintf("An order that is %s cannot become %s", order.Status, req.Status)).
+			WithDetails(map[string]interface{}{"status": order.Status, "allowed": allowed}))
+		return
+	}
+
+	change := StatusChange{Status: req.Status, At: time.Now(), By: actor(ctx), Note: req.Note}
+	if err := kh.Orders.SetStatus(ctx, objID, order.Status, change); err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to update order")
+		return
+	}
+
+	order.Status = change.Status
+	order.SettlementPending = settles(change.Status)
+	order.UpdatedAt = change.At
+	order.StatusHistory = append(order.StatusHistory, change)
+	if order.SettlementPending {
+		// The status has changed either way; an order left pending is
+		// settled again by SettleOrders
+		if err := kh.settle(ctx, &order); err != nil {
+			log.Printf("Failed to settle order %s: %v", order.TransactionID, err)
+		}
+	}
+	response.JSON(w, r, http.StatusOK, order)
+}
+
+// SettlementResult reports the settlement of one order by SettleOrders
+type SettlementResult struct {
+	ID            primitive.ObjectID `json:"id"`
+	TransactionID string             `json:"transactionId"`
+	Settled       bool               `json:"settled"`
+	Error         string             `json:"error,omitempty"`
+}
+
+// SettleOrders settles again the completed and cancelled orders whose
+// points or stock could not be settled when their status changed, up to
+// maxSettlementBatch at a time, oldest first
+func (kh *KioskHandlers) SettleOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	pending, err := kh.Orders.List(ctx, listing.Query{
+		Conditions: []listing.Condition{{Path: "settlementPending", Op: listing.Eq, Value: true}},
+		SortPath:   "_id",
+		Limit:      maxSettlementBatch,
+	})
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch orders")
+		return
+	}
+
+	results := make([]SettlementResult, 0, len(pending.Items))
+	for _, order := range pending.Items {
+		result := SettlementResult{ID: order.ID, TransactionID: order.TransactionID, Settled: true}
+		if err := kh.settle(ctx, &order); err != nil {
+			result.Settled, result.Error = false, err.Error()
+		}
+		results = append(results, result)
+	}
+
+	response.JSON(w, r, http.StatusOK, results)
+}
+
+// settle settles the points and stock of a final order and clears its
+// SettlementPending mark. Each step records what it did against the order,
+// so settling an order again only completes what failed before.
+func (kh *KioskHandlers) settle(ctx context.Context, order *Order) error {
+	if err := kh.settlePoints(ctx, *order); err != nil {
+		return fmt.Errorf("settle points: %w", err)
+	}
+	if err := kh.settleStock(ctx, *order); err != nil {
+		return fmt.Errorf("settle stock: %w", err)
+	}
+	if err := kh.Orders.Settled(ctx, order.ID); err != nil {
+		return err
+	}
+	order.SettlementPending = false
+	return nil
+}
+
+// settlePoints credits the points a completed order earns its customer, or
+// proposes them for approval if that is required, and returns the points a
+// cancelled order redeemed. Each is posted once per order.
+func (kh *KioskHandlers) settlePoints(ctx context.Context, order Order) error {
+	if order.CustomerID == "" {
+		return nil
+	}
+
+	switch order.Status {
+	case StatusCompleted:
+		customer, err := kh.Customers.Get(ctx, order.CustomerID)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Walk-in customers have no member ID and earn nothing
+		switch {
+		case customer.MemberID == "":
+		case kh.Points.Rules.RequireApproval:
+			if kh.Points.Earned(order) > 0 {
+				if _, err := kh.Points.Propose(ctx, order, customer, actor(ctx)); err != nil && !errors.Is(err, errAlreadyAwarded) {
+					return err
+				}
+			}
+		default:
+			return kh.Points.Earn(ctx, order, actor(ctx))
+		}
+	case StatusCancelled:
+		if order.PointsRedeemed > 0 {
+			return kh.Points.Refund(ctx, order.CustomerID, order.PointsRedeemed, order.ID, "Order "+order.TransactionID+" cancelled", actor(ctx))
+		}
+	}
+	return nil
+}
+
+// orderFromQuote records the lines and totals of quote on a new order
+func orderFromQuote(quote Quote) Order {
+	order := Order{
+		CustomerID: quote.CustomerID,
+		Total:      quote.Total,
+		Discount:   quote.Discount,
+		Rounding:   quote.Rounding,
+		FinalTotal: quote.FinalTotal,
+	}
+	for _, line := range quote.Lines {
+		order.Items = append(order.Items, OrderItem{
+			ProductID:   line.ProductID,
+			ProductName: line.ProductName,
+			VariantID:   line.VariantID,
+			VariantName: line.VariantName,
+			CategoryID:  line.CategoryID,
+			Quantity:    line.Quantity,
+			Price:       line.ListPrice,
+			Total:       line.Total,
+			Discount:    line.Discount,
+		})
+	}
+	return order
+}
+
+// actor names the caller authenticated on ctx for status histories
+func actor(ctx context.Context) string {
+	if claims, ok := auth.ClaimsFromContext(ctx); ok {
+		return claims.Username
+	}
+	return ""
+}
@@ -0,0 +1,233 @@
+package kiosk
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/apitest"
+	"isy-api/config"
+	"isy-api/listing"
+)
+
+func TestCanTransition(t *testing.T) {
+	statuses := []string{StatusPending, StatusPreparing, StatusReady, StatusCompleted, StatusCancelled}
+	allowed := map[[2]string]bool{
+		{StatusPending, StatusPreparing}:   true,
+		{StatusPending, StatusCancelled}:   true,
+		{StatusPreparing, StatusReady}:     true,
+		{StatusPreparing, StatusCancelled}: true,
+		{StatusReady, StatusCompleted}:     true,
+		{StatusReady, StatusCancelled}:     true,
+	}
+	for _, from := range append(statuses, "unknown") {
+		for _, to := range append(statuses, "unknown") {
+			if got, want := CanTransition(from, to), allowed[[2]string{from, to}]; got != want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	product := Product{ID: primitive.NewObjectID(), Name: "Latte", CategoryID: "drinks", Price: 4.5, IsActive: true}
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, []Product{product}, nil)
+
+	cart := `{"items":[{"productId":"` + product.ID.Hex() + `","quantity":2}]}`
+	status, body := apitest.Serve(t, kh.CreateOrder, apitest.Request("POST", "/orders", cart))
+	if status != http.StatusCreated {
+		t.Fatalf("CreateOrder = %d %s, want 201", status, body.Code)
+	}
+	var order Order
+	body.Decode(t, &order)
+	if order.Status != StatusPending || order.FinalTotal != 9 {
+		t.Fatalf("created %+v", order)
+	}
+
+	id := map[string]string{"id": order.ID.Hex()}
+	status, body = apitest.Serve(t, kh.GetOrder, apitest.WithVars(apitest.Request("GET", "/orders/"+order.ID.Hex(), ""), id))
+	if status != http.StatusOK {
+		t.Fatalf("GetOrder = %d %s, want 200", status, body.Code)
+	}
+
+	status, body = apitest.Serve(t, kh.UpdateOrderStatus, apitest.WithVars(apitest.As(apitest.Request("PUT", "/orders/"+order.ID.Hex()+"/status", `{"status":"completed"}`), apitest.Admin), id))
+	if status != http.StatusConflict || body.Code != string(CodeInvalidTransition) {
+		t.Fatalf("skipping to completed = %d %s, want 409 %s", status, body.Code, CodeInvalidTransition)
+	}
+	status, body = apitest.Serve(t, kh.UpdateOrderStatus, apitest.WithVars(apitest.As(apitest.Request("PUT", "/orders/"+order.ID.Hex()+"/status", `{"status":"preparing"}`), apitest.Admin), id))
+	body.Decode(t, &order)
+	if status != http.StatusOK || order.Status != StatusPreparing || len(order.StatusHistory) != 2 {
+		t.Fatalf("UpdateOrderStatus = %d %+v", status, order)
+	}
+}
+
+func TestOrderNotFound(t *testing.T) {
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, nil, nil)
+
+	missing := primitive.NewObjectID().Hex()
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		id      string
+		want    int
+	}{
+		{"get unknown", kh.GetOrder, "GET", "", missing, http.StatusNotFound},
+		{"get malformed", kh.GetOrder, "GET", "", "nope", http.StatusBadRequest},
+		{"status of unknown", kh.UpdateOrderStatus, "PUT", `{"status":"preparing"}`, missing, http.StatusNotFound},
+		{"unknown product", kh.CreateOrder, "POST", `{"items":[{"productId":"` + missing + `","quantity":1}]}`, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := apitest.Serve(t, tt.handler, apitest.WithVars(apitest.As(apitest.Request(tt.method, "/orders", tt.body), apitest.Admin), map[string]string{"id": tt.id}))
+			if status != tt.want {
+				t.Errorf("status = %d %s, want %d", status, body.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrdersPerTenant(t *testing.T) {
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, nil, nil)
+	create := apitest.ForTenant(apitest.Request("POST", "/products", `{"name":"Latte","categoryId":"drinks","price":4.5,"isActive":true}`), "north")
+	status, body := apitest.Serve(t, kh.CreateProduct, create)
+	if status != http.StatusCreated {
+		t.Fatalf("CreateProduct = %d %s, want 201", status, body.Code)
+	}
+	var product Product
+	body.Decode(t, &product)
+
+	// Another tenant cannot order the product
+	cart := `{"items":[{"productId":"` + product.ID.Hex() + `","quantity":1}]}`
+	if status, _ := apitest.Serve(t, kh.CreateOrder, apitest.ForTenant(apitest.Request("POST", "/orders", cart), "south")); status != http.StatusBadRequest {
+		t.Errorf("ordering another tenant's product = %d, want 400", status)
+	}
+	status, body = apitest.Serve(t, kh.CreateOrder, apitest.ForTenant(apitest.Request("POST", "/orders", cart), "north"))
+	if status != http.StatusCreated {
+		t.Fatalf("CreateOrder = %d %s, want 201", status, body.Code)
+	}
+	var order Order
+	body.Decode(t, &order)
+
+	id := map[string]string{"id": order.ID.Hex()}
+	for tenantID, want := range map[string]int{"north": http.StatusOK, "south": http.StatusNotFound, "": http.StatusNotFound} {
+		if status, _ := apitest.Serve(t, kh.GetOrder, apitest.WithVars(apitest.ForTenant(apitest.Request("GET", "/orders/"+order.ID.Hex(), ""), tenantID), id)); status != want {
+			t.Errorf("tenant %q order = %d, want %d", tenantID, status, want)
+		}
+	}
+}
+
+// flakyStock fails Append while err is set
+type flakyStock struct {
+	StockRepository
+	err error
+}
+
+func (s *flakyStock) Append(ctx context.Context, movements []StockMovement) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.StockRepository.Append(ctx, movements)
+}
+
+func TestSettlementRetry(t *testing.T) {
+	product := Product{ID: primitive.NewObjectID(), Name: "Latte", CategoryID: "drinks", Price: 400, IsActive: true}
+	customer := Customer{ID: primitive.NewObjectID(), CustomerID: "C-1", Name: "Ana", MemberID: "M-1", IsActive: true}
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, []Product{product}, []Customer{customer})
+	stock := &flakyStock{StockRepository: kh.Stock, err: ErrUnavailable}
+	kh.Stock = stock
+
+	cart := `{"customerId":"C-1","items":[{"productId":"` + product.ID.Hex() + `","quantity":1}]}`
+	status, body := apitest.Serve(t, kh.CreateOrder, apitest.Request("POST", "/orders", cart))
+	if status != http.StatusCreated {
+		t.Fatalf("CreateOrder = %d %s, want 201", status, body.Code)
+	}
+	var order Order
+	body.Decode(t, &order)
+	id := map[string]string{"id": order.ID.Hex()}
+	for _, next := range []string{StatusPreparing, StatusReady, StatusCompleted} {
+		status, body = apitest.Serve(t, kh.UpdateOrderStatus, apitest.WithVars(apitest.As(apitest.Request("PUT", "/orders/"+order.ID.Hex()+"/status", `{"status":"`+next+`"}`), apitest.Admin), id))
+		if status != http.StatusOK {
+			t.Fatalf("moving to %s = %d %s", next, status, body.Code)
+		}
+	}
+	body.Decode(t, &order)
+	if !order.SettlementPending {
+		t.Fatal("order whose stock failed to settle is not pending settlement")
+	}
+
+	settle := func() []SettlementResult {
+		t.Helper()
+		status, body := apitest.Serve(t, kh.SettleOrders, apitest.Request("POST", "/orders/settlements", ""))
+		if status != http.StatusOK {
+			t.Fatalf("SettleOrders = %d %s", status, body.Code)
+		}
+		var results []SettlementResult
+		body.Decode(t, &results)
+		return results
+	}
+
+	if results := settle(); len(results) != 1 || results[0].Settled {
+		t.Fatalf("settling while stock is down = %+v, want one failure", results)
+	}
+	stock.err = nil
+	if results := settle(); len(results) != 1 || !results[0].Settled {
+		t.Fatalf("settling = %+v, want one success", results)
+	}
+	if results := settle(); len(results) != 0 {
+		t.Fatalf("settling again = %+v, want nothing pending", results)
+	}
+
+	// The earlier attempts credited points before stock failed; the retries
+	// did not credit them again
+	balance, err := kh.Points.Balance(context.Background(), customer.ID.Hex())
+	if err != nil || balance.Earned != 4 {
+		t.Errorf("earned %g (%v), want 4 once", balance.Earned, err)
+	}
+	movements, err := kh.Stock.List(context.Background(), listing.Query{SortPath: "_id", Limit: 10})
+	if err != nil || movements.Total != 1 || movements.Items[0].Quantity != -1 {
+		t.Errorf("stock movements = %+v (%v), want one sale of 1", movements.Items, err)
+	}
+	stored, err := kh.Orders.Get(context.Background(), order.ID)
+	if err != nil || stored.SettlementPending {
+		t.Errorf("stored order pending = %v (%v), want settled", stored.SettlementPending, err)
+	}
+}
+
+func TestCancelRefundsOnce(t *testing.T) {
+	product := Product{ID: primitive.NewObjectID(), Name: "Latte", CategoryID: "drinks", Price: 400, IsActive: true}
+	customer := Customer{ID: primitive.NewObjectID(), CustomerID: "C-1", Name: "Ana", MemberID: "M-1", IsActive: true}
+	kh := NewMemoryKioskHandlers(config.Default().Kiosk, []Product{product}, []Customer{customer})
+	ctx := context.Background()
+	if _, _, err := kh.Points.post(ctx, customer.ID.Hex(), PointsEntry{Type: PointsAdjust, Points: 10}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cart := `{"customerId":"C-1","points":10,"items":[{"productId":"` + product.ID.Hex() + `","quantity":1}]}`
+	status, body := apitest.Serve(t, kh.CreateOrder, apitest.Request("POST", "/orders", cart))
+	if status != http.StatusCreated {
+		t.Fatalf("CreateOrder = %d %s, want 201", status, body.Code)
+	}
+	var order Order
+	body.Decode(t, &order)
+	id := map[string]string{"id": order.ID.Hex()}
+	if status, body := apitest.Serve(t, kh.UpdateOrderStatus, apitest.WithVars(apitest.As(apitest.Request("PUT", "/", `{"status":"cancelled"}`), apitest.Admin), id)); status != http.StatusOK {
+		t.Fatalf("cancelling = %d %s", status, body.Code)
+	}
+
+	// Settling the cancelled order again does not refund twice
+	stored, err := kh.Orders.Get(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kh.settle(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := kh.Points.Balance(ctx, customer.ID.Hex())
+	if err != nil || balance.Balance != 10 || balance.Redeemed != 0 {
+		t.Errorf("balance %+v (%v), want 10 with nothing redeemed", balance, err)
+	}
+}
@@ -0,0 +1,470 @@
+package kiosk
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/config"
+	"isy-api/listing"
+	"isy-api/response"
+	"isy-api/validate"
+)
+
+// Points entry types. Credits add points and debits remove them; a refund
+// returns the points an order redeemed when it is cancelled.
+const (
+	PointsEarn    = "earn"
+	PointsRedeem  = "redeem"
+	PointsRefund  = "refund"
+	PointsExpire  = "expire"
+	PointsAdjust  = "adjust"
+	PointsOpening = "opening"
+)
+
+// ErrInsufficientPoints is returned when a debit exceeds the balance
+var ErrInsufficientPoints = errors.New("insufficient points")
+
+// CodeInsufficientPoints is returned for a redemption above the balance
+const CodeInsufficientPoints response.Code = "insufficient_points"
+
+// postAttempts bounds the retries of a ledger write that raced another
+const postAttempts = 3
+
+// PointsEntry is an immutable line of a customer's points ledger
+type PointsEntry struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	CustomerID string             `bson:"customerId" json:"customerId"`
+	// Seq numbers a customer's entries from 1 without gaps. It is unique per
+	// customer, so two writers cannot both spend the same balance.
+	Seq  int64  `bson:"seq" json:"seq"`
+	Type string `bson:"type" json:"type"`
+	// Points is positive for credits and negative for debits
+	Points  float64 `bson:"points" json:"points"`
+	OrderID string  `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	// Lot is the credit an expire entry closes
+	Lot *primitive.ObjectID `bson:"lot,omitempty" json:"lot,omitempty"`
+	// ExpiresAt is set on credits that can only be redeemed until then
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	Reason    string     `bson:"reason,omitempty" json:"reason,omitempty"`
+	By        string     `bson:"by,omitempty" json:"by,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+}
+
+// PointsLot is the part of a credit that is neither spent nor expired
+type PointsLot struct {
+	Entry     primitive.ObjectID `bson:"entry" json:"entry"`
+	Remaining float64            `bson:"remaining" json:"remaining"`
+	ExpiresAt *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+}
+
+// PointsBalance is a snapshot of a customer's ledger after entry Seq. The
+// ledger is the record; snapshots only save replaying it.
+type PointsBalance struct {
+	CustomerID string  `bson:"_id" json:"customerId"`
+	Seq        int64   `bson:"seq" json:"-"`
+	Balance    float64 `bson:"balance" json:"balance"`
+	Earned     float64 `bson:"earned" json:"earned"`
+	// Redeemed is net of refunds
+	Redeemed  float64     `bson:"redeemed" json:"redeemed"`
+	Expired   float64     `bson:"expired" json:"expired"`
+	Lots      []PointsLot `bson:"lots" json:"-"`
+	UpdatedAt time.Time   `bson:"updatedAt" json:"updatedAt"`
+}
+
+// apply returns the snapshot after entry. Debits spend the lots that expire
+// first, so members lose as few points as possible to expiry.
+func (b PointsBalance) apply(entry PointsEntry) PointsBalance {
+	b.Lots = slices.Clone(b.Lots)
+	b.Seq = entry.Seq
+	b.Balance = roundPoints(b.Balance + entry.Points)
+	b.UpdatedAt = entry.CreatedAt
+
+	switch {
+	case entry.Type == PointsExpire:
+		b.Expired = roundPoints(b.Expired - entry.Points)
+		for i := range b.Lots {
+			if entry.Lot != nil && b.Lots[i].Entry == *entry.Lot {
+				b.Lots[i].Remaining = roundPoints(b.Lots[i].Remaining + entry.Points)
+			}
+		}
+	case entry.Points > 0:
+		switch entry.Type {
+		case PointsEarn:
+			b.Earned = roundPoints(b.Earned + entry.Points)
+		case PointsRefund:
+			b.Redeemed = roundPoints(b.Redeemed - entry.Points)
+		}
+		b.Lots = append(b.Lots, PointsLot{Entry: entry.ID, Remaining: entry.Points, ExpiresAt: entry.ExpiresAt})
+		slices.SortStableFunc(b.Lots, func(x, y PointsLot) int {
+			switch {
+			case x.ExpiresAt == nil && y.ExpiresAt == nil:
+				return 0
+			case x.ExpiresAt == nil:
+				return 1
+			case y.ExpiresAt == nil:
+				return -1
+			}
+			return x.ExpiresAt.Compare(*y.ExpiresAt)
+		})
+	default:
+		if entry.Type == PointsRedeem {
+			b.Redeemed = roundPoints(b.Redeemed - entry.Points)
+		}
+		owed := -entry.Points
+		for i := range b.Lots {
+			spent := math.Min(owed, b.Lots[i].Remaining)
+			b.Lots[i].Remaining = roundPoints(b.Lots[i].Remaining - spent)
+			if owed = roundPoints(owed - spent); owed <= 0 {
+				break
+			}
+		}
+	}
+
+	b.Lots = slices.DeleteFunc(b.Lots, func(lot PointsLot) bool { return lot.Remaining <= 0 })
+	return b
+}
+
+// Tier is a loyalty level reached at a balance of Points
+type Tier struct {
+	Name   string  `json:"name"`
+	Points float64 `json:"points"`
+}
+
+// Tiers lists the loyalty levels from lowest to highest
+var Tiers = []Tier{
+	{Name: "Bronze", Points: 0},
+	{Name: "Silver", Points: 500},
+	{Name: "Gold", Points: 1000},
+	{Name: "Platinum", Points: 2000},
+}
+
+// TierFor returns the tier a balance reaches and the one after it, nil at
+// the top
+func TierFor(balance float64) (Tier, *Tier) {
+	current := 0
+	for i, tier := range Tiers {
+		if balance >= tier.Points {
+			current = i
+		}
+	}
+	if current+1 < len(Tiers) {
+		return Tiers[current], &Tiers[current+1]
+	}
+	return Tiers[current], nil
+}
+
+// Points posts to the loyalty ledger under the configured earn, redeem and
+// expiry rules
+type Points struct {
+	Ledger PointsRepository
+	// Awards holds earned points awaiting approval
+	Awards PointsAwardRepository
+	Rules  config.Points
+}
+
+// Balance returns the customer's current balance without the lots that are
+// due. Reading writes nothing: their expire entries are recorded by the next
+// post to the customer's ledger.
+func (p *Points) Balance(ctx context.Context, customerID string) (PointsBalance, error) {
+	balance, err := p.Ledger.Balance(ctx, customerID)
+	if err != nil {
+		return PointsBalance{}, err
+	}
+	for _, entry := range expiries(balance, time.Now()) {
+		entry.Seq = balance.Seq
+		balance = balance.apply(entry)
+	}
+	return balance, nil
+}
+
+// expire records the expire entries of balance due at now
+func (p *Points) expire(ctx context.Context, balance PointsBalance, now time.Time) (PointsBalance, error) {
+	for _, entry := range expiries(balance, now) {
+		var err error
+		_, balance, err = p.Ledger.Append(ctx, balance, entry)
+		if err != nil {
+			return PointsBalance{}, err
+		}
+	}
+	return balance, nil
+}
+
+// expiries returns an expire entry for every lot of balance due at now
+func expiries(balance PointsBalance, now time.Time) []PointsEntry {
+	var entries []PointsEntry
+	for _, lot := range balance.Lots {
+		if lot.ExpiresAt == nil || lot.ExpiresAt.After(now) {
+			continue
+		}
+		lotID := lot.Entry
+		entries = append(entries, PointsEntry{
+			CustomerID: balance.CustomerID,
+			Type:       PointsExpire,
+			Points:     -lot.Remaining,
+			Lot:        &lotID,
+			CreatedAt:  now,
+		})
+	}
+	return entries
+}
+
+// post appends entry to the customer's ledger. Debits fail with
+// ErrInsufficientPoints unless allowNegative is set, which only manual
+// adjustments use.
+func (p *Points) post(ctx context.Context, customerID string, entry PointsEntry, allowNegative bool) (PointsEntry, PointsBalance, error) {
+	entry.CustomerID = customerID
+	entry.Points = roundPoints(entry.Points)
+	for attempt := 1; ; attempt++ {
+		posted, balance, err := p.append(ctx, entry, allowNegative)
+		if errors.Is(err, ErrConflict) && attempt < postAttempts {
+			continue
+		}
+		return posted, balance, err
+	}
+}
+
+// postOnce posts entry unless the customer's ledger already has an entry of
+// its type for its order. The check is repeated on every attempt, so of two
+// writers racing to post the same entry the second finds the first's.
+func (p *Points) postOnce(ctx context.Context, customerID string, entry PointsEntry) error {
+	entry.CustomerID = customerID
+	entry.Points = roundPoints(entry.Points)
+	for attempt := 1; ; attempt++ {
+		posted, err := p.posted(ctx, customerID, entry.OrderID, entry.Type)
+		if err != nil || posted {
+			return err
+		}
+		_, _, err = p.append(ctx, entry, false)
+		if errors.Is(err, ErrConflict) && attempt < postAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+// posted reports whether the customer's ledger has an entry of type typ
+// for the order
+func (p *Points) posted(ctx context.Context, customerID, orderID, typ string) (bool, error) {
+	entries, err := p.Ledger.History(ctx, customerID, listing.Query{
+		Conditions: []listing.Condition{
+			{Path: "orderId", Op: listing.Eq, Value: orderID},
+			{Path: "type", Op: listing.Eq, Value: typ},
+		},
+		SortPath: "_id",
+		Limit:    1,
+	})
+	return entries.Total > 0, err
+}
+
+// append is one attempt of post against the current balance, recording
+// the expiries that are due first
+func (p *Points) append(ctx context.Context, entry PointsEntry, allowNegative bool) (PointsEntry, PointsBalance, error) {
+	balance, err := p.Ledger.Balance(ctx, entry.CustomerID)
+	if err != nil {
+		return PointsEntry{}, PointsBalance{}, err
+	}
+	balance, err = p.expire(ctx, balance, time.Now())
+	if err != nil {
+		return PointsEntry{}, PointsBalance{}, err
+	}
+	if entry.Points < 0 && !allowNegative && balance.Balance+entry.Points < 0 {
+		return PointsEntry{}, balance, ErrInsufficientPoints
+	}
+
+	entry.CreatedAt = time.Now()
+	if entry.Points > 0 && entry.Type != PointsAdjust && p.Rules.Expiry > 0 {
+		expiresAt := entry.CreatedAt.Add(p.Rules.Expiry)
+		entry.ExpiresAt = &expiresAt
+	}
+	return p.Ledger.Append(ctx, balance, entry)
+}
+
+// Earn credits a member for a completed order, once
+func (p *Points) Earn(ctx context.Context, order Order, by string) error {
+	points := p.Earned(order)
+	if points <= 0 {
+		return nil
+	}
+
+	return p.postOnce(ctx, order.CustomerID, PointsEntry{
+		Type:    PointsEarn,
+		Points:  points,
+		OrderID: order.ID.Hex(),
+		Reason:  "Order " + order.TransactionID,
+		By:      by,
+	})
+}
+
+// Earned returns the whole points a member earns for order. Points are
+// earned on what was paid, at the rate of each line's category.
+func (p *Points) Earned(order Order) float64 {
+	due := order.FinalTotal + order.PointsValue
+	if due <= 0 {
+		return 0
+	}
+	// Points redeemed on the order were not paid for
+	paid := math.Max(0, order.FinalTotal) / due
+
+	var points float64
+	for _, item := range order.Items {
+		rate, ok := p.Rules.CategoryRates[item.CategoryID]
+		if !ok {
+			rate = p.Rules.EarnRate
+		}
+		points += (item.Total - item.Discount) * paid * rate
+	}
+	// Only whole points are earned
+	return math.Max(0, math.Floor(points+1e-9))
+}
+
+// Redeem debits points spent on an order
+func (p *Points) Redeem(ctx context.Context, customerID string, points float64, orderID primitive.ObjectID, by string) error {
+	_, _, err := p.post(ctx, customerID, PointsEntry{
+		Type:    PointsRedeem,
+		Points:  -points,
+		OrderID: orderID.Hex(),
+		By:      by,
+	}, false)
+	return err
+}
+
+// Refund credits back the points an order redeemed, once
+func (p *Points) Refund(ctx context.Context, customerID string, points float64, orderID primitive.ObjectID, reason, by string) error {
+	return p.postOnce(ctx, customerID, PointsEntry{
+		Type:    PointsRefund,
+		Points:  points,
+		OrderID: orderID.Hex(),
+		Reason:  reason,
+		By:      by,
+	})
+}
+
+// roundPoints keeps ledger arithmetic to hundredths of a point
+func roundPoints(points float64) float64 {
+	return math.Round(points*100) / 100
+}
+
+// pointsListing lists the history parameters of GetCustomerPoints
+var pointsListing = listing.Spec{
+	Fields: []listing.Field{
+		{Name: "seq", Type: listing.Number, Sortable: true},
+		{Name: "type", Type: listing.String},
+		{Name: "orderId", Type: listing.String},
+		{Name: "createdAt", Type: listing.Time, Sortable: true},
+	},
+	DefaultSort: "-seq",
+}
+
+// PointsSummary is the response of GetCustomerPoints
+type PointsSummary struct {
+	PointsBalance
+	Tier     Tier  `json:"tier"`
+	NextTier *Tier `json:"nextTier"`
+	// PointsToNextTier is how many more points reach NextTier
+	PointsToNextTier float64       `json:"pointsToNextTier"`
+	Value            float64       `json:"value"`
+	History          []PointsEntry `json:"history"`
+	Page             response.Page `json:"page"`
+}
+
+// PointsAdjustment is the body of AdjustPoints
+type PointsAdjustment struct {
+	Points float64 `json:"points"`
+	Reason string  `json:"reason"`
+}
+
+// GetCustomerPoints returns a customer's balance, tier and ledger history
+// a page at a time, newest first
+func (kh *KioskHandlers) GetCustomerPoints(w http.ResponseWriter, r *http.Request) {
+	query, err := listing.Parse(r, pointsListing)
+	if err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	customer, err := kh.Customers.Get(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch customer")
+		return
+	}
+
+	customerID := customer.ID.Hex()
+	balance, err := kh.Points.Balance(ctx, customerID)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch points")
+		return
+	}
+	history, err := kh.Points.Ledger.History(ctx, customerID, query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch points history")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, summarizePoints(balance, history, kh.Points.Rules))
+}
+
+// AdjustPoints credits or debits a customer by hand, e.g. to correct a
+// mistake or grant bonus points. Adjustments never expire and may leave a
+// negative balance.
+func (kh *KioskHandlers) AdjustPoints(w http.ResponseWriter, r *http.Request) {
+	var req PointsAdjustment
+	if err := validate.DecodeInto(r, pointsAdjustmentSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+	if req.Points == 0 {
+		response.WriteError(w, r, response.New(http.StatusBadRequest, response.CodeValidationFailed, "Invalid request body").
+			WithFields(response.FieldError{Field: "points", Code: validate.CodeInvalidValue, Message: "must not be zero"}))
+		return
+	}
+
+	ctx := r.Context()
+	customer, err := kh.Customers.Get(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch customer")
+		return
+	}
+
+	entry, balance, err := kh.Points.post(ctx, customer.ID.Hex(), PointsEntry{
+		Type:   PointsAdjust,
+		Points: req.Points,
+		Reason: req.Reason,
+		By:     actor(ctx),
+	}, true)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to adjust points")
+		return
+	}
+
+	// The new entry is the first page of the history; seq counts the ledger
+	history := listing.Result[PointsEntry]{Items: []PointsEntry{entry}, Total: balance.Seq, Limit: 1}
+	summary := summarizePoints(balance, history, kh.Points.Rules)
+	response.JSON(w, r, http.StatusCreated, summary)
+}
+
+func summarizePoints(balance PointsBalance, history listing.Result[PointsEntry], rules config.Points) PointsSummary {
+	tier, next := TierFor(balance.Balance)
+	summary := PointsSummary{
+		PointsBalance: balance,
+		Tier:          tier,
+		NextTier:      next,
+		Value:         roundCents(math.Max(0, balance.Balance) * rules.Value),
+		History:       history.Items,
+		Page:          history.Page(),
+	}
+	if next != nil {
+		summary.PointsToNextTier = roundPoints(next.Points - balance.Balance)
+	}
+	if summary.History == nil {
+		summary.History = []PointsEntry{}
+	}
+	return summary
+}
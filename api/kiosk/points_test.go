@@ -0,0 +1,166 @@
+package kiosk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/config"
+	"isy-api/listing"
+)
+
+func TestPointsBalanceApply(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(days int) *time.Time {
+		t := now.AddDate(0, 0, days)
+		return &t
+	}
+	ids := make([]primitive.ObjectID, 3)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	credit := func(i int, typ string, points float64, expiresAt *time.Time) PointsEntry {
+		return PointsEntry{ID: ids[i], Type: typ, Points: points, ExpiresAt: expiresAt}
+	}
+
+	tests := []struct {
+		name    string
+		entries []PointsEntry
+		want    PointsBalance
+		lots    []PointsLot
+	}{
+		{
+			name:    "credits open lots soonest expiry first",
+			entries: []PointsEntry{credit(0, PointsEarn, 10, at(30)), credit(1, PointsAdjust, 5, nil), credit(2, PointsEarn, 7, at(10))},
+			want:    PointsBalance{Balance: 22, Earned: 17},
+			lots:    []PointsLot{{Entry: ids[2], Remaining: 7, ExpiresAt: at(10)}, {Entry: ids[0], Remaining: 10, ExpiresAt: at(30)}, {Entry: ids[1], Remaining: 5}},
+		},
+		{
+			name:    "debits spend the lots expiring first",
+			entries: []PointsEntry{credit(0, PointsEarn, 10, at(30)), credit(1, PointsAdjust, 5, nil), credit(2, PointsEarn, 7, at(10)), {Type: PointsRedeem, Points: -9}},
+			want:    PointsBalance{Balance: 13, Earned: 17, Redeemed: 9},
+			lots:    []PointsLot{{Entry: ids[0], Remaining: 8, ExpiresAt: at(30)}, {Entry: ids[1], Remaining: 5}},
+		},
+		{
+			name:    "debits past the lots leave none",
+			entries: []PointsEntry{credit(0, PointsEarn, 3, at(5)), {Type: PointsAdjust, Points: -5}},
+			want:    PointsBalance{Balance: -2, Earned: 3},
+		},
+		{
+			name:    "refunds count against redeemed and open a lot",
+			entries: []PointsEntry{credit(0, PointsEarn, 10, nil), {Type: PointsRedeem, Points: -6}, credit(1, PointsRefund, 6, at(5))},
+			want:    PointsBalance{Balance: 10, Earned: 10},
+			lots:    []PointsLot{{Entry: ids[1], Remaining: 6, ExpiresAt: at(5)}, {Entry: ids[0], Remaining: 4}},
+		},
+		{
+			name:    "expiry closes only its lot",
+			entries: []PointsEntry{credit(0, PointsEarn, 10, at(5)), credit(1, PointsEarn, 4, at(9)), {Type: PointsRedeem, Points: -3}, {Type: PointsExpire, Points: -7, Lot: &ids[0]}},
+			want:    PointsBalance{Balance: 4, Earned: 14, Redeemed: 3, Expired: 7},
+			lots:    []PointsLot{{Entry: ids[1], Remaining: 4, ExpiresAt: at(9)}},
+		},
+		{
+			name:    "hundredths of a point",
+			entries: []PointsEntry{credit(0, PointsAdjust, 0.1, nil), credit(1, PointsAdjust, 0.2, nil), {Type: PointsRedeem, Points: -0.3}},
+			want:    PointsBalance{Redeemed: 0.3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var balance PointsBalance
+			for i, entry := range tt.entries {
+				entry.Seq = int64(i + 1)
+				balance = balance.apply(entry)
+			}
+			if balance.Seq != int64(len(tt.entries)) {
+				t.Errorf("seq = %d, want %d", balance.Seq, len(tt.entries))
+			}
+			if balance.Balance != tt.want.Balance || balance.Earned != tt.want.Earned ||
+				balance.Redeemed != tt.want.Redeemed || balance.Expired != tt.want.Expired {
+				t.Errorf("balance %g earned %g redeemed %g expired %g; want %g, %g, %g, %g",
+					balance.Balance, balance.Earned, balance.Redeemed, balance.Expired,
+					tt.want.Balance, tt.want.Earned, tt.want.Redeemed, tt.want.Expired)
+			}
+			if len(balance.Lots) != len(tt.lots) {
+				t.Fatalf("lots = %+v, want %+v", balance.Lots, tt.lots)
+			}
+			for i, lot := range balance.Lots {
+				want := tt.lots[i]
+				if lot.Entry != want.Entry || lot.Remaining != want.Remaining || !sameTime(lot.ExpiresAt, want.ExpiresAt) {
+					t.Errorf("lot %d = %+v, want %+v", i, lot, want)
+				}
+			}
+		})
+	}
+}
+
+// sameTime reports whether a and b are both nil or the same instant
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func TestPointsExpiry(t *testing.T) {
+	ctx := context.Background()
+	ledger := NewMemoryPointsRepository()
+	p := &Points{Ledger: ledger, Rules: config.Points{Value: 1, Expiry: time.Hour}}
+	past := time.Now().Add(-time.Minute)
+
+	// A lot that expired a minute ago, and one that never does
+	if _, _, err := ledger.Append(ctx, PointsBalance{CustomerID: "C"}, PointsEntry{CustomerID: "C", Type: PointsEarn, Points: 10, ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := ledger.Balance(ctx, "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ledger.Append(ctx, balance, PointsEntry{CustomerID: "C", Type: PointsAdjust, Points: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading leaves out the expired lot without writing its entry
+	balance, err = p.Balance(ctx, "C")
+	if err != nil || balance.Balance != 4 || balance.Expired != 10 || balance.Seq != 2 {
+		t.Fatalf("Balance = %+v (%v), want 4 after 10 expired at seq 2", balance, err)
+	}
+	history, err := ledger.History(ctx, "C", listing.Query{SortPath: "seq", Limit: 10})
+	if err != nil || history.Total != 2 {
+		t.Fatalf("history after reading = %d entries (%v), want 2", history.Total, err)
+	}
+
+	// Expired points cannot be spent; the next post records the expiry first
+	if err := p.Redeem(ctx, "C", 5, primitive.NewObjectID(), "tester"); err != ErrInsufficientPoints {
+		t.Fatalf("redeeming expired points = %v, want ErrInsufficientPoints", err)
+	}
+	if err := p.Redeem(ctx, "C", 4, primitive.NewObjectID(), "tester"); err != nil {
+		t.Fatal(err)
+	}
+	history, err = ledger.History(ctx, "C", listing.Query{SortPath: "seq", Limit: 10})
+	if err != nil || history.Total != 4 || history.Items[2].Type != PointsExpire || history.Items[2].Points != -10 {
+		t.Fatalf("history = %+v (%v), want the expiry recorded once before the redemption", history.Items, err)
+	}
+	balance, err = p.Balance(ctx, "C")
+	if err != nil || balance.Balance != 0 || balance.Expired != 10 || balance.Redeemed != 4 {
+		t.Errorf("Balance = %+v (%v), want 0 with 10 expired and 4 redeemed", balance, err)
+	}
+}
+
+func TestPointsEarnExpiresCredits(t *testing.T) {
+	ctx := context.Background()
+	p := &Points{Ledger: NewMemoryPointsRepository(), Rules: config.Points{EarnRate: 0.1, Value: 1, Expiry: 24 * time.Hour}}
+	order := Order{ID: primitive.NewObjectID(), CustomerID: "C", FinalTotal: 50, Items: []OrderItem{{CategoryID: "drinks", Total: 50}}}
+
+	if err := p.Earn(ctx, order, "tester"); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := p.Balance(ctx, "C")
+	if err != nil || balance.Balance != 5 || len(balance.Lots) != 1 || balance.Lots[0].ExpiresAt == nil {
+		t.Fatalf("Balance = %+v (%v), want 5 in one expiring lot", balance, err)
+	}
+	if until := time.Until(*balance.Lots[0].ExpiresAt); until < 23*time.Hour || until > 24*time.Hour {
+		t.Errorf("lot expires in %s, want 24h", until)
+	}
+}
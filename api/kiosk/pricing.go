@@ -0,0 +1,204 @@
+package kiosk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+
+	"isy-api/config"
+	"isy-api/response"
+	"isy-api/validate"
+)
+
+// Cart names the products a customer wants to buy. It carries no prices;
+// they are looked up when the cart is priced.
+type Cart struct {
+	CustomerID string     `json:"customerId"`
+	Items      []CartLine `json:"items"`
+}
+
+// CartLine is one product or variant of a cart
+type CartLine struct {
+	ProductID string `json:"productId"`
+	VariantID string `json:"variantId"`
+	Quantity  int    `json:"quantity"`
+}
+
+// Quote is a priced cart. Total is at list prices; Discount covers member
+// prices and configured discounts, and Rounding brings the rest to the
+// configured increment.
+type Quote struct {
+	CustomerID string      `json:"customerId,omitempty"`
+	Member     bool        `json:"member"`
+	Lines      []QuoteLine `json:"lines"`
+	Total      float64     `json:"total"`
+	Discount   float64     `json:"discount"`
+	Rounding   float64     `json:"rounding"`
+	FinalTotal float64     `json:"finalTotal"`
+}
+
+// QuoteLine is a priced cart line
+type QuoteLine struct {
+	ProductID   string  `json:"productId"`
+	ProductName string  `json:"productName"`
+	VariantID   string  `json:"variantId,omitempty"`
+	VariantName string  `json:"variantName,omitempty"`
+	CategoryID  string  `json:"categoryId"`
+	Quantity    int     `json:"quantity"`
+	ListPrice   float64 `json:"listPrice"`
+	// UnitPrice is the member price for members where it is lower
+	UnitPrice float64 `json:"unitPrice"`
+	Total     float64 `json:"total"`
+	Discount  float64 `json:"discount"`
+	// Discounts names what reduced the line: "member price" and the
+	// configured discount applied, if any
+	Discounts  []string `json:"discounts,omitempty"`
+	FinalTotal float64  `json:"finalTotal"`
+}
+
+// memberPriceDiscount names the member price in QuoteLine.Discounts
+const memberPriceDiscount = "member price"
+
+// Pricer prices carts from the current catalog
+type Pricer struct {
+	Products  ProductRepository
+	Customers CustomerRepository
+	Rules     config.Pricing
+}
+
+// Quote prices cart for its customer. Members pay the member price where
+// one is set and lower. Customers with allowed categories can only buy from
+// those. Problems with the cart are returned as one validation error with
+// an entry per line.
+func (p *Pricer) Quote(ctx context.Context, cart Cart) (Quote, error) {
+	var quote Quote
+	var problems []response.FieldError
+	fail := func(field string, code response.Code, message string) {
+		problems = append(problems, response.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	var allowed []string
+	if cart.CustomerID != "" {
+		customer, err := p.Customers.Get(ctx, cart.CustomerID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			fail("customerId", validate.CodeInvalidValue, "unknown customer")
+		case err != nil:
+			return Quote{}, err
+		case !customer.IsActive:
+			fail("customerId", validate.CodeInvalidValue, "customer is not active")
+		default:
+			quote.CustomerID = customer.ID.Hex()
+			// Walk-in customers have no member ID
+			quote.Member = customer.MemberID != ""
+			allowed = customer.AllowedCategories
+		}
+	}
+
+	for i, item := range cart.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		product, err := p.Products.Get(ctx, item.ProductID)
+		if errors.Is(err, ErrNotFound) {
+			fail(field+".productId", validate.CodeInvalidValue, "unknown product")
+			continue
+		}
+		if err != nil {
+			return Quote{}, err
+		}
+		if !product.IsActive {
+			fail(field+".productId", validate.CodeInvalidValue, "product is not available")
+			continue
+		}
+		if len(allowed) > 0 && !slices.Contains(allowed, product.CategoryID) {
+			fail(field+".productId", validate.CodeInvalidValue, "product is not available to this customer")
+			continue
+		}
+
+		line := QuoteLine{
+			ProductID:   product.ID.Hex(),
+			ProductName: product.Name,
+			CategoryID:  product.CategoryID,
+			Quantity:    item.Quantity,
+			ListPrice:   product.Price,
+		}
+		memberPrice := product.MemberPrice
+		switch {
+		case item.VariantID != "":
+			v := slices.IndexFunc(product.Variants, func(v Variant) bool { return v.ID == item.VariantID })
+			if v < 0 {
+				fail(field+".variantId", validate.CodeInvalidValue, "unknown variant of this product")
+				continue
+			}
+			variant := product.Variants[v]
+			line.VariantID, line.VariantName = variant.ID, variant.Name
+			line.ListPrice, memberPrice = variant.Price, variant.MemberPrice
+		case product.HasVariants:
+			fail(field+".variantId", validate.CodeRequired, "is required for a product with variants")
+			continue
+		}
+
+		p.priceLine(&line, memberPrice, quote.Member)
+		quote.Lines = append(quote.Lines, line)
+		quote.Total += line.Total
+		quote.Discount += line.Discount
+	}
+
+	if len(problems) > 0 {
+		return Quote{}, response.New(http.StatusBadRequest, response.CodeValidationFailed, "Invalid cart").WithFields(problems...)
+	}
+
+	quote.Total = roundCents(quote.Total)
+	quote.Discount = roundCents(quote.Discount)
+	due := quote.Total - quote.Discount
+	quote.FinalTotal = roundCents(math.Round(due/p.Rules.RoundTo) * p.Rules.RoundTo)
+	quote.Rounding = roundCents(quote.FinalTotal - due)
+	return quote, nil
+}
+
+// priceLine sets the unit price, totals and discounts of line from its list
+// price and the member price
+func (p *Pricer) priceLine(line *QuoteLine, memberPrice float64, member bool) {
+	line.UnitPrice = line.ListPrice
+	if member && memberPrice > 0 && memberPrice < line.ListPrice {
+		line.UnitPrice = memberPrice
+		line.Discounts = append(line.Discounts, memberPriceDiscount)
+	}
+
+	line.Total = roundCents(line.ListPrice * float64(line.Quantity))
+	net := roundCents(line.UnitPrice * float64(line.Quantity))
+	if discount, ok := p.bestDiscount(line, member); ok {
+		net = roundCents(net * (1 - discount.Percent/100))
+		line.Discounts = append(line.Discounts, discount.Name)
+	}
+	line.FinalTotal = net
+	line.Discount = roundCents(line.Total - net)
+}
+
+// bestDiscount returns the largest configured discount line qualifies for
+func (p *Pricer) bestDiscount(line *QuoteLine, member bool) (config.Discount, bool) {
+	var best config.Discount
+	found := false
+	for _, discount := range p.Rules.Discounts {
+		if discount.MembersOnly && !member {
+			continue
+		}
+		if line.Quantity < discount.MinQuantity {
+			continue
+		}
+		if len(discount.Categories) > 0 && !slices.Contains(discount.Categories, line.CategoryID) {
+			continue
+		}
+		if !found || discount.Percent > best.Percent {
+			best, found = discount, true
+		}
+	}
+	return best, found
+}
+
+// roundCents rounds an amount to whole cents, halves away from zero
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
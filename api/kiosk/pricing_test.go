@@ -0,0 +1,180 @@
+package kiosk
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/config"
+	"isy-api/response"
+)
+
+func TestPricerQuote(t *testing.T) {
+	coffee := Product{ID: primitive.NewObjectID(), Name: "Latte", CategoryID: "drinks", Price: 10, MemberPrice: 8, IsActive: true}
+	cake := Product{ID: primitive.NewObjectID(), Name: "Cake", CategoryID: "food", Price: 3.33, IsActive: true}
+	shirt := Product{ID: primitive.NewObjectID(), Name: "Shirt", CategoryID: "merch", Price: 20, IsActive: true, HasVariants: true, Variants: []Variant{
+		{ID: "s", Name: "Small", Price: 20, MemberPrice: 25},
+		{ID: "l", Name: "Large", Price: 24, MemberPrice: 18},
+	}}
+	retired := Product{ID: primitive.NewObjectID(), Name: "Mocha", CategoryID: "drinks", Price: 9}
+	member := Customer{ID: primitive.NewObjectID(), CustomerID: "M", MemberID: "M-1", IsActive: true}
+	walkIn := Customer{ID: primitive.NewObjectID(), CustomerID: "W", IsActive: true}
+	staff := Customer{ID: primitive.NewObjectID(), CustomerID: "S", MemberID: "M-2", IsActive: true, AllowedCategories: []string{"drinks"}}
+
+	bulk := config.Discount{Name: "bulk", Percent: 10, MinQuantity: 3}
+	drinks := config.Discount{Name: "drinks", Percent: 25, Categories: []string{"drinks"}}
+	members := config.Discount{Name: "members", Percent: 50, MembersOnly: true}
+
+	line := func(product Product, quantity int) CartLine {
+		return CartLine{ProductID: product.ID.Hex(), Quantity: quantity}
+	}
+	tests := []struct {
+		name      string
+		rules     config.Pricing
+		cart      Cart
+		final     float64
+		discount  float64
+		rounding  float64
+		discounts [][]string
+		invalid   []string
+	}{
+		{
+			name:      "list price for walk-ins",
+			cart:      Cart{CustomerID: "W", Items: []CartLine{line(coffee, 2)}},
+			final:     20,
+			discounts: [][]string{nil},
+		},
+		{
+			name:      "member price for members",
+			cart:      Cart{CustomerID: "M", Items: []CartLine{line(coffee, 2)}},
+			final:     16,
+			discount:  4,
+			discounts: [][]string{{memberPriceDiscount}},
+		},
+		{
+			name:      "member price only when lower",
+			cart:      Cart{CustomerID: "M", Items: []CartLine{{ProductID: shirt.ID.Hex(), VariantID: "s", Quantity: 1}, {ProductID: shirt.ID.Hex(), VariantID: "l", Quantity: 1}}},
+			final:     38,
+			discount:  6,
+			discounts: [][]string{nil, {memberPriceDiscount}},
+		},
+		{
+			name:      "discount after member price",
+			rules:     config.Pricing{Discounts: []config.Discount{drinks}},
+			cart:      Cart{CustomerID: "M", Items: []CartLine{line(coffee, 1)}},
+			final:     6,
+			discount:  4,
+			discounts: [][]string{{memberPriceDiscount, "drinks"}},
+		},
+		{
+			name:      "largest discount only",
+			rules:     config.Pricing{Discounts: []config.Discount{bulk, drinks}},
+			cart:      Cart{Items: []CartLine{line(coffee, 4), line(cake, 3)}},
+			final:     38.99,
+			discount:  11,
+			discounts: [][]string{{"drinks"}, {"bulk"}},
+		},
+		{
+			name:      "members-only discount skips walk-ins",
+			rules:     config.Pricing{Discounts: []config.Discount{members, bulk}},
+			cart:      Cart{CustomerID: "W", Items: []CartLine{line(coffee, 1)}},
+			final:     10,
+			discounts: [][]string{nil},
+		},
+		{
+			name:      "members-only discount for members",
+			rules:     config.Pricing{Discounts: []config.Discount{members, bulk}},
+			cart:      Cart{CustomerID: "M", Items: []CartLine{line(coffee, 3)}},
+			final:     12,
+			discount:  18,
+			discounts: [][]string{{memberPriceDiscount, "members"}},
+		},
+		{
+			name:      "allowed categories",
+			cart:      Cart{CustomerID: "S", Items: []CartLine{line(coffee, 1)}},
+			final:     8,
+			discount:  2,
+			discounts: [][]string{{memberPriceDiscount}},
+		},
+		{
+			name:    "outside allowed categories",
+			cart:    Cart{CustomerID: "S", Items: []CartLine{line(coffee, 1), line(cake, 1)}},
+			invalid: []string{"items[1].productId"},
+		},
+		{
+			name:      "rounds down to the increment",
+			rules:     config.Pricing{RoundTo: 0.25},
+			cart:      Cart{Items: []CartLine{line(cake, 1)}},
+			final:     3.25,
+			rounding:  -0.08,
+			discounts: [][]string{nil},
+		},
+		{
+			name:      "rounds up to the increment",
+			rules:     config.Pricing{RoundTo: 0.5},
+			cart:      Cart{Items: []CartLine{line(cake, 3)}},
+			final:     10,
+			rounding:  0.01,
+			discounts: [][]string{nil},
+		},
+		{
+			name:      "rounds to whole units",
+			rules:     config.Pricing{RoundTo: 1},
+			cart:      Cart{Items: []CartLine{line(cake, 2)}},
+			final:     7,
+			rounding:  0.34,
+			discounts: [][]string{nil},
+		},
+		{
+			name:    "every problem reported",
+			cart:    Cart{CustomerID: "nobody", Items: []CartLine{line(retired, 1), {ProductID: shirt.ID.Hex(), Quantity: 1}, {ProductID: shirt.ID.Hex(), VariantID: "xl", Quantity: 1}}},
+			invalid: []string{"customerId", "items[0].productId", "items[1].variantId", "items[2].variantId"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rules.RoundTo == 0 {
+				tt.rules.RoundTo = 0.01
+			}
+			p := &Pricer{
+				Products:  NewMemoryProductRepository(coffee, cake, shirt, retired),
+				Customers: NewMemoryCustomerRepository(member, walkIn, staff),
+				Rules:     tt.rules,
+			}
+			quote, err := p.Quote(context.Background(), tt.cart)
+
+			if tt.invalid != nil {
+				var problem *response.Error
+				if !errors.As(err, &problem) {
+					t.Fatalf("Quote error = %v, want a validation error", err)
+				}
+				var fields []string
+				for _, field := range problem.Fields {
+					fields = append(fields, field.Field)
+				}
+				if !slices.Equal(fields, tt.invalid) {
+					t.Errorf("invalid fields = %v, want %v", fields, tt.invalid)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quote.FinalTotal != tt.final || quote.Discount != tt.discount || quote.Rounding != tt.rounding {
+				t.Errorf("final %g, discount %g, rounding %g; want %g, %g, %g",
+					quote.FinalTotal, quote.Discount, quote.Rounding, tt.final, tt.discount, tt.rounding)
+			}
+			if due := roundCents(quote.Total - quote.Discount + quote.Rounding); due != quote.FinalTotal {
+				t.Errorf("total %g - discount %g + rounding %g != final %g", quote.Total, quote.Discount, quote.Rounding, quote.FinalTotal)
+			}
+			for i, line := range quote.Lines {
+				if !slices.Equal(line.Discounts, tt.discounts[i]) {
+					t.Errorf("line %d discounts = %v, want %v", i, line.Discounts, tt.discounts[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,567 @@
+package kiosk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"isy-api/listing"
+	"isy-api/search"
+	"isy-api/tenant"
+)
+
+var (
+	// ErrNotFound is returned when a document does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrUnavailable is returned when the database is not connected
+	ErrUnavailable = errors.New("database not available")
+	// ErrConflict is returned when a document changed between reading and
+	// writing it
+	ErrConflict = errors.New("conflict")
+)
+
+// ProductRepository stores kiosk products
+type ProductRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[Product], error)
+	Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error)
+	// Get finds a product by ObjectId or by its productId
+	Get(ctx context.Context, id string) (Product, error)
+	Create(ctx context.Context, product *Product) error
+}
+
+// CustomerRepository stores kiosk customers
+type CustomerRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[Customer], error)
+	Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error)
+	// Get finds a customer by ObjectId or by its customerId
+	Get(ctx context.Context, id string) (Customer, error)
+}
+
+// OrderRepository stores kiosk orders
+type OrderRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[Order], error)
+	Get(ctx context.Context, id primitive.ObjectID) (Order, error)
+	// Create numbers the order with the next transaction ID and inserts it
+	Create(ctx context.Context, order *Order) error
+	// SetStatus moves an order from status from to change.Status, marking
+	// it SettlementPending in the same write when the status is final. It
+	// returns ErrConflict if the order is no longer in status from.
+	SetStatus(ctx context.Context, id primitive.ObjectID, from string, change StatusChange) error
+	// Settled clears the SettlementPending mark of an order
+	Settled(ctx context.Context, id primitive.ObjectID) error
+}
+
+// PointsRepository stores the loyalty ledger and its balance snapshots
+type PointsRepository interface {
+	// Balance returns the customer's latest snapshot brought up to date with
+	// the entries appended after it
+	Balance(ctx context.Context, customerID string) (PointsBalance, error)
+	// History lists the customer's ledger entries
+	History(ctx context.Context, customerID string, query listing.Query) (listing.Result[PointsEntry], error)
+	// Append adds entry as the next entry after balance and returns it with
+	// the new balance. Entries without an ID get one. It returns ErrConflict
+	// if another entry was appended after balance or has the same ID.
+	Append(ctx context.Context, balance PointsBalance, entry PointsEntry) (PointsEntry, PointsBalance, error)
+}
+
+// PointsAwardRepository stores points awards awaiting an admin's decision
+type PointsAwardRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[PointsAward], error)
+	Get(ctx context.Context, id primitive.ObjectID) (PointsAward, error)
+	// Create inserts award. It returns ErrConflict if its order already has
+	// an award.
+	Create(ctx context.Context, award *PointsAward) error
+	// Decide moves an award from status from to decision.Status. It returns
+	// ErrConflict if the award is no longer in status from.
+	Decide(ctx context.Context, id primitive.ObjectID, from string, decision AwardDecision) error
+	// Credited clears the CreditPending mark of an approved award
+	Credited(ctx context.Context, id primitive.ObjectID) error
+}
+
+// StockRepository stores the stock movement ledger
+type StockRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[StockMovement], error)
+	// Append inserts movements. A sale already recorded for the same order,
+	// product and variant is skipped, so an order is only deducted once.
+	Append(ctx context.Context, movements []StockMovement) error
+	// Levels totals the movements per product and variant, ordered by name.
+	// A productID narrows them to that product.
+	Levels(ctx context.Context, productID string) ([]StockLevel, error)
+}
+
+// mongoCollection returns the named collection of the tenant database bound
+// to ctx, or of db for requests without a tenant
+func mongoCollection(ctx context.Context, db *mongo.Database, name string) (*mongo.Collection, error) {
+	db = tenant.Database(ctx, db)
+	if db == nil {
+		return nil, ErrUnavailable
+	}
+	return db.Collection(name), nil
+}
+
+// idFilter matches documents by ObjectId or by field, the business ID
+// clients may know them by
+func idFilter(field, id string) bson.M {
+	if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+		return bson.M{"$or": bson.A{bson.M{"_id": objID}, bson.M{field: id}}}
+	}
+	return bson.M{field: id}
+}
+
+// findOne decodes the document matching filter into T
+func findOne[T any](ctx context.Context, collection *mongo.Collection, filter bson.M) (T, error) {
+	var document T
+	err := collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{search.GramsField: 0})).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = ErrNotFound
+	}
+	return document, err
+}
+
+// MongoProductRepository stores products in the products collection
+type MongoProductRepository struct {
+	DB *mongo.Database
+}
+
+// NewMongoProductRepository creates a product repository backed by db
+func NewMongoProductRepository(db *mongo.Database) *MongoProductRepository {
+	return &MongoProductRepository{DB: db}
+}
+
+func (repo *MongoProductRepository) List(ctx context.Context, query listing.Query) (listing.Result[Product], error) {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return listing.Result[Product]{}, err
+	}
+	return listing.Find[Product](ctx, collection, query)
+}
+
+func (repo *MongoProductRepository) Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return nil, err
+	}
+	return search.Find[Product](ctx, collection, search.Products, query, limit)
+}
+
+func (repo *MongoProductRepository) Get(ctx context.Context, id string) (Product, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return Product{}, err
+	}
+	return findOne[Product](ctx, collection, idFilter("productId", id))
+}
+
+func (repo *MongoProductRepository) Create(ctx context.Context, product *Product) error {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return err
+	}
+
+	if product.SearchGrams, err = search.Products.Grams(product); err != nil {
+		return err
+	}
+
+	result, err := collection.InsertOne(ctx, product)
+	if err != nil {
+		return err
+	}
+	product.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// MongoCustomerRepository stores customers in the customers collection
+type MongoCustomerRepository struct {
+	DB *mongo.Database
+}
+
+// NewMongoCustomerRepository creates a customer repository backed by db
+func NewMongoCustomerRepository(db *mongo.Database) *MongoCustomerRepository {
+	return &MongoCustomerRepository{DB: db}
+}
+
+func (repo *MongoCustomerRepository) List(ctx context.Context, query listing.Query) (listing.Result[Customer], error) {
+	collection, err := mongoCollection(ctx, repo.DB, "customers")
+	if err != nil {
+		return listing.Result[Customer]{}, err
+	}
+	return listing.Find[Customer](ctx, collection, query)
+}
+
+func (repo *MongoCustomerRepository) Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "customers")
+	if err != nil {
+		return nil, err
+	}
+	return search.Find[Customer](ctx, collection, search.Customers, query, limit)
+}
+
+func (repo *MongoCustomerRepository) Get(ctx context.Context, id string) (Customer, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "customers")
+	if err != nil {
+		return Customer{}, err
+	}
+	return findOne[Customer](ctx, collection, idFilter("customerId", id))
+}
+
+// MongoOrderRepository stores orders in the orders collection
+type MongoOrderRepository struct {
+	DB *mongo.Database
+	// Prefix starts every transaction ID, e.g. TRX-00042
+	Prefix string
+}
+
+// NewMongoOrderRepository creates an order repository backed by db
+func NewMongoOrderRepository(db *mongo.Database) *MongoOrderRepository {
+	return &MongoOrderRepository{DB: db, Prefix: "TRX"}
+}
+
+func (repo *MongoOrderRepository) List(ctx context.Context, query listing.Query) (listing.Result[Order], error) {
+	collection, err := mongoCollection(ctx, repo.DB, "orders")
+	if err != nil {
+		return listing.Result[Order]{}, err
+	}
+	return listing.Find[Order](ctx, collection, query)
+}
+
+func (repo *MongoOrderRepository) Get(ctx context.Context, id primitive.ObjectID) (Order, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "orders")
+	if err != nil {
+		return Order{}, err
+	}
+	return findOne[Order](ctx, collection, bson.M{"_id": id})
+}
+
+func (repo *MongoOrderRepository) Create(ctx context.Context, order *Order) error {
+	collection, err := mongoCollection(ctx, repo.DB, "orders")
+	if err != nil {
+		return err
+	}
+
+	// The counter is shared with the transactions the kiosk front-end
+	// numbers itself, so both sequences stay unique
+	var counter struct {
+		Count int64 `bson:"count"`
+	}
+	err = collection.Database().Collection("counters").FindOneAndUpdate(ctx,
+		bson.M{"_id": "transaction_" + repo.Prefix},
+		bson.M{"$inc": bson.M{"count": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return err
+	}
+	order.TransactionID = fmt.Sprintf("%s-%05d", repo.Prefix, counter.Count)
+
+	result, err := collection.InsertOne(ctx, order)
+	if err != nil {
+		return err
+	}
+	order.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (repo *MongoOrderRepository) SetStatus(ctx context.Context, id primitive.ObjectID, from string, change StatusChange) error {
+	collection, err := mongoCollection(ctx, repo.DB, "orders")
+	if err != nil {
+		return err
+	}
+
+	set := bson.M{"status": change.Status, "updatedAt": change.At}
+	if settles(change.Status) {
+		set["settlementPending"] = true
+	}
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": from},
+		bson.M{
+			"$set":  set,
+			"$push": bson.M{"statusHistory": change},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := repo.Get(ctx, id); err != nil {
+			return err
+		}
+		return ErrConflict
+	}
+	return nil
+}
+
+func (repo *MongoOrderRepository) Settled(ctx context.Context, id primitive.ObjectID) error {
+	collection, err := mongoCollection(ctx, repo.DB, "orders")
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"settlementPending": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MongoPointsRepository keeps the ledger in the points_ledger collection and
+// one snapshot per customer in points_balances
+type MongoPointsRepository struct {
+	DB *mongo.Database
+}
+
+// NewMongoPointsRepository creates a points repository backed by db
+func NewMongoPointsRepository(db *mongo.Database) *MongoPointsRepository {
+	return &MongoPointsRepository{DB: db}
+}
+
+func (repo *MongoPointsRepository) Balance(ctx context.Context, customerID string) (PointsBalance, error) {
+	ledger, err := mongoCollection(ctx, repo.DB, "points_ledger")
+	if err != nil {
+		return PointsBalance{}, err
+	}
+
+	balance := PointsBalance{CustomerID: customerID}
+	err = ledger.Database().Collection("points_balances").FindOne(ctx, bson.M{"_id": customerID}).Decode(&balance)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return PointsBalance{}, err
+	}
+
+	cursor, err := ledger.Find(ctx,
+		bson.M{"customerId": customerID, "seq": bson.M{"$gt": balance.Seq}},
+		options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}),
+	)
+	if err != nil {
+		return PointsBalance{}, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var entry PointsEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return PointsBalance{}, err
+		}
+		balance = balance.apply(entry)
+	}
+	return balance, cursor.Err()
+}
+
+func (repo *MongoPointsRepository) History(ctx context.Context, customerID string, query listing.Query) (listing.Result[PointsEntry], error) {
+	ledger, err := mongoCollection(ctx, repo.DB, "points_ledger")
+	if err != nil {
+		return listing.Result[PointsEntry]{}, err
+	}
+	query.Conditions = append(query.Conditions, listing.Condition{Path: "customerId", Op: listing.Eq, Value: customerID})
+	return listing.Find[PointsEntry](ctx, ledger, query)
+}
+
+func (repo *MongoPointsRepository) Append(ctx context.Context, balance PointsBalance, entry PointsEntry) (PointsEntry, PointsBalance, error) {
+	ledger, err := mongoCollection(ctx, repo.DB, "points_ledger")
+	if err != nil {
+		return PointsEntry{}, PointsBalance{}, err
+	}
+
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	entry.Seq = balance.Seq + 1
+	if _, err := ledger.InsertOne(ctx, entry); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return PointsEntry{}, PointsBalance{}, ErrConflict
+		}
+		return PointsEntry{}, PointsBalance{}, err
+	}
+
+	// Snapshots only move forward. One that fails to save is caught up from
+	// the ledger on the next read, so the entry stands either way.
+	next := balance.apply(entry)
+	_, err = ledger.Database().Collection("points_balances").ReplaceOne(ctx,
+		bson.M{"_id": entry.CustomerID, "seq": bson.M{"$lt": next.Seq}},
+		next,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Failed to save points snapshot of %s: %v", entry.CustomerID, err)
+	}
+	return entry, next, nil
+}
+
+// MongoPointsAwardRepository stores awards in the pending_points collection
+type MongoPointsAwardRepository struct {
+	DB *mongo.Database
+}
+
+// NewMongoPointsAwardRepository creates an award repository backed by db
+func NewMongoPointsAwardRepository(db *mongo.Database) *MongoPointsAwardRepository {
+	return &MongoPointsAwardRepository{DB: db}
+}
+
+func (repo *MongoPointsAwardRepository) List(ctx context.Context, query listing.Query) (listing.Result[PointsAward], error) {
+	collection, err := mongoCollection(ctx, repo.DB, "pending_points")
+	if err != nil {
+		return listing.Result[PointsAward]{}, err
+	}
+	return listing.Find[PointsAward](ctx, collection, query)
+}
+
+func (repo *MongoPointsAwardRepository) Get(ctx context.Context, id primitive.ObjectID) (PointsAward, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "pending_points")
+	if err != nil {
+		return PointsAward{}, err
+	}
+	return findOne[PointsAward](ctx, collection, bson.M{"_id": id})
+}
+
+func (repo *MongoPointsAwardRepository) Create(ctx context.Context, award *PointsAward) error {
+	collection, err := mongoCollection(ctx, repo.DB, "pending_points")
+	if err != nil {
+		return err
+	}
+
+	if award.ID.IsZero() {
+		award.ID = primitive.NewObjectID()
+	}
+	if _, err := collection.InsertOne(ctx, award); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+func (repo *MongoPointsAwardRepository) Decide(ctx context.Context, id primitive.ObjectID, from string, decision AwardDecision) error {
+	collection, err := mongoCollection(ctx, repo.DB, "pending_points")
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id, "status": from}, bson.M{"$set": decision})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := repo.Get(ctx, id); err != nil {
+			return err
+		}
+		return ErrConflict
+	}
+	return nil
+}
+
+func (repo *MongoPointsAwardRepository) Credited(ctx context.Context, id primitive.ObjectID) error {
+	collection, err := mongoCollection(ctx, repo.DB, "pending_points")
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"creditPending": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MongoStockRepository keeps the stock ledger in the stock_movements
+// collection
+type MongoStockRepository struct {
+	DB *mongo.Database
+}
+
+// NewMongoStockRepository creates a stock repository backed by db
+func NewMongoStockRepository(db *mongo.Database) *MongoStockRepository {
+	return &MongoStockRepository{DB: db}
+}
+
+func (repo *MongoStockRepository) List(ctx context.Context, query listing.Query) (listing.Result[StockMovement], error) {
+	collection, err := mongoCollection(ctx, repo.DB, "stock_movements")
+	if err != nil {
+		return listing.Result[StockMovement]{}, err
+	}
+	return listing.Find[StockMovement](ctx, collection, query)
+}
+
+func (repo *MongoStockRepository) Append(ctx context.Context, movements []StockMovement) error {
+	collection, err := mongoCollection(ctx, repo.DB, "stock_movements")
+	if err != nil {
+		return err
+	}
+
+	documents := make([]interface{}, len(movements))
+	for i, movement := range movements {
+		documents[i] = movement
+	}
+	_, err = collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+
+	// Duplicates are sales recorded before; the other movements went in
+	var bulk mongo.BulkWriteException
+	if errors.As(err, &bulk) && bulk.WriteConcernError == nil {
+		for _, writeErr := range bulk.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr.WriteError) {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+func (repo *MongoStockRepository) Levels(ctx context.Context, productID string) ([]StockLevel, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "stock_movements")
+	if err != nil {
+		return nil, err
+	}
+
+	match := bson.M{}
+	if productID != "" {
+		match["productId"] = productID
+	}
+	// counted sums the quantities of the movement types, negated for removals
+	counted := func(types bson.A, sign int) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{
+			bson.M{"$in": bson.A{"$type", types}},
+			bson.M{"$multiply": bson.A{"$quantity", sign}},
+			0,
+		}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		// Oldest first within each product and variant, so the names are
+		// those of the latest movement
+		{{Key: "$sort", Value: bson.D{{Key: "productId", Value: 1}, {Key: "variantId", Value: 1}, {Key: "createdAt", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            bson.M{"productId": "$productId", "variantId": "$variantId"},
+			"productName":    bson.M{"$last": "$productName"},
+			"variantName":    bson.M{"$last": "$variantName"},
+			"onHand":         bson.M{"$sum": "$quantity"},
+			"received":       counted(bson.A{StockIn}, 1),
+			"issued":         counted(bson.A{StockOut}, -1),
+			"sold":           counted(bson.A{StockSale}, -1),
+			"returned":       counted(bson.A{StockReturn}, 1),
+			"adjusted":       counted(bson.A{StockAdjustment, StockOpening}, 1),
+			"lastMovementAt": bson.M{"$max": "$createdAt"},
+		}}},
+		{{Key: "$set", Value: bson.M{"productId": "$_id.productId", "variantId": "$_id.variantId"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "productName", Value: 1}, {Key: "variantName", Value: 1}}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	levels := []StockLevel{}
+	if err := cursor.All(ctx, &levels); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
@@ -0,0 +1,111 @@
+package kiosk
+
+import (
+	"slices"
+
+	"isy-api/validate"
+)
+
+// Shared field shapes
+var (
+	identifier = validate.Field{Type: validate.String, MaxLength: 64}
+	label      = validate.Field{Type: validate.String, MaxLength: 200}
+	longText   = validate.Field{Type: validate.String, MaxLength: 5000}
+	imageURL   = validate.Field{Type: validate.String, MaxLength: 2048, Format: validate.URL}
+	price      = validate.Field{Type: validate.Number, Min: validate.Limit(0)}
+)
+
+// productSchema is the body of CreateProduct. The ID and timestamps are set
+// by the server.
+var productSchema = validate.Schema{Fields: []validate.Field{
+	identifier.Named("productId"),
+	label.Named("name").Require(),
+	longText.Named("description"),
+	identifier.Named("categoryId").Require(),
+	identifier.Named("subcategoryId"),
+	{Name: "hasVariants", Type: validate.Bool},
+	{Name: "variants", Type: validate.Array, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+		identifier.Named("id"),
+		label.Named("name").Require(),
+		price.Named("price").Require(),
+		price.Named("memberPrice"),
+		identifier.Named("sku"),
+		{Name: "stock", Type: validate.Integer, Min: validate.Limit(0)},
+	}}},
+	price.Named("price").Require(),
+	price.Named("memberPrice"),
+	imageURL.Named("mainImage"),
+	{Name: "images", Type: validate.Array, MaxLength: 50, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+		imageURL.Named("url").Require(),
+		{Name: "path", Type: validate.String, MaxLength: 2048},
+		label.Named("name"),
+	}}},
+	identifier.Named("sku"),
+	imageURL.Named("backgroundImage"),
+	{Name: "backgroundFit", Type: validate.String, Enum: []string{"cover", "contain", "fill"}},
+	{Name: "textColor", Type: validate.String, MaxLength: 32},
+	imageURL.Named("modelUrl"),
+	{Name: "isActive", Type: validate.Bool},
+	{Name: "isFeatured", Type: validate.Bool},
+	longText.Named("notes"),
+}}
+
+// cartFields name what is bought; prices sent by a client are refused as
+// unknown fields
+var cartFields = []validate.Field{
+	identifier.Named("customerId"),
+	{Name: "items", Type: validate.Array, Required: true, MinLength: 1, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+		identifier.Named("productId").Require(),
+		identifier.Named("variantId"),
+		{Name: "quantity", Type: validate.Integer, Required: true, Min: validate.Limit(1), Max: validate.Limit(999)},
+	}}},
+}
+
+// cartSchema is the body of Quote
+var cartSchema = validate.Schema{Fields: cartFields}
+
+// orderSchema is the body of CreateOrder
+var orderSchema = validate.Schema{Fields: append(slices.Clip(cartFields),
+	validate.Field{Name: "paymentMethod", Type: validate.String, MaxLength: 40},
+	longText.Named("notes"),
+	// Points to redeem against the amount due
+	validate.Field{Name: "points", Type: validate.Integer, Min: validate.Limit(0)},
+)}
+
+// orderStatusSchema is the body of UpdateOrderStatus
+var orderStatusSchema = validate.Schema{Fields: []validate.Field{
+	{Name: "status", Type: validate.String, Required: true, Enum: []string{StatusPending, StatusPreparing, StatusReady, StatusCompleted, StatusCancelled}},
+	{Name: "note", Type: validate.String, MaxLength: 500},
+}}
+
+// awardDecisionSchema is the body of DecidePointsAward
+var awardDecisionSchema = validate.Schema{Fields: []validate.Field{
+	{Name: "status", Type: validate.String, Required: true, Enum: []string{AwardApproved, AwardDiscarded}},
+	{Name: "note", Type: validate.String, MaxLength: 500},
+}}
+
+// awardBatchSchema is the body of DecidePointsAwards
+var awardBatchSchema = validate.Schema{Fields: append([]validate.Field{
+	{Name: "ids", Type: validate.Array, Required: true, MinLength: 1, MaxLength: maxAwardBatch, Items: &validate.Field{Type: validate.ObjectID}},
+}, awardDecisionSchema.Fields...)}
+
+// stockSchema is the body of CreateStockMovements. Sales come from orders
+// and the opening count from the ledger's migration, so neither is accepted.
+var stockSchema = validate.Schema{Fields: []validate.Field{
+	{Name: "type", Type: validate.String, Required: true, Enum: []string{StockIn, StockOut, StockAdjustment, StockReturn}},
+	identifier.Named("purchaseOrderId"),
+	label.Named("supplier"),
+	longText.Named("notes"),
+	{Name: "items", Type: validate.Array, Required: true, MinLength: 1, MaxLength: 100, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+		identifier.Named("productId").Require(),
+		identifier.Named("variantId"),
+		{Name: "quantity", Type: validate.Integer, Required: true, Min: validate.Limit(-100000), Max: validate.Limit(100000)},
+		price.Named("unitCost"),
+	}}},
+}}
+
+// pointsAdjustmentSchema is the body of AdjustPoints; negative points debit
+var pointsAdjustmentSchema = validate.Schema{Fields: []validate.Field{
+	{Name: "points", Type: validate.Number, Required: true, Min: validate.Limit(-1000000), Max: validate.Limit(1000000)},
+	{Name: "reason", Type: validate.String, Required: true, MinLength: 1, MaxLength: 500},
+}}
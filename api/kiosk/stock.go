@@ -0,0 +1,295 @@
+package kiosk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/listing"
+	"isy-api/response"
+	"isy-api/validate"
+)
+
+// Stock movement types. Receipts, returns and positive adjustments add
+// stock; issues, sales and negative adjustments remove it. Sales are only
+// recorded when an order completes, and the opening movement carries the
+// stock counted before the ledger.
+const (
+	StockIn         = "in"
+	StockOut        = "out"
+	StockAdjustment = "adjustment"
+	StockSale       = "sale"
+	StockReturn     = "return"
+	StockOpening    = "opening"
+)
+
+// StockMovement is an immutable change to the stock of a product or one of
+// its variants
+type StockMovement struct {
+	ID primitive.ObjectID `bson:"_id" json:"id"`
+	// ProductID is the product's ObjectId, as on order items
+	ProductID   string `bson:"productId" json:"productId"`
+	ProductName string `bson:"productName" json:"productName"`
+	// VariantID is empty for products without variants
+	VariantID   string `bson:"variantId" json:"variantId"`
+	VariantName string `bson:"variantName,omitempty" json:"variantName,omitempty"`
+	Type        string `bson:"type" json:"type"`
+	// Quantity is positive for stock added and negative for stock removed
+	Quantity int     `bson:"quantity" json:"quantity"`
+	UnitCost float64 `bson:"unitCost,omitempty" json:"unitCost,omitempty"`
+	Supplier string  `bson:"supplier,omitempty" json:"supplier,omitempty"`
+	// PurchaseOrderID groups the receipts of one purchase
+	PurchaseOrderID string `bson:"purchaseOrderId,omitempty" json:"purchaseOrderId,omitempty"`
+	// OrderID is the order a sale was made on
+	OrderID   string    `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	Notes     string    `bson:"notes,omitempty" json:"notes,omitempty"`
+	CreatedBy string    `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// StockLevel totals the movements of a product or variant. OnHand may go
+// negative when more was sold than was recorded as received, which points
+// to a missing receipt or a count to adjust.
+type StockLevel struct {
+	ProductID   string `bson:"productId" json:"productId"`
+	ProductName string `bson:"productName" json:"productName"`
+	VariantID   string `bson:"variantId" json:"variantId"`
+	VariantName string `bson:"variantName" json:"variantName"`
+	OnHand      int    `bson:"onHand" json:"onHand"`
+	Received    int    `bson:"received" json:"received"`
+	Issued      int    `bson:"issued" json:"issued"`
+	Sold        int    `bson:"sold" json:"sold"`
+	Returned    int    `bson:"returned" json:"returned"`
+	// Adjusted is the net of adjustments and the opening count
+	Adjusted       int       `bson:"adjusted" json:"adjusted"`
+	LastMovementAt time.Time `bson:"lastMovementAt" json:"lastMovementAt"`
+}
+
+// add counts movement into the level
+func (l *StockLevel) add(movement StockMovement) {
+	l.OnHand += movement.Quantity
+	switch movement.Type {
+	case StockIn:
+		l.Received += movement.Quantity
+	case StockOut:
+		l.Issued -= movement.Quantity
+	case StockSale:
+		l.Sold -= movement.Quantity
+	case StockReturn:
+		l.Returned += movement.Quantity
+	default:
+		l.Adjusted += movement.Quantity
+	}
+	if !movement.CreatedAt.Before(l.LastMovementAt) {
+		l.ProductName, l.VariantName = movement.ProductName, movement.VariantName
+		l.LastMovementAt = movement.CreatedAt
+	}
+}
+
+var stockListing = listing.Spec{
+	Fields: []listing.Field{
+		{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+		{Name: "productId", Type: listing.String},
+		{Name: "variantId", Type: listing.String},
+		{Name: "type", Type: listing.String},
+		{Name: "purchaseOrderId", Type: listing.String},
+		{Name: "orderId", Type: listing.String},
+		{Name: "supplier", Type: listing.String},
+		{Name: "createdAt", Type: listing.Time, Sortable: true},
+	},
+	DefaultSort: "-createdAt",
+}
+
+// StockRequest is the body of CreateStockMovements: one movement per item,
+// sharing the type and references
+type StockRequest struct {
+	Type            string             `json:"type"`
+	PurchaseOrderID string             `json:"purchaseOrderId"`
+	Supplier        string             `json:"supplier"`
+	Notes           string             `json:"notes"`
+	Items           []StockRequestItem `json:"items"`
+}
+
+// StockRequestItem is one product or variant of a StockRequest. Quantity is
+// what was received, issued or returned; only adjustments are signed.
+type StockRequestItem struct {
+	ProductID string  `json:"productId"`
+	VariantID string  `json:"variantId"`
+	Quantity  int     `json:"quantity"`
+	UnitCost  float64 `json:"unitCost"`
+}
+
+// GetStock returns the on-hand stock of every product and variant with
+// movements, optionally narrowed to one product by ?productId=
+func (kh *KioskHandlers) GetStock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var productID string
+	if id := r.URL.Query().Get("productId"); id != "" {
+		product, err := kh.Products.Get(ctx, id)
+		if err != nil {
+			respondWithRepositoryError(w, r, err, "Failed to fetch product")
+			return
+		}
+		productID = product.ID.Hex()
+	}
+
+	levels, err := kh.Stock.Levels(ctx, productID)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch stock")
+		return
+	}
+
+	response.JSON(w, r, http.StatusOK, levels)
+}
+
+// GetStockMovements lists stock movements a page at a time, newest first
+// unless sorted otherwise
+func (kh *KioskHandlers) GetStockMovements(w http.ResponseWriter, r *http.Request) {
+	query, err := listing.Parse(r, stockListing)
+	if err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	result, err := kh.Stock.List(r.Context(), query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch stock movements")
+		return
+	}
+
+	response.List(w, r, result.Items, result.Page())
+}
+
+// CreateStockMovements records received, issued, returned or adjusted stock.
+// Sales are recorded by completing orders.
+func (kh *KioskHandlers) CreateStockMovements(w http.ResponseWriter, r *http.Request) {
+	var req StockRequest
+	if err := validate.DecodeInto(r, stockSchema, &req); err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	movements, err := kh.stockMovements(ctx, req)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to fetch products")
+		return
+	}
+	if err := kh.Stock.Append(ctx, movements); err != nil {
+		respondWithRepositoryError(w, r, err, "Failed to record stock movements")
+		return
+	}
+
+	response.JSON(w, r, http.StatusCreated, movements)
+}
+
+// stockMovements turns the items of req into movements, resolving product
+// and variant names. Problems with the items are returned as one validation
+// error with an entry per item.
+func (kh *KioskHandlers) stockMovements(ctx context.Context, req StockRequest) ([]StockMovement, error) {
+	var problems []response.FieldError
+	fail := func(field string, code response.Code, message string) {
+		problems = append(problems, response.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	now := time.Now()
+	movements := make([]StockMovement, 0, len(req.Items))
+	for i, item := range req.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		quantity := item.Quantity
+		switch {
+		case req.Type == StockAdjustment && quantity == 0:
+			fail(field+".quantity", validate.CodeInvalidValue, "must not be zero")
+			continue
+		case req.Type != StockAdjustment && quantity <= 0:
+			fail(field+".quantity", validate.CodeInvalidValue, "must be positive; only adjustments are signed")
+			continue
+		case req.Type == StockOut:
+			quantity = -quantity
+		}
+
+		product, err := kh.Products.Get(ctx, item.ProductID)
+		if errors.Is(err, ErrNotFound) {
+			fail(field+".productId", validate.CodeInvalidValue, "unknown product")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		movement := StockMovement{
+			ID:              primitive.NewObjectID(),
+			ProductID:       product.ID.Hex(),
+			ProductName:     product.Name,
+			Type:            req.Type,
+			Quantity:        quantity,
+			UnitCost:        item.UnitCost,
+			Supplier:        req.Supplier,
+			PurchaseOrderID: req.PurchaseOrderID,
+			Notes:           req.Notes,
+			CreatedBy:       actor(ctx),
+			CreatedAt:       now,
+		}
+		switch {
+		case item.VariantID != "":
+			v := slices.IndexFunc(product.Variants, func(v Variant) bool { return v.ID == item.VariantID })
+			if v < 0 {
+				fail(field+".variantId", validate.CodeInvalidValue, "unknown variant of this product")
+				continue
+			}
+			movement.VariantID, movement.VariantName = product.Variants[v].ID, product.Variants[v].Name
+		case product.HasVariants:
+			fail(field+".variantId", validate.CodeRequired, "is required for a product with variants")
+			continue
+		}
+		movements = append(movements, movement)
+	}
+
+	if len(problems) > 0 {
+		return nil, response.New(http.StatusBadRequest, response.CodeValidationFailed, "Invalid stock movements").WithFields(problems...)
+	}
+	return movements, nil
+}
+
+// settleStock records the sale of a completed order's items. The ledger
+// keeps one sale per order, product and variant, so recording it again
+// changes nothing.
+func (kh *KioskHandlers) settleStock(ctx context.Context, order Order) error {
+	if order.Status != StatusCompleted {
+		return nil
+	}
+
+	// One sale per product and variant
+	var movements []StockMovement
+	for _, item := range order.Items {
+		i := slices.IndexFunc(movements, func(m StockMovement) bool {
+			return m.ProductID == item.ProductID && m.VariantID == item.VariantID
+		})
+		if i >= 0 {
+			movements[i].Quantity -= item.Quantity
+			continue
+		}
+		movements = append(movements, StockMovement{
+			ID:          primitive.NewObjectID(),
+			ProductID:   item.ProductID,
+			ProductName: item.ProductName,
+			VariantID:   item.VariantID,
+			VariantName: item.VariantName,
+			Type:        StockSale,
+			Quantity:    -item.Quantity,
+			OrderID:     order.ID.Hex(),
+			Notes:       "Order " + order.TransactionID,
+			CreatedBy:   actor(ctx),
+			CreatedAt:   order.UpdatedAt,
+		})
+	}
+	if len(movements) == 0 {
+		return nil
+	}
+	return kh.Stock.Append(ctx, movements)
+}
@@ -0,0 +1,118 @@
+package kiosk
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/config"
+)
+
+// recordingStock keeps the batches passed to Append
+type recordingStock struct {
+	StockRepository
+	batches [][]StockMovement
+}
+
+func (s *recordingStock) Append(ctx context.Context, movements []StockMovement) error {
+	s.batches = append(s.batches, movements)
+	return s.StockRepository.Append(ctx, movements)
+}
+
+func TestSettleStock(t *testing.T) {
+	// sale is the movement expected for a product and variant
+	type sale struct {
+		product, variant string
+		quantity         int
+	}
+	tests := []struct {
+		name   string
+		status string
+		items  []OrderItem
+		want   []sale
+	}{
+		{
+			name:   "one line",
+			status: StatusCompleted,
+			items:  []OrderItem{{ProductID: "latte", Quantity: 2}},
+			want:   []sale{{"latte", "", -2}},
+		},
+		{
+			name:   "lines of a product merged",
+			status: StatusCompleted,
+			items:  []OrderItem{{ProductID: "latte", Quantity: 2}, {ProductID: "cake", Quantity: 1}, {ProductID: "latte", Quantity: 3}},
+			want:   []sale{{"latte", "", -5}, {"cake", "", -1}},
+		},
+		{
+			name:   "variants kept apart",
+			status: StatusCompleted,
+			items:  []OrderItem{{ProductID: "shirt", VariantID: "s", Quantity: 1}, {ProductID: "shirt", VariantID: "l", Quantity: 2}, {ProductID: "shirt", VariantID: "s", Quantity: 4}},
+			want:   []sale{{"shirt", "s", -5}, {"shirt", "l", -2}},
+		},
+		{
+			name:   "variant IDs of different products kept apart",
+			status: StatusCompleted,
+			items:  []OrderItem{{ProductID: "shirt", VariantID: "s", Quantity: 1}, {ProductID: "cap", VariantID: "s", Quantity: 1}},
+			want:   []sale{{"shirt", "s", -1}, {"cap", "s", -1}},
+		},
+		{
+			name:   "cancelled orders sell nothing",
+			status: StatusCancelled,
+			items:  []OrderItem{{ProductID: "latte", Quantity: 2}},
+		},
+		{
+			name:   "orders in progress sell nothing",
+			status: StatusReady,
+			items:  []OrderItem{{ProductID: "latte", Quantity: 2}},
+		},
+		{
+			name:   "empty orders sell nothing",
+			status: StatusCompleted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			kh := NewMemoryKioskHandlers(config.Default().Kiosk, nil, nil)
+			stock := &recordingStock{StockRepository: kh.Stock}
+			kh.Stock = stock
+			order := Order{ID: primitive.NewObjectID(), TransactionID: "T-1", Status: tt.status, Items: tt.items}
+
+			if err := kh.settleStock(ctx, order); err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if len(stock.batches) != 0 {
+					t.Fatalf("appended %+v, want nothing", stock.batches)
+				}
+				return
+			}
+			if len(stock.batches) != 1 || len(stock.batches[0]) != len(tt.want) {
+				t.Fatalf("appended %+v, want one batch of %d sales", stock.batches, len(tt.want))
+			}
+			for i, movement := range stock.batches[0] {
+				got := sale{movement.ProductID, movement.VariantID, movement.Quantity}
+				if got != tt.want[i] || movement.Type != StockSale || movement.OrderID != order.ID.Hex() {
+					t.Errorf("movement %d = %+v, want a sale of %+v on the order", i, movement, tt.want[i])
+				}
+			}
+
+			// Settling the order again deducts nothing more
+			if err := kh.settleStock(ctx, order); err != nil {
+				t.Fatal(err)
+			}
+			levels, err := kh.Stock.Levels(ctx, "")
+			if err != nil || len(levels) != len(tt.want) {
+				t.Fatalf("levels = %+v (%v), want %d", levels, err, len(tt.want))
+			}
+			for _, want := range tt.want {
+				for _, level := range levels {
+					if level.ProductID == want.product && level.VariantID == want.variant && level.OnHand != want.quantity {
+						t.Errorf("%s/%s on hand = %d, want %d", want.product, want.variant, level.OnHand, want.quantity)
+					}
+				}
+			}
+		})
+	}
+}
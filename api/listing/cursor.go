@@ -0,0 +1,57 @@
+package listing
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Cursor is the position of the last item of a page: its sort value and _id
+type Cursor struct {
+	// Sort is the sort order the cursor was issued for, e.g. "-price"
+	Sort  string
+	Value any
+	ID    any
+}
+
+// Encode returns the opaque form sent to clients. The values are BSON
+// encoded so their types survive the round trip.
+func (c Cursor) Encode() string {
+	raw, err := bson.Marshal(bson.D{{Key: "s", Value: c.Sort}, {Key: "v", Value: c.Value}, {Key: "id", Value: c.ID}})
+	if err != nil {
+		// Values come from decoded documents, so they always marshal
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(encoded string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	raw := bson.Raw(data)
+	if err := raw.Validate(); err != nil {
+		return nil, err
+	}
+
+	sort, ok := raw.Lookup("s").StringValueOK()
+	if !ok {
+		return nil, errors.New("cursor has no sort order")
+	}
+	id := valueOf(raw.Lookup("id"))
+	if id == nil {
+		return nil, errors.New("cursor has no id")
+	}
+	return &Cursor{Sort: sort, Value: valueOf(raw.Lookup("v")), ID: id}, nil
+}
+
+// cursorAt is the cursor pointing at document
+func (q Query) cursorAt(document bson.Raw) Cursor {
+	return Cursor{
+		Sort:  q.sortKey(),
+		Value: lookup(document, q.SortPath),
+		ID:    lookup(document, "_id"),
+	}
+}
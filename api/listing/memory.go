@@ -0,0 +1,197 @@
+package listing
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Apply runs query over items the way Find runs it in MongoDB, for the
+// in-memory repositories. Items are compared through their BSON encoding.
+func Apply[T any](items []T, query Query) (Result[T], error) {
+	type entry struct {
+		item     T
+		document bson.Raw
+	}
+
+	var matching []entry
+	for _, item := range items {
+		document, err := bson.Marshal(item)
+		if err != nil {
+			return Result[T]{}, err
+		}
+		if query.matches(document) {
+			matching = append(matching, entry{item, document})
+		}
+	}
+
+	slices.SortStableFunc(matching, func(a, b entry) int {
+		return query.compareDocuments(a.document, b.document)
+	})
+	total := int64(len(matching))
+
+	if query.After != nil {
+		after := query.After
+		matching = slices.DeleteFunc(matching, func(e entry) bool {
+			position := compareValues(lookup(e.document, query.SortPath), after.Value)
+			if position == 0 {
+				position = compareValues(lookup(e.document, "_id"), after.ID)
+			}
+			if query.Descending {
+				position = -position
+			}
+			return position <= 0
+		})
+	}
+
+	if len(matching) > query.Limit+1 {
+		matching = matching[:query.Limit+1]
+	}
+	documents := make([]bson.Raw, len(matching))
+	for i, e := range matching {
+		documents[i] = e.document
+	}
+	return paginate(query, documents, total, func(i int) (T, error) {
+		return matching[i].item, nil
+	})
+}
+
+func (q Query) matches(document bson.Raw) bool {
+	for _, c := range q.Conditions {
+		if !c.matches(lookup(document, c.Path)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches follows MongoDB: an array matches when one of its elements does,
+// and values of different types never compare
+func (c Condition) matches(value any) bool {
+	if c.Op == Ne {
+		return !Condition{Path: c.Path, Op: Eq, Value: c.Value}.matches(value)
+	}
+	if elements, ok := value.([]any); ok {
+		return slices.ContainsFunc(elements, c.matches)
+	}
+
+	if c.Op == In {
+		return slices.ContainsFunc(c.Value.([]any), func(candidate any) bool {
+			return rank(value) == rank(candidate) && compareValues(value, candidate) == 0
+		})
+	}
+	if rank(value) != rank(c.Value) {
+		return false
+	}
+	order := compareValues(value, c.Value)
+	switch c.Op {
+	case Eq:
+		return order == 0
+	case Gt:
+		return order > 0
+	case Gte:
+		return order >= 0
+	case Lt:
+		return order < 0
+	case Lte:
+		return order <= 0
+	}
+	return false
+}
+
+func (q Query) compareDocuments(a, b bson.Raw) int {
+	order := compareValues(lookup(a, q.SortPath), lookup(b, q.SortPath))
+	if order == 0 {
+		order = compareValues(lookup(a, "_id"), lookup(b, "_id"))
+	}
+	if q.Descending {
+		return -order
+	}
+	return order
+}
+
+// lookup returns the value at the dotted path of document, nil when missing
+func lookup(document bson.Raw, path string) any {
+	value, err := document.LookupErr(strings.Split(path, ".")...)
+	if err != nil {
+		return nil
+	}
+	return valueOf(value)
+}
+
+// valueOf converts value to the Go types filters are parsed into
+func valueOf(value bson.RawValue) any {
+	switch value.Type {
+	case bsontype.String:
+		return value.StringValue()
+	case bsontype.Int32:
+		return float64(value.Int32())
+	case bsontype.Int64:
+		return float64(value.Int64())
+	case bsontype.Double:
+		return value.Double()
+	case bsontype.Boolean:
+		return value.Boolean()
+	case bsontype.DateTime:
+		return value.Time().UTC()
+	case bsontype.ObjectID:
+		return value.ObjectID()
+	case bsontype.Array:
+		elements, _ := value.Array().Values()
+		list := make([]any, len(elements))
+		for i, element := range elements {
+			list[i] = valueOf(element)
+		}
+		return list
+	}
+	return nil
+}
+
+// rank orders values of different types like MongoDB does
+func rank(value any) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case float64:
+		return 1
+	case string:
+		return 2
+	case primitive.ObjectID:
+		return 3
+	case bool:
+		return 4
+	case time.Time:
+		return 5
+	}
+	return 6
+}
+
+func compareValues(a, b any) int {
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return cmp.Compare(ra, rb)
+	}
+	switch a := a.(type) {
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case primitive.ObjectID:
+		return strings.Compare(a.Hex(), b.(primitive.ObjectID).Hex())
+	case bool:
+		if a == b.(bool) {
+			return 0
+		}
+		if !a {
+			return -1
+		}
+		return 1
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package listing
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"isy-api/response"
+)
+
+// Result is one page of a list
+type Result[T any] struct {
+	Items []T
+	// Total counts every matching item, not only this page
+	Total int64
+	Limit int
+	// Next is the cursor of the following page, empty on the last one
+	Next string
+}
+
+// Page describes the result for the response envelope
+func (r Result[T]) Page() response.Page {
+	return response.Page{Total: r.Total, Limit: r.Limit, NextCursor: r.Next}
+}
+
+// Find runs query against collection and decodes the page into T
+func Find[T any](ctx context.Context, collection *mongo.Collection, query Query) (Result[T], error) {
+	total, err := collection.CountDocuments(ctx, query.Filter())
+	if err != nil {
+		return Result[T]{}, err
+	}
+
+	// One extra document tells whether another page follows
+	opts := options.Find().SetSort(query.Sort()).SetLimit(int64(query.Limit) + 1)
+	if len(query.Hidden) > 0 {
+		projection := bson.M{}
+		for _, path := range query.Hidden {
+			projection[path] = 0
+		}
+		opts.SetProjection(projection)
+	}
+	cursor, err := collection.Find(ctx, query.pageFilter(), opts)
+	if err != nil {
+		return Result[T]{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var documents []bson.Raw
+	for cursor.Next(ctx) {
+		documents = append(documents, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		return Result[T]{}, err
+	}
+
+	return paginate(query, documents, total, func(i int) (T, error) {
+		var item T
+		err := bson.Unmarshal(documents[i], &item)
+		return item, err
+	})
+}
+
+// paginate builds the result from the matching documents after the cursor,
+// in order; decode returns the item of documents[i]
+func paginate[T any](query Query, documents []bson.Raw, total int64, decode func(i int) (T, error)) (Result[T], error) {
+	result := Result[T]{Items: []T{}, Total: total, Limit: query.Limit}
+	if len(documents) > query.Limit {
+		documents = documents[:query.Limit]
+		result.Next = query.cursorAt(documents[len(documents)-1]).Encode()
+	}
+	for i := range documents {
+		item, err := decode(i)
+		if err != nil {
+			return Result[T]{}, err
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result, nil
+}
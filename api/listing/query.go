@@ -0,0 +1,350 @@
+// Package listing parses the pagination, sorting and filtering parameters of
+// list endpoints and runs them against MongoDB or in-memory documents.
+//
+// A list request looks like
+//
+//	GET /kiosk/v1/products?limit=20&sort=-price&categoryId=drinks&price[lte]=10
+//
+// Each resource whitelists the fields clients may filter and sort on in a
+// Spec; any other parameter is refused. Pages are keyset based: the response
+// carries an opaque cursor naming the last item, and passing it back as
+// cursor returns the items after it, so results stay stable while documents
+// are inserted.
+package listing
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/response"
+)
+
+// Page size limits
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// Parameters with a fixed meaning on every list endpoint
+const (
+	LimitParam  = "limit"
+	SortParam   = "sort"
+	CursorParam = "cursor"
+)
+
+// Type is the type filter values are parsed as
+type Type int
+
+const (
+	String Type = iota
+	Number
+	Bool
+	Time
+	ObjectID
+)
+
+// Op is a filter operator, written field[op]=value. A bare field=value means Eq.
+type Op string
+
+const (
+	Eq  Op = "eq"
+	Ne  Op = "ne"
+	Gt  Op = "gt"
+	Gte Op = "gte"
+	Lt  Op = "lt"
+	Lte Op = "lte"
+	// In takes a comma separated list
+	In Op = "in"
+)
+
+var mongoOps = map[Op]string{Eq: "$eq", Ne: "$ne", Gt: "$gt", Gte: "$gte", Lt: "$lt", Lte: "$lte", In: "$in"}
+
+// Field is a document field clients may filter on
+type Field struct {
+	// Name is the query parameter
+	Name string
+	// Path is the document field, Name when empty
+	Path     string
+	Type     Type
+	Sortable bool
+	// Ops lists the accepted operators. Empty accepts Eq, Ne and In for
+	// strings, object IDs and booleans, and every operator for numbers and times.
+	Ops []Op
+}
+
+func (f Field) path() string {
+	if f.Path == "" {
+		return f.Name
+	}
+	return f.Path
+}
+
+func (f Field) accepts(op Op) bool {
+	if len(f.Ops) > 0 {
+		return slices.Contains(f.Ops, op)
+	}
+	switch f.Type {
+	case Number, Time:
+		return true
+	}
+	return op == Eq || op == Ne || op == In
+}
+
+// Spec describes the list parameters of one resource
+type Spec struct {
+	Fields []Field
+	// DefaultSort is used when the request has no sort, e.g. "-createdAt".
+	// Empty sorts by _id.
+	DefaultSort string
+	// Hidden lists internal document fields left out of results
+	Hidden []string
+}
+
+func (s Spec) field(name string) (Field, bool) {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Condition restricts a document field
+type Condition struct {
+	Path  string
+	Op    Op
+	Value any
+}
+
+// Query is a parsed list request
+type Query struct {
+	Conditions []Condition
+	// SortPath is the document field results are ordered by; ties are broken
+	// by _id in the same direction
+	SortPath   string
+	Descending bool
+	Limit      int
+	// After is the position of the previous page's last item, nil on the
+	// first page
+	After *Cursor
+	// Hidden lists fields left out of results
+	Hidden []string
+}
+
+// Parse reads the list parameters of r. Problems are reported together as a
+// validation error with one entry per parameter.
+func Parse(r *http.Request, spec Spec) (Query, error) {
+	query := Query{SortPath: "_id", Limit: DefaultLimit, Hidden: spec.Hidden}
+	var problems []response.FieldError
+	fail := func(param string, code response.Code, format string, args ...any) {
+		problems = append(problems, response.FieldError{Field: param, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	values := r.URL.Query()
+
+	if raw := values.Get(LimitParam); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			fail(LimitParam, codeInvalidValue, "must be a number between 1 and %d", MaxLimit)
+		} else {
+			query.Limit = limit
+		}
+	}
+
+	sort := values.Get(SortParam)
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	if sort != "" {
+		name := strings.TrimPrefix(sort, "-")
+		if field, ok := spec.field(name); ok && field.Sortable {
+			query.SortPath = field.path()
+			query.Descending = strings.HasPrefix(sort, "-")
+		} else {
+			fail(SortParam, codeNotAllowed, "cannot sort by %q", name)
+		}
+	}
+
+	for param, list := range values {
+		switch param {
+		case LimitParam, SortParam, CursorParam:
+			continue
+		}
+
+		name, op := param, Eq
+		if open := strings.IndexByte(param, '['); open > 0 && strings.HasSuffix(param, "]") {
+			name, op = param[:open], Op(param[open+1:len(param)-1])
+		}
+		field, ok := spec.field(name)
+		if !ok {
+			fail(param, codeNotAllowed, "unknown filter %q", name)
+			continue
+		}
+		if _, known := mongoOps[op]; !known || !field.accepts(op) {
+			fail(param, codeNotAllowed, "operator %q is not supported for %s", op, name)
+			continue
+		}
+
+		value, err := parseFilter(field.Type, op, list[len(list)-1])
+		if err != nil {
+			fail(param, codeInvalidValue, "%v", err)
+			continue
+		}
+		query.Conditions = append(query.Conditions, Condition{Path: field.path(), Op: op, Value: value})
+	}
+	// Map iteration order is random; keep filters deterministic for logs and
+	// query plans
+	slices.SortFunc(query.Conditions, func(a, b Condition) int {
+		return strings.Compare(a.Path+string(a.Op), b.Path+string(b.Op))
+	})
+
+	if raw := values.Get(CursorParam); raw != "" {
+		after, err := decodeCursor(raw)
+		if err != nil || after.Sort != query.sortKey() {
+			fail(CursorParam, codeInvalidValue, "cursor is invalid or was issued for another sort order")
+		} else {
+			query.After = after
+		}
+	}
+
+	if len(problems) > 0 {
+		return Query{}, response.New(http.StatusBadRequest, response.CodeValidationFailed, "Invalid list parameters").WithFields(problems...)
+	}
+	return query, nil
+}
+
+// Codes of the field errors Parse reports
+const (
+	codeInvalidValue response.Code = "invalid_value"
+	codeNotAllowed   response.Code = "not_allowed"
+)
+
+// sortKey identifies the sort order a cursor belongs to
+func (q Query) sortKey() string {
+	if q.Descending {
+		return "-" + q.SortPath
+	}
+	return q.SortPath
+}
+
+func parseFilter(t Type, op Op, raw string) (any, error) {
+	if op == In {
+		var list []any
+		for _, item := range strings.Split(raw, ",") {
+			value, err := parseValue(t, strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+	return parseValue(t, raw)
+}
+
+func parseValue(t Type, raw string) (any, error) {
+	switch t {
+	case Number:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return n, nil
+	case Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not true or false", raw)
+		}
+		return b, nil
+	case Time:
+		for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+			if ts, err := time.Parse(layout, raw); err == nil {
+				return ts, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not an RFC 3339 time or a date", raw)
+	case ObjectID:
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an ID", raw)
+		}
+		return id, nil
+	}
+	return raw, nil
+}
+
+// Filter is the MongoDB filter selecting the matching documents, ignoring the
+// cursor
+func (q Query) Filter() bson.M {
+	filter := bson.M{}
+	for _, c := range q.Conditions {
+		ops, _ := filter[c.Path].(bson.M)
+		if ops == nil {
+			ops = bson.M{}
+			filter[c.Path] = ops
+		}
+		ops[mongoOps[c.Op]] = c.Value
+	}
+	return filter
+}
+
+// Sort is the MongoDB sort order
+func (q Query) Sort() bson.D {
+	direction := 1
+	if q.Descending {
+		direction = -1
+	}
+	if q.SortPath == "_id" {
+		return bson.D{{Key: "_id", Value: direction}}
+	}
+	return bson.D{{Key: q.SortPath, Value: direction}, {Key: "_id", Value: direction}}
+}
+
+// pageFilter is Filter restricted to the documents after the cursor. MongoDB
+// sorts null and missing values before every other value, which the
+// conditions mirror.
+func (q Query) pageFilter() bson.M {
+	filter := q.Filter()
+	if q.After == nil {
+		return filter
+	}
+
+	after, idOp := "$gt", "$gt"
+	if q.Descending {
+		after, idOp = "$lt", "$lt"
+	}
+	if q.SortPath == "_id" {
+		return bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{idOp: q.After.ID}}}}
+	}
+
+	path, value := q.SortPath, q.After.Value
+	var next bson.A
+	switch {
+	case value == nil && !q.Descending:
+		next = bson.A{
+			bson.M{path: bson.M{"$ne": nil}},
+			bson.M{path: nil, "_id": bson.M{idOp: q.After.ID}},
+		}
+	case value == nil:
+		next = bson.A{bson.M{path: nil, "_id": bson.M{idOp: q.After.ID}}}
+	case !q.Descending:
+		next = bson.A{
+			bson.M{path: bson.M{after: value}},
+			bson.M{path: value, "_id": bson.M{idOp: q.After.ID}},
+		}
+	default:
+		next = bson.A{
+			bson.M{path: bson.M{after: value}},
+			bson.M{path: value, "_id": bson.M{idOp: q.After.ID}},
+			bson.M{path: nil},
+		}
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{"$or": next}}}
+}
@@ -0,0 +1,154 @@
+package listing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/mongodb/mongotest"
+	"isy-api/response"
+)
+
+// item has a sort value that repeats across items and is missing on some
+type item struct {
+	ID    primitive.ObjectID `bson:"_id"`
+	Name  string             `bson:"name"`
+	Price *float64           `bson:"price,omitempty"`
+}
+
+var itemSpec = Spec{Fields: []Field{
+	{Name: "price", Type: Number, Sortable: true},
+	{Name: "name", Type: String},
+}}
+
+// items returns a to g, whose IDs sort in that order
+func items() []item {
+	price := func(p float64) *float64 { return &p }
+	prices := map[string]*float64{"a": price(2), "b": price(1), "c": nil, "d": price(2), "e": price(1), "f": nil, "g": price(3)}
+	var list []item
+	for i, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		var id primitive.ObjectID
+		id[len(id)-1] = byte(i + 1)
+		list = append(list, item{ID: id, Name: name, Price: prices[name]})
+	}
+	// Stored out of order, so sorting is not a no-op
+	slices.Reverse(list)
+	return list
+}
+
+func parse(t *testing.T, params url.Values) Query {
+	t.Helper()
+	query, err := Parse(httptest.NewRequest("GET", "/items?"+params.Encode(), nil), itemSpec)
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", params.Encode(), err)
+	}
+	return query
+}
+
+// pageThrough follows the cursors of find from the first page to the last
+// and returns the names in the order they were listed
+func pageThrough(t *testing.T, sort string, limit int, find func(Query) (Result[item], error)) []string {
+	t.Helper()
+	var names []string
+	params := url.Values{SortParam: {sort}, LimitParam: {strconv.Itoa(limit)}}
+	for pages := 0; ; pages++ {
+		if pages > len(items()) {
+			t.Fatalf("sort %q, limit %d: the cursors never ran out, listed %v", sort, limit, names)
+		}
+		result, err := find(parse(t, params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Total != int64(len(items())) {
+			t.Errorf("total = %d, want %d", result.Total, len(items()))
+		}
+		if len(result.Items) > limit {
+			t.Fatalf("page of %d items, limit %d", len(result.Items), limit)
+		}
+		for _, it := range result.Items {
+			names = append(names, it.Name)
+		}
+		if result.Next == "" {
+			return names
+		}
+		params.Set(CursorParam, result.Next)
+	}
+}
+
+// paging lists every sort with the order it must produce: missing values
+// first as MongoDB sorts them, ties broken by _id in the sort's direction
+var paging = []struct {
+	sort string
+	want []string
+}{
+	{"price", []string{"c", "f", "b", "e", "a", "d", "g"}},
+	{"-price", []string{"g", "d", "a", "e", "b", "f", "c"}},
+	{"", []string{"a", "b", "c", "d", "e", "f", "g"}},
+}
+
+func testPaging(t *testing.T, find func(Query) (Result[item], error)) {
+	for _, tt := range paging {
+		for _, limit := range []int{1, 2, 3, 7, 50} {
+			t.Run(tt.sort+"/"+strconv.Itoa(limit), func(t *testing.T) {
+				// Equal to want means nothing was skipped or listed twice
+				if got := pageThrough(t, tt.sort, limit, find); !slices.Equal(got, tt.want) {
+					t.Errorf("listed %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestApplyPaging(t *testing.T) {
+	testPaging(t, func(query Query) (Result[item], error) {
+		return Apply(items(), query)
+	})
+}
+
+func TestFindPaging(t *testing.T) {
+	collection := mongotest.Database(t).Collection("items")
+	ctx := context.Background()
+	for _, it := range items() {
+		if _, err := collection.InsertOne(ctx, it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testPaging(t, func(query Query) (Result[item], error) {
+		return Find[item](ctx, collection, query)
+	})
+}
+
+func TestCursorForAnotherSort(t *testing.T) {
+	first, err := Apply(items(), parse(t, url.Values{SortParam: {"price"}, LimitParam: {"2"}}))
+	if err != nil || first.Next == "" {
+		t.Fatalf("first page = %+v, %v", first, err)
+	}
+
+	tests := []struct {
+		name   string
+		params url.Values
+	}{
+		{"descending", url.Values{SortParam: {"-price"}, CursorParam: {first.Next}}},
+		{"by _id", url.Values{CursorParam: {first.Next}}},
+		{"garbage", url.Values{SortParam: {"price"}, CursorParam: {"not-a-cursor"}}},
+		{"truncated", url.Values{SortParam: {"price"}, CursorParam: {first.Next[:len(first.Next)/2]}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(httptest.NewRequest("GET", "/items?"+tt.params.Encode(), nil), itemSpec)
+			problem, ok := err.(*response.Error)
+			if !ok || problem.Status != http.StatusBadRequest {
+				t.Fatalf("Parse error = %v, want a validation error", err)
+			}
+		})
+	}
+
+	// The cursor still works for the sort it was issued for
+	parse(t, url.Values{SortParam: {"price"}, CursorParam: {first.Next}})
+}
@@ -2,154 +2,373 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/rs/cors"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"isy-api/admin"
+	"isy-api/audit"
+	"isy-api/auth"
+	"isy-api/config"
 	"isy-api/healthcare"
 	"isy-api/kiosk"
+	"isy-api/migrations"
+	"isy-api/mongodb"
+	"isy-api/observability"
+	"isy-api/response"
 	"isy-api/retail"
+	"isy-api/tenant"
 )
 
+// App wires services and routes around one database connection. Until
+// MongoDB is reachable the App built by Initialize serves with a nil DB, which
+// handlers answer with 503. Once the connection manager connects, a fresh App
+// is built around the live database and swapped in, so a request always sees
+// one consistent set of handlers.
 type App struct {
-	Router   *mux.Router
-	DB       *mongo.Database
-	Client   *mongo.Client
+	Router  *mux.Router
+	DB      *mongo.Database
+	Client  *mongo.Client
+	Auth    *auth.Service
+	Audit   *audit.Logger
+	Tenants *tenant.Registry
+	Mongo   *mongodb.Manager
+	Config  *config.Config
+
+	signer          *auth.Signer
+	proxies         auth.Proxies
+	live            *atomic.Pointer[App]
+	shutdownTracing func(context.Context) error
 }
 
-func (a *App) Initialize() {
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
-	}
+// Initialize starts the App with the validated configuration cfg
+func (a *App) Initialize(cfg *config.Config) {
+	a.Config = cfg
 
-	// Get MongoDB connection string
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://localhost:27017"
+	// Token signing keys are shared by every App generation so tokens stay
+	// valid when the database connects
+	signer, err := auth.NewSignerFromConfig(cfg.Auth.JWT)
+	if err != nil {
+		log.Fatalf("Invalid JWT configuration: %v", err)
 	}
+	a.signer = signer
 
-	// Try to connect to MongoDB, but don't fail if it's not available
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	proxies, err := auth.ParseProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	a.proxies = proxies
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	// Tracing is set up before the first client or router is built so both
+	// pick up the exporter
+	shutdownTracing, err := observability.SetupTracing(context.Background(), cfg.Observability.TracesExporter)
 	if err != nil {
-		log.Printf("Warning: Failed to connect to MongoDB: %v", err)
-		log.Println("Server will start without database connection")
-		a.DB = nil
-		a.Client = nil
-	} else {
-		// Ping the database
-		if err := client.Ping(ctx, nil); err != nil {
-			log.Printf("Warning: Failed to ping MongoDB: %v", err)
-			log.Println("Server will start without database connection")
-			a.DB = nil
-			a.Client = nil
-		} else {
-			fmt.Println("✅ Connected to MongoDB")
-
-			// Get database name
-			dbName := os.Getenv("DB_NAME")
-			if dbName == "" {
-				dbName = "isy_api"
-			}
-
-			a.DB = client.Database(dbName)
-			a.Client = client
+		log.Fatalf("Invalid tracing configuration: %v", err)
+	}
+	a.shutdownTracing = shutdownTracing
+
+	a.live = &atomic.Pointer[App]{}
+	a.setup(nil, nil)
+	a.live.Store(a)
+
+	// Connect in the background; the server starts either way
+	a.Mongo = mongodb.NewManager(cfg.Mongo.URI, cfg.Mongo.Database)
+	a.Mongo.Monitor = observability.MongoMonitor()
+	a.Mongo.OnConnect = a.connected
+	a.Mongo.Start()
+}
+
+// connected swaps in an App built around the live database
+func (a *App) connected(client *mongo.Client, db *mongo.Database) {
+	next := &App{Mongo: a.Mongo, Config: a.Config, signer: a.signer, proxies: a.proxies, live: a.live}
+	next.setup(client, db)
+	a.live.Store(next)
+}
+
+// setup builds the services and routes for db, which may be nil
+func (a *App) setup(client *mongo.Client, db *mongo.Database) {
+	a.Client = client
+	a.DB = db
+
+	a.Auth = auth.NewService(a.DB, a.signer, a.Config.Auth)
+	a.Audit = audit.NewLogger(a.DB)
+	a.Tenants = tenant.NewRegistry(a.Client, a.DB, a.Config.Tenants)
+	if a.DB != nil {
+		migrator := migrations.NewRunner(a.DB, a.Tenants)
+		a.Tenants.Prepare = migrator.MigrateTenant
+		if a.Config.Mongo.MigrateOnBoot {
+			a.migrate(migrator)
 		}
 	}
 
-	// Initialize router
+	// Initialize router; the route template names spans and labels access
+	// logs and metrics
 	a.Router = mux.NewRouter()
+	a.Router.Use(observability.TraceMiddleware(), observability.RouteMiddleware, a.proxies.Middleware)
+	a.Router.NotFoundHandler = response.NotFoundHandler()
+	a.Router.MethodNotAllowedHandler = response.MethodNotAllowedHandler()
 
 	// Setup routes
 	a.setupRoutes()
 }
 
+// migrate applies pending migrations before the App serves the database. A
+// failure is logged and the API starts anyway, like it does without MongoDB.
+func (a *App) migrate(migrator *migrations.Runner) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	err := migrator.Up(ctx, 0)
+	switch {
+	case errors.Is(err, migrations.ErrLocked):
+		log.Println("Migrations are being applied by another instance, skipping")
+	case err != nil:
+		log.Printf("Warning: Failed to apply migrations: %v", err)
+	}
+}
+
+// ServeHTTP dispatches to the current App generation
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.live.Load().Router.ServeHTTP(w, r)
+}
+
+// Close disconnects from MongoDB and flushes pending trace spans
+func (a *App) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := a.Mongo.Disconnect(ctx); err != nil {
+		log.Printf("Warning: Failed to disconnect from MongoDB: %v", err)
+	}
+	if err := a.shutdownTracing(ctx); err != nil {
+		log.Printf("Warning: Failed to flush traces: %v", err)
+	}
+}
+
 func (a *App) setupRoutes() {
 	// Health check
 	a.Router.HandleFunc("/health", a.healthCheck).Methods("GET")
-	
-	// Serve uploaded files from /root/uploads (Docker volume mount)
-	uploadsDir := os.Getenv("UPLOADS_DIR")
-	if uploadsDir == "" {
-		uploadsDir = "/root/uploads" // Default Docker location
-	}
-	a.Router.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir(uploadsDir))))
+	a.Router.HandleFunc("/health/live", a.liveness).Methods("GET")
+	a.Router.HandleFunc("/health/ready", a.readiness).Methods("GET")
+
+	// Prometheus metrics, behind a bearer token when METRICS_TOKEN is set
+	a.Router.Handle("/metrics", observability.MetricsHandler(a.Config.Observability.MetricsToken)).Methods("GET")
+
+	// Serve uploaded files, each tenant's from its own folder
+	a.Router.PathPrefix("/uploads/").Handler(a.Tenants.Middleware(http.HandlerFunc(a.serveUpload)))
 
 	// Initialize handlers
+	authHandlers := auth.NewAuthHandlers(a.Auth)
 	healthcareHandlers := healthcare.NewHealthcareHandlers(a.DB)
-	retailHandlers := retail.NewRetailHandlers(a.DB)
-	kioskHandlers := kiosk.NewKioskHandlers(a.DB)
+	retailHandlers := retail.NewRetailHandlers(a.DB, a.Auth)
+	kioskHandlers := kiosk.NewKioskHandlers(a.DB, a.Auth, a.Config.Kiosk)
+	adminHandlers := admin.NewAdminHandlers(a.DB, a.Auth, a.Audit, a.Tenants)
+
+	// Each module only answers browsers on its own front-end origins; routes
+	// outside these subrouters send no CORS headers at all
+	origins := a.Config.CORS
+	sharedCORS := corsPolicy(sharedOrigins(origins), origins.MaxAge)
+	// Every body but an upload, which uploadFile caps at the file size limit
+	bodyLimit := limitBody(a.Config.Server.MaxBodySize)
+
+	// Shared auth routes
+	authAPI := a.Router.PathPrefix("/auth").Subrouter()
+	authAPI.Use(sharedCORS, bodyLimit)
+	allowPreflight(authAPI)
+	authAPI.HandleFunc("/login", authHandlers.Login).Methods("POST")
+	authAPI.HandleFunc("/mfa/verify", authHandlers.VerifyMFA).Methods("POST")
+	authAPI.HandleFunc("/refresh", authHandlers.Refresh).Methods("POST")
+	authAPI.HandleFunc("/logout", authHandlers.Logout).Methods("POST")
+
+	// Admin API v1 routes
+	adminAPI := a.Router.PathPrefix("/admin/v1").Subrouter()
+	adminAPI.Use(sharedCORS, bodyLimit)
+	allowPreflight(adminAPI)
+
+	// The current admin can always reach their own profile, password and MFA
+	// settings, even while a password change or MFA enrollment is pending
+	adminSelf := adminAPI.PathPrefix("/me").Subrouter()
+	adminSelf.Use(a.Auth.AccountSetupMiddleware)
+	adminSelf.HandleFunc("", adminHandlers.GetCurrentUser).Methods("GET")
+	adminSelf.HandleFunc("/password", adminHandlers.ChangeOwnPassword).Methods("POST")
+	adminSelf.HandleFunc("/mfa/enroll", adminHandlers.BeginMFAEnrollment).Methods("POST")
+	adminSelf.HandleFunc("/mfa/activate", adminHandlers.ActivateMFA).Methods("POST")
+	adminSelf.HandleFunc("/mfa/recovery-codes", adminHandlers.RegenerateRecoveryCodes).Methods("POST")
+	adminSelf.HandleFunc("/mfa", adminHandlers.DisableMFA).Methods("DELETE")
+
+	adminUsers := adminAPI.NewRoute().Subrouter()
+	adminUsers.Use(a.Auth.Middleware)
+	adminUsers.Handle("/users", auth.Require("admin:users:read", adminHandlers.GetUsers)).Methods("GET")
+	adminUsers.Handle("/users", auth.Require("admin:users:write", adminHandlers.CreateUser)).Methods("POST")
+	adminUsers.Handle("/users/{id}", auth.Require("admin:users:read", adminHandlers.GetUser)).Methods("GET")
+	adminUsers.Handle("/users/{id}", auth.Require("admin:users:write", adminHandlers.UpdateUser)).Methods("PUT")
+	adminUsers.Handle("/users/{id}", auth.Require("admin:users:write", adminHandlers.DeleteUser)).Methods("DELETE")
+	adminUsers.Handle("/users/{id}/password", auth.Require("admin:users:write", adminHandlers.ResetPassword)).Methods("PUT")
+	adminUsers.Handle("/users/{id}/mfa", auth.Require("admin:users:write", adminHandlers.ResetMFA)).Methods("DELETE")
+	adminUsers.Handle("/users/{id}/unlock", auth.Require("admin:users:write", adminHandlers.UnlockUser)).Methods("POST")
+	adminUsers.Handle("/api-keys", auth.Require("admin:api-keys:read", adminHandlers.GetAPIKeys)).Methods("GET")
+	adminUsers.Handle("/api-keys", auth.Require("admin:api-keys:write", adminHandlers.CreateAPIKey)).Methods("POST")
+	adminUsers.Handle("/api-keys/{id}", auth.Require("admin:api-keys:write", adminHandlers.RevokeAPIKey)).Methods("DELETE")
+	adminUsers.Handle("/lockouts", auth.Require("admin:users:read", adminHandlers.GetLockouts)).Methods("GET")
+	adminUsers.Handle("/lockouts/{key}", auth.Require("admin:users:write", adminHandlers.DeleteLockout)).Methods("DELETE")
+	adminUsers.Handle("/tenants", auth.Require("platform:tenants:read", adminHandlers.GetTenants)).Methods("GET")
+	adminUsers.Handle("/tenants", auth.Require("platform:tenants:write", adminHandlers.CreateTenant)).Methods("POST")
 
-	// Healthcare API v1 routes
+	adminTenant := adminAPI.NewRoute().Subrouter()
+	adminTenant.Use(a.Auth.Middleware, a.Tenants.Middleware)
+	adminTenant.Handle("/search/reindex", auth.Require("admin:search:write", a.reindexSearch)).Methods("POST")
+
+	// Business routes are bound to a tenant by a.Tenants.Middleware. On
+	// protected routes it runs after auth so the token's tenant is enforced,
+	// which is why public and protected routes sit in separate subrouters.
+
+	// Healthcare API v1 routes (all require a token)
 	healthcareAPI := a.Router.PathPrefix("/healthcare/v1").Subrouter()
-	healthcareAPI.HandleFunc("/patients", healthcareHandlers.GetPatients).Methods("GET")
-	healthcareAPI.HandleFunc("/patients", healthcareHandlers.CreatePatient).Methods("POST")
-	healthcareAPI.HandleFunc("/appointments", healthcareHandlers.GetAppointments).Methods("GET")
-	healthcareAPI.HandleFunc("/appointments", healthcareHandlers.CreateAppointment).Methods("POST")
-	healthcareAPI.HandleFunc("/medical-records", healthcareHandlers.GetMedicalRecords).Methods("GET")
-	healthcareAPI.HandleFunc("/medical-records", healthcareHandlers.CreateMedicalRecord).Methods("POST")
+	healthcareAPI.Use(corsPolicy(origins.Healthcare, origins.MaxAge), bodyLimit, a.Auth.Middleware, a.Tenants.Middleware)
+	allowPreflight(healthcareAPI)
+	healthcareAPI.Handle("/patients", auth.Require("healthcare:patients:read", healthcareHandlers.GetPatients)).Methods("GET")
+	healthcareAPI.Handle("/patients", auth.Require("healthcare:patients:write", healthcareHandlers.CreatePatient)).Methods("POST")
+	healthcareAPI.Handle("/appointments", auth.Require("healthcare:appointments:read", healthcareHandlers.GetAppointments)).Methods("GET")
+	healthcareAPI.Handle("/appointments", auth.Require("healthcare:appointments:write", healthcareHandlers.CreateAppointment)).Methods("POST")
+	healthcareAPI.Handle("/medical-records", auth.Require("healthcare:medical-records:read", healthcareHandlers.GetMedicalRecords)).Methods("GET")
+	healthcareAPI.Handle("/medical-records", auth.Require("healthcare:medical-records:write", healthcareHandlers.CreateMedicalRecord)).Methods("POST")
+	healthcareAPI.Handle("/search", auth.Require("healthcare:patients:read", healthcareHandlers.SearchPatients)).Methods("GET")
 
 	// Retail API v1 routes
 	retailAPI := a.Router.PathPrefix("/retail/v1").Subrouter()
+	retailAPI.Use(corsPolicy(origins.Retail, origins.MaxAge), bodyLimit)
+	allowPreflight(retailAPI)
 	retailAPI.HandleFunc("/auth", retailHandlers.Authenticate).Methods("POST")
-	retailAPI.HandleFunc("/products", retailHandlers.GetProducts).Methods("GET")
-	retailAPI.HandleFunc("/products", retailHandlers.CreateProduct).Methods("POST")
-	retailAPI.HandleFunc("/products/{id}", retailHandlers.GetProduct).Methods("GET")
-	retailAPI.HandleFunc("/products/{id}", retailHandlers.UpdateProduct).Methods("PUT")
-	retailAPI.HandleFunc("/products/{id}", retailHandlers.DeleteProduct).Methods("DELETE")
-	retailAPI.HandleFunc("/metadata", retailHandlers.GetMetadata).Methods("GET")
-	retailAPI.HandleFunc("/metadata", retailHandlers.SaveMetadata).Methods("POST")
-	retailAPI.HandleFunc("/migrate-base64", retailHandlers.MigrateBase64ToFiles).Methods("POST")
+
+	retailPublic := retailAPI.NewRoute().Subrouter()
+	retailPublic.Use(a.Tenants.Middleware)
+	retailPublic.HandleFunc("/products", retailHandlers.GetProducts).Methods("GET")
+	retailPublic.HandleFunc("/products/{id}", retailHandlers.GetProduct).Methods("GET")
+	retailPublic.HandleFunc("/metadata", retailHandlers.GetMetadata).Methods("GET")
+	retailPublic.HandleFunc("/search", retailHandlers.SearchProducts).Methods("GET")
+
+	retailAdmin := retailAPI.NewRoute().Subrouter()
+	retailAdmin.Use(a.Auth.Middleware, a.Tenants.Middleware)
+	retailAdmin.Handle("/products", auth.Require("retail:products:write", retailHandlers.CreateProduct)).Methods("POST")
+	retailAdmin.Handle("/products/{id}", auth.Require("retail:products:write", retailHandlers.UpdateProduct)).Methods("PUT")
+	retailAdmin.Handle("/products/{id}", auth.Require("retail:products:write", retailHandlers.DeleteProduct)).Methods("DELETE")
+	retailAdmin.Handle("/metadata", auth.Require("retail:metadata:write", retailHandlers.SaveMetadata)).Methods("POST")
+	retailAdmin.Handle("/migrate-base64", auth.Require("retail:metadata:write", retailHandlers.MigrateBase64ToFiles)).Methods("POST")
 
 	// Kiosk API v1 routes
 	kioskAPI := a.Router.PathPrefix("/kiosk/v1").Subrouter()
+	kioskAPI.Use(corsPolicy(origins.Kiosk, origins.MaxAge), bodyLimit)
+	allowPreflight(kioskAPI)
 	kioskAPI.HandleFunc("/auth", kioskHandlers.Authenticate).Methods("POST")
-	kioskAPI.HandleFunc("/products", kioskHandlers.GetProducts).Methods("GET")
-	kioskAPI.HandleFunc("/products", kioskHandlers.CreateProduct).Methods("POST")
-	kioskAPI.HandleFunc("/customers", kioskHandlers.GetCustomers).Methods("GET")
+
+	kioskPublic := kioskAPI.NewRoute().Subrouter()
+	kioskPublic.Use(a.Tenants.Middleware)
+	kioskPublic.HandleFunc("/products", kioskHandlers.GetProducts).Methods("GET")
+
+	kioskAdmin := kioskAPI.NewRoute().Subrouter()
+	kioskAdmin.Use(a.Auth.Middleware, a.Tenants.Middleware)
+	kioskAdmin.Handle("/products", auth.Require("kiosk:products:write", kioskHandlers.CreateProduct)).Methods("POST")
+	kioskAdmin.Handle("/customers", auth.Require("kiosk:customers:read", kioskHandlers.GetCustomers)).Methods("GET")
+	kioskAdmin.Handle("/customers/{id}/points", auth.Require("kiosk:customers:read", kioskHandlers.GetCustomerPoints)).Methods("GET")
+	kioskAdmin.Handle("/customers/{id}/points/adjustments", auth.Require("kiosk:points:write", kioskHandlers.AdjustPoints)).Methods("POST")
+	kioskAdmin.Handle("/orders", auth.Require("kiosk:orders:read", kioskHandlers.GetOrders)).Methods("GET")
+	kioskAdmin.Handle("/orders", auth.Require("kiosk:orders:write", kioskHandlers.CreateOrder)).Methods("POST")
+	kioskAdmin.Handle("/orders/settlements", auth.Require("kiosk:orders:write", kioskHandlers.SettleOrders)).Methods("POST")
+	kioskAdmin.Handle("/orders/{id}", auth.Require("kiosk:orders:read", kioskHandlers.GetOrder)).Methods("GET")
+	kioskAdmin.Handle("/orders/{id}/status", auth.Require("kiosk:orders:write", kioskHandlers.UpdateOrderStatus)).Methods("PUT")
+	kioskAdmin.Handle("/orders/{id}/pending-points", auth.Require("kiosk:orders:write", kioskHandlers.CreatePointsAward)).Methods("POST")
+	kioskAdmin.Handle("/pending-points", auth.Require("kiosk:points:read", kioskHandlers.GetPointsAwards)).Methods("GET")
+	kioskAdmin.Handle("/pending-points/status", auth.Require("kiosk:points:approve", kioskHandlers.DecidePointsAwards)).Methods("PUT")
+	kioskAdmin.Handle("/pending-points/credits", auth.Require("kiosk:points:approve", kioskHandlers.CreditPointsAwards)).Methods("POST")
+	kioskAdmin.Handle("/pending-points/{id}", auth.Require("kiosk:points:read", kioskHandlers.GetPointsAward)).Methods("GET")
+	kioskAdmin.Handle("/pending-points/{id}/status", auth.Require("kiosk:points:approve", kioskHandlers.DecidePointsAward)).Methods("PUT")
+	// A quote previews an order, so it takes the same permission
+	kioskAdmin.Handle("/quote", auth.Require("kiosk:orders:write", kioskHandlers.Quote)).Methods("POST")
+	kioskAdmin.Handle("/stock", auth.Require("kiosk:stock:read", kioskHandlers.GetStock)).Methods("GET")
+	kioskAdmin.Handle("/stock/movements", auth.Require("kiosk:stock:read", kioskHandlers.GetStockMovements)).Methods("GET")
+	kioskAdmin.Handle("/stock/movements", auth.Require("kiosk:stock:write", kioskHandlers.CreateStockMovements)).Methods("POST")
+	// Customers are only searched for callers allowed to read them
+	kioskAdmin.HandleFunc("/search", kioskHandlers.Search).Methods("GET")
 
 	// Legacy API v1 routes (for backward compatibility)
 	api := a.Router.PathPrefix("/api/v1").Subrouter()
-	api.HandleFunc("/content", a.getContent).Methods("GET")
-	api.HandleFunc("/content", a.saveContent).Methods("POST")
-	api.HandleFunc("/upload", a.uploadFile).Methods("POST")
-	api.HandleFunc("/upload/{filename}", a.deleteFile).Methods("DELETE")
-	api.HandleFunc("/migrate-base64", a.migrateBase64ToFiles).Methods("POST")
+	api.Use(corsPolicy(origins.Site, origins.MaxAge))
+	allowPreflight(api)
+	apiPublic := api.NewRoute().Subrouter()
+	apiPublic.Use(a.Tenants.Middleware)
+	apiPublic.HandleFunc("/content", a.getContent).Methods("GET")
+
+	apiUploads := api.NewRoute().Subrouter()
+	apiUploads.Use(a.Auth.Middleware, a.Tenants.Middleware)
+	apiUploads.Handle("/upload", auth.Require("site:uploads:write", a.uploadFile)).Methods("POST")
+	apiUploads.Handle("/upload/{filename}", auth.Require("site:uploads:write", a.deleteFile)).Methods("DELETE")
+
+	apiAdmin := api.NewRoute().Subrouter()
+	apiAdmin.Use(bodyLimit, a.Auth.Middleware, a.Tenants.Middleware)
+	apiAdmin.Handle("/content", auth.Require("site:content:write", a.saveContent)).Methods("POST")
+	apiAdmin.Handle("/migrate-base64", auth.Require("site:content:write", a.migrateBase64ToFiles)).Methods("POST")
 }
 
 func (a *App) Run(addr string) {
 	fmt.Printf("🚀 ISY API Server starting on %s\n", addr)
 
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: true,
-	})
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           observability.Handler(a),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       a.Config.Server.ReadTimeout,
+		WriteTimeout:      a.Config.Server.WriteTimeout,
+		IdleTimeout:       a.Config.Server.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		a.Close()
+		log.Fatalf("Server failed: %v", err)
+	case <-ctx.Done():
+	}
+
+	// Stop accepting connections and let in-flight requests (uploads, kiosk
+	// orders) finish before the database goes away
+	log.Println("Shutting down, draining connections...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.Config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: Connections did not drain in time: %v", err)
+	}
 
-	handler := c.Handler(a.Router)
-	log.Fatal(http.ListenAndServe(addr, handler))
+	a.Close()
+	log.Println("Server stopped")
 }
 
 func main() {
-	a := App{}
-	a.Initialize()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
 	}
 
-	a.Run(":" + port)
-}
\ No newline at end of file
+	a := App{}
+	a.Initialize(cfg)
+	a.Run(":" + cfg.Server.Port)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"isy-api/config"
+	"isy-api/migrations"
+	"isy-api/tenant"
+)
+
+// migrationTimeout bounds one migration run, at boot or from the command line
+const migrationTimeout = 10 * time.Minute
+
+const migrateUsage = `usage: %s migrate <command>
+
+commands:
+  up [version]   apply pending migrations, up to version if given
+  down [steps]   revert the last steps migrations of each database (default 1)
+  status         list applied and pending migrations per database`
+
+// runMigrate runs the migrate subcommand and returns the exit code
+func runMigrate(cfg *config.Config, args []string) int {
+	usage := fmt.Sprintf(migrateUsage, filepath.Base(os.Args[0]))
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	number := 0
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "invalid number %q\n\n%s\n", args[1], usage)
+			return 2
+		}
+		number = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI).SetServerSelectionTimeout(10*time.Second))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect to MongoDB: %v\n", err)
+		return 1
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(cfg.Mongo.Database)
+	runner := migrations.NewRunner(db, tenant.NewRegistry(client, db, cfg.Tenants))
+
+	switch args[0] {
+	case "up":
+		err = runner.Up(ctx, number)
+	case "down":
+		err = runner.Down(ctx, max(number, 1))
+	case "status":
+		if number != 0 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		err = printMigrationStatus(ctx, runner)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	if errors.Is(err, migrations.ErrLocked) {
+		fmt.Fprintln(os.Stderr, "Another process is applying migrations; try again once it is done")
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(ctx context.Context, runner *migrations.Runner) error {
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		fmt.Println(status.Database)
+		for _, record := range status.Applied {
+			fmt.Printf("  applied  %4d %-24s %s\n", record.Version, record.Name, record.AppliedAt.Format(time.RFC3339))
+		}
+		pending := make([]string, len(status.Pending))
+		for i, version := range status.Pending {
+			pending[i] = strconv.Itoa(version)
+		}
+		if len(pending) > 0 {
+			fmt.Printf("  pending  %s\n", strings.Join(pending, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// index is an index of one collection
+type index struct {
+	collection string
+	model      mongo.IndexModel
+}
+
+// createIndexes migrates by creating indexes. Creating an index that
+// already exists with the same keys and options does nothing, so databases
+// that had them created at startup before migrations existed pass through.
+func createIndexes(indexes ...index) (up, down func(ctx context.Context, db *mongo.Database) error) {
+	up = func(ctx context.Context, db *mongo.Database) error {
+		for _, ix := range indexes {
+			if _, err := db.Collection(ix.collection).Indexes().CreateOne(ctx, ix.model); err != nil {
+				return fmt.Errorf("create index %v on %s: %w", ix.model.Keys, ix.collection, err)
+			}
+		}
+		return nil
+	}
+	down = func(ctx context.Context, db *mongo.Database) error {
+		for _, ix := range indexes {
+			_, err := db.Collection(ix.collection).Indexes().DropOneWithKey(ctx, ix.model.Keys)
+			if err != nil && !isMissing(err) {
+				return fmt.Errorf("drop index %v on %s: %w", ix.model.Keys, ix.collection, err)
+			}
+		}
+		return nil
+	}
+	return up, down
+}
+
+// isMissing reports the error of dropping an index or collection that does
+// not exist
+func isMissing(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == codeNamespaceNotFound || cmdErr.Code == codeIndexNotFound)
+}
+
+// Server error codes
+const (
+	codeNamespaceNotFound = 26
+	codeIndexNotFound     = 27
+)
@@ -0,0 +1,322 @@
+// Package migrations applies versioned schema changes to the shared database
+// and to every tenant database.
+//
+// A migration is a Go function pair: Up makes the change and Down reverts it.
+// Each database records the versions applied to it in its migrations
+// collection, so a database provisioned later catches up on its own and a
+// failed run resumes where it stopped. Migrations run at boot and through
+// the "migrate" subcommand of the API binary; a lock in the shared database
+// keeps two instances from running them at the same time.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"isy-api/tenant"
+)
+
+// Collection records the migrations applied to a database
+const Collection = "migrations"
+
+// ErrLocked is returned when another process is running migrations
+var ErrLocked = errors.New("migrations are locked by another process")
+
+// Scope says which databases a migration applies to
+type Scope int
+
+const (
+	// Shared migrations change the shared database only: admins, sessions,
+	// tenants and other platform collections
+	Shared Scope = iota
+	// Tenant migrations change business data. They run on every tenant
+	// database and on the shared one, which serves requests without a tenant.
+	Tenant
+)
+
+// Migration is one versioned change
+type Migration struct {
+	// Version orders migrations; it is never reused
+	Version int
+	Name    string
+	Scope   Scope
+	Up      func(ctx context.Context, db *mongo.Database) error
+	// Down reverts Up; nil when the change cannot be reverted
+	Down func(ctx context.Context, db *mongo.Database) error
+}
+
+// Record is an entry of the migrations collection
+type Record struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"appliedAt" json:"appliedAt"`
+}
+
+// Status lists what has been applied to one database and what is pending
+type Status struct {
+	Database string   `json:"database"`
+	Applied  []Record `json:"applied"`
+	Pending  []int    `json:"pending"`
+}
+
+// Runner applies migrations to the shared database and the tenant databases
+// of its registry
+type Runner struct {
+	DB *mongo.Database
+	// Tenants lists the tenant databases; nil migrates the shared database
+	// only
+	Tenants    *tenant.Registry
+	Migrations []Migration
+	// LockTTL bounds how long a crashed run keeps other processes out
+	LockTTL time.Duration
+}
+
+// NewRunner creates a runner for every registered migration
+func NewRunner(db *mongo.Database, tenants *tenant.Registry) *Runner {
+	return &Runner{DB: db, Tenants: tenants, Migrations: All, LockTTL: 15 * time.Minute}
+}
+
+// target is a database and the scopes that apply to it
+type target struct {
+	db     *mongo.Database
+	scopes []Scope
+}
+
+func (t target) migrations(all []Migration) []Migration {
+	var list []Migration
+	for _, m := range all {
+		if slices.Contains(t.scopes, m.Scope) {
+			list = append(list, m)
+		}
+	}
+	slices.SortFunc(list, func(a, b Migration) int { return a.Version - b.Version })
+	return list
+}
+
+func (r *Runner) targets(ctx context.Context) ([]target, error) {
+	if r.DB == nil {
+		return nil, tenant.ErrUnavailable
+	}
+
+	targets := []target{{db: r.DB, scopes: []Scope{Shared, Tenant}}}
+	if r.Tenants == nil {
+		return targets, nil
+	}
+	tenants, err := r.Tenants.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list tenants: %w", err)
+	}
+	for i := range tenants {
+		targets = append(targets, target{db: r.Tenants.Database(&tenants[i]), scopes: []Scope{Tenant}})
+	}
+	return targets, nil
+}
+
+// Up applies the pending migrations up to version, or all of them when
+// version is 0. A database whose migration fails is left at the last version
+// that succeeded; the other databases are still migrated and every failure
+// is reported.
+func (r *Runner) Up(ctx context.Context, version int) error {
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	targets, err := r.targets(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, t := range targets {
+		if err := r.up(ctx, t, version); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.db.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MigrateTenant brings a newly provisioned tenant database up to date. It
+// does not take the lock: no other run knows the database yet, and the
+// tenant migrations are safe to repeat.
+func (r *Runner) MigrateTenant(ctx context.Context, db *mongo.Database) error {
+	return r.up(ctx, target{db: db, scopes: []Scope{Tenant}}, 0)
+}
+
+func (r *Runner) up(ctx context.Context, t target, version int) error {
+	applied, err := appliedVersions(ctx, t.db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range t.migrations(r.Migrations) {
+		if version > 0 && m.Version > version {
+			break
+		}
+		if applied[m.Version] {
+			continue
+		}
+
+		started := time.Now()
+		if err := m.Up(ctx, t.db); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		record := Record{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+		if _, err := t.db.Collection(Collection).InsertOne(ctx, record); err != nil {
+			return fmt.Errorf("record migration %d: %w", m.Version, err)
+		}
+		log.Printf("Applied migration %d %s to %s in %s", m.Version, m.Name, t.db.Name(), time.Since(started).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// Down reverts the last steps migrations applied to each database, newest
+// first
+func (r *Runner) Down(ctx context.Context, steps int) error {
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	targets, err := r.targets(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, t := range targets {
+		if err := r.down(ctx, t, steps); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.db.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Runner) down(ctx context.Context, t target, steps int) error {
+	applied, err := appliedVersions(ctx, t.db)
+	if err != nil {
+		return err
+	}
+
+	list := t.migrations(r.Migrations)
+	slices.Reverse(list)
+	for _, m := range list {
+		if steps == 0 {
+			break
+		}
+		if !applied[m.Version] {
+			continue
+		}
+		if m.Down == nil {
+			return fmt.Errorf("migration %d %s cannot be reverted", m.Version, m.Name)
+		}
+
+		if err := m.Down(ctx, t.db); err != nil {
+			return fmt.Errorf("revert migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if _, err := t.db.Collection(Collection).DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return fmt.Errorf("unrecord migration %d: %w", m.Version, err)
+		}
+		log.Printf("Reverted migration %d %s on %s", m.Version, m.Name, t.db.Name())
+		steps--
+	}
+	return nil
+}
+
+// Status reports the applied and pending migrations of every database
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	targets, err := r.targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, t := range targets {
+		status := Status{Database: t.db.Name(), Applied: []Record{}, Pending: []int{}}
+		cursor, err := t.db.Collection(Collection).Find(ctx, bson.M{},
+			options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+		if err != nil {
+			return nil, err
+		}
+		if err := cursor.All(ctx, &status.Applied); err != nil {
+			return nil, err
+		}
+
+		applied := map[int]bool{}
+		for _, record := range status.Applied {
+			applied[record.Version] = true
+		}
+		for _, m := range t.migrations(r.Migrations) {
+			if !applied[m.Version] {
+				status.Pending = append(status.Pending, m.Version)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func appliedVersions(ctx context.Context, db *mongo.Database) (map[int]bool, error) {
+	cursor, err := db.Collection(Collection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	return applied, nil
+}
+
+// lockID names the lock document in the migration_locks collection
+const lockID = "migrations"
+
+// lock takes the migration lock in the shared database. A lock whose holder
+// died is taken over once it expires.
+func (r *Runner) lock(ctx context.Context) (unlock func(), err error) {
+	if r.DB == nil {
+		return nil, tenant.ErrUnavailable
+	}
+
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d:%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+	now := time.Now()
+	locks := r.DB.Collection("migration_locks")
+
+	// The filter only matches an expired lock; while the lock is held the
+	// upsert collides with it on _id
+	_, err = locks.UpdateOne(ctx,
+		bson.M{"_id": lockID, "expiresAt": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": owner, "lockedAt": now, "expiresAt": now.Add(r.LockTTL)}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		// The run's context may be done by now
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := locks.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner}); err != nil {
+			log.Printf("Warning: Failed to release migration lock: %v", err)
+		}
+	}, nil
+}
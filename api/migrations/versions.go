@@ -0,0 +1,406 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"isy-api/auth"
+	"isy-api/search"
+)
+
+// All lists every migration. Append new ones with the next version; never
+// edit or renumber a migration that has shipped.
+var All = []Migration{
+	sharedIndexes(),
+	businessIndexes(),
+	searchIndexes(),
+	orderIndexes(),
+	pointsLedger(),
+	pendingPoints(),
+	stockMovements(),
+	orderSettlement(),
+	awardCredit(),
+	defaultPasswords(),
+}
+
+// sharedIndexes backs authentication and tenant lookups. The session, login
+// attempt, API key and tenant indexes were created at every startup before
+// migrations existed.
+func sharedIndexes() Migration {
+	up, down := createIndexes(
+		index{"admins", mongo.IndexModel{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		index{"roles", mongo.IndexModel{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		// Expired refresh tokens and lockout counters are purged by MongoDB
+		index{"sessions", mongo.IndexModel{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+		index{"sessions", mongo.IndexModel{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		index{"sessions", mongo.IndexModel{Keys: bson.D{{Key: "familyId", Value: 1}}}},
+		index{"sessions", mongo.IndexModel{Keys: bson.D{{Key: "adminId", Value: 1}}}},
+		index{"login_attempts", mongo.IndexModel{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+		index{"api_keys", mongo.IndexModel{
+			Keys:    bson.D{{Key: "prefix", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		// Tenants reached only by header or token have no hosts
+		index{"tenants", mongo.IndexModel{
+			Keys:    bson.D{{Key: "hosts", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		}},
+		index{"tenants", mongo.IndexModel{
+			Keys:    bson.D{{Key: "database", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		index{"audit_logs", mongo.IndexModel{Keys: bson.D{{Key: "createdAt", Value: -1}}}},
+	)
+	return Migration{Version: 1, Name: "shared_indexes", Scope: Shared, Up: up, Down: down}
+}
+
+// businessIndexes backs the lookups of kiosk, retail and healthcare data
+func businessIndexes() Migration {
+	up, down := createIndexes(
+		// Walk-in customers have an empty member ID; $gt "" only matches
+		// non-empty strings
+		index{"customers", mongo.IndexModel{
+			Keys: bson.D{{Key: "memberId", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"memberId": bson.M{"$gt": ""}}),
+		}},
+		index{"customers", mongo.IndexModel{Keys: bson.D{{Key: "customerId", Value: 1}}}},
+		index{"products", mongo.IndexModel{Keys: bson.D{{Key: "productId", Value: 1}}}},
+		index{"products", mongo.IndexModel{Keys: bson.D{{Key: "categoryId", Value: 1}}}},
+		index{"patients", mongo.IndexModel{Keys: bson.D{{Key: "patientId", Value: 1}}}},
+		index{"appointments", mongo.IndexModel{Keys: bson.D{{Key: "patientId", Value: 1}}}},
+		// A patient's records are listed newest first
+		index{"medicalrecords", mongo.IndexModel{Keys: bson.D{{Key: "patientId", Value: 1}, {Key: "createdAt", Value: -1}}}},
+	)
+	return Migration{Version: 2, Name: "business_indexes", Scope: Tenant, Up: up, Down: down}
+}
+
+// searchIndexes creates the search indexes and computes the trigrams of
+// documents written before search existed
+func searchIndexes() Migration {
+	return Migration{
+		Version: 3,
+		Name:    "search",
+		Scope:   Tenant,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, ix := range search.Indexes {
+				if err := ix.EnsureIndexes(ctx, db); err != nil {
+					return err
+				}
+				if _, err := ix.Reindex(ctx, db); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for _, ix := range search.Indexes {
+				if err := ix.DropIndexes(ctx, db); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// orderIndexes backs the kiosk order API. Transaction IDs come from a
+// counter, so a duplicate means two writers numbered the same order.
+func orderIndexes() Migration {
+	up, down := createIndexes(
+		index{"orders", mongo.IndexModel{
+			Keys:    bson.D{{Key: "transactionId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		index{"orders", mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}}}},
+		index{"orders", mongo.IndexModel{Keys: bson.D{{Key: "customerId", Value: 1}, {Key: "createdAt", Value: -1}}}},
+	)
+	return Migration{Version: 4, Name: "order_indexes", Scope: Tenant, Up: up, Down: down}
+}
+
+// pointsLedger creates the loyalty ledger and opens it for every customer
+// with the balance the kiosk kept on the customer document: the "added"
+// minus the "minus" entries of points, plus customPoints.
+func pointsLedger() Migration {
+	up, down := createIndexes(
+		// Unique so two writers cannot append the same entry of a customer
+		index{"points_ledger", mongo.IndexModel{
+			Keys:    bson.D{{Key: "customerId", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		index{"points_ledger", mongo.IndexModel{Keys: bson.D{{Key: "customerId", Value: 1}, {Key: "createdAt", Value: -1}}}},
+	)
+	return Migration{
+		Version: 5,
+		Name:    "points_ledger",
+		Scope:   Tenant,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := up(ctx, db); err != nil {
+				return err
+			}
+			return openPointsLedger(ctx, db)
+		},
+		// Entries posted since stay; the snapshots are rebuilt from them
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if _, err := db.Collection("points_ledger").DeleteMany(ctx, bson.M{"type": "opening"}); err != nil {
+				return err
+			}
+			if err := db.Collection("points_balances").Drop(ctx); err != nil {
+				return err
+			}
+			return down(ctx, db)
+		},
+	}
+}
+
+func openPointsLedger(ctx context.Context, db *mongo.Database) error {
+	cursor, err := db.Collection("customers").Find(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"points.0": bson.M{"$exists": true}},
+			bson.M{"customPoints": bson.M{"$ne": 0, "$exists": true}},
+		}},
+		options.Find().SetProjection(bson.M{"points": 1, "customPoints": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now()
+	for cursor.Next(ctx) {
+		var customer struct {
+			ID     primitive.ObjectID `bson:"_id"`
+			Points []struct {
+				Amount float64 `bson:"amount"`
+				Type   string  `bson:"type"`
+			} `bson:"points"`
+			CustomPoints float64 `bson:"customPoints"`
+		}
+		if err := cursor.Decode(&customer); err != nil {
+			return err
+		}
+
+		balance := customer.CustomPoints
+		for _, entry := range customer.Points {
+			switch entry.Type {
+			case "added":
+				balance += entry.Amount
+			case "minus":
+				balance -= entry.Amount
+			}
+		}
+		if balance == 0 {
+			continue
+		}
+
+		// A customer already in the ledger has an entry 1; leave it alone
+		_, err := db.Collection("points_ledger").InsertOne(ctx, bson.M{
+			"_id":        primitive.NewObjectID(),
+			"customerId": customer.ID.Hex(),
+			"seq":        int64(1),
+			"type":       "opening",
+			"points":     balance,
+			"reason":     "Balance before the points ledger",
+			"createdAt":  now,
+		})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("open points ledger of %s: %w", customer.ID.Hex(), err)
+		}
+	}
+	return cursor.Err()
+}
+
+// pendingPoints backs the points approval queue. An order gets at most one
+// award, whatever became of it.
+func pendingPoints() Migration {
+	up, down := createIndexes(
+		index{"pending_points", mongo.IndexModel{
+			Keys:    bson.D{{Key: "orderId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		index{"pending_points", mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}}}},
+		index{"pending_points", mongo.IndexModel{Keys: bson.D{{Key: "customerId", Value: 1}, {Key: "createdAt", Value: -1}}}},
+	)
+	return Migration{Version: 6, Name: "pending_points", Scope: Tenant, Up: up, Down: down}
+}
+
+// stockMovements creates the stock ledger and opens it with the stock the
+// kiosk kept on each product variant
+func stockMovements() Migration {
+	up, down := createIndexes(
+		// Levels are grouped per product and variant, newest name last
+		index{"stock_movements", mongo.IndexModel{Keys: bson.D{{Key: "productId", Value: 1}, {Key: "variantId", Value: 1}, {Key: "createdAt", Value: 1}}}},
+		index{"stock_movements", mongo.IndexModel{Keys: bson.D{{Key: "createdAt", Value: -1}}}},
+		index{"stock_movements", mongo.IndexModel{
+			Keys:    bson.D{{Key: "purchaseOrderId", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		}},
+		// An order's sale of a product or variant is recorded once
+		index{"stock_movements", mongo.IndexModel{
+			Keys: bson.D{{Key: "orderId", Value: 1}, {Key: "productId", Value: 1}, {Key: "variantId", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"type": "sale"}),
+		}},
+	)
+	return Migration{
+		Version: 7,
+		Name:    "stock_movements",
+		Scope:   Tenant,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := up(ctx, db); err != nil {
+				return err
+			}
+			return openStockLedger(ctx, db)
+		},
+		// Movements recorded since stay
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if _, err := db.Collection("stock_movements").DeleteMany(ctx, bson.M{"type": "opening"}); err != nil {
+				return err
+			}
+			return down(ctx, db)
+		},
+	}
+}
+
+func openStockLedger(ctx context.Context, db *mongo.Database) error {
+	cursor, err := db.Collection("products").Find(ctx,
+		bson.M{"variants.stock": bson.M{"$ne": 0}},
+		options.Find().SetProjection(bson.M{"name": 1, "variants": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now()
+	for cursor.Next(ctx) {
+		var product struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Name     string             `bson:"name"`
+			Variants []struct {
+				ID    string `bson:"id"`
+				Name  string `bson:"name"`
+				Stock int    `bson:"stock"`
+			} `bson:"variants"`
+		}
+		if err := cursor.Decode(&product); err != nil {
+			return err
+		}
+
+		for _, variant := range product.Variants {
+			if variant.Stock == 0 {
+				continue
+			}
+			// A variant already in the ledger was counted there; leave it alone
+			recorded, err := db.Collection("stock_movements").CountDocuments(ctx,
+				bson.M{"productId": product.ID.Hex(), "variantId": variant.ID},
+				options.Count().SetLimit(1),
+			)
+			if err != nil {
+				return err
+			}
+			if recorded > 0 {
+				continue
+			}
+			_, err = db.Collection("stock_movements").InsertOne(ctx, bson.M{
+				"_id":         primitive.NewObjectID(),
+				"productId":   product.ID.Hex(),
+				"productName": product.Name,
+				"variantId":   variant.ID,
+				"variantName": variant.Name,
+				"type":        "opening",
+				"quantity":    variant.Stock,
+				"notes":       "Stock before the movement ledger",
+				"createdAt":   now,
+			})
+			if err != nil {
+				return fmt.Errorf("open stock ledger of %s: %w", product.ID.Hex(), err)
+			}
+		}
+	}
+	return cursor.Err()
+}
+
+// orderSettlement finds the orders whose points or stock are still to be
+// settled. Only those carry the mark, so the index stays small.
+func orderSettlement() Migration {
+	up, down := createIndexes(
+		index{"orders", mongo.IndexModel{
+			Keys:    bson.D{{Key: "settlementPending", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"settlementPending": true}),
+		}},
+	)
+	return Migration{Version: 8, Name: "order_settlement", Scope: Tenant, Up: up, Down: down}
+}
+
+// awardCredit finds the approved awards whose points are still to be
+// credited. Only those carry the mark, so the index stays small.
+func awardCredit() Migration {
+	up, down := createIndexes(
+		index{"pending_points", mongo.IndexModel{
+			Keys:    bson.D{{Key: "creditPending", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"creditPending": true}),
+		}},
+	)
+	return Migration{Version: 9, Name: "award_credit", Scope: Tenant, Up: up, Down: down}
+}
+
+// defaultPasswords makes admins still signing in with a common password
+// change it at their next login. Accounts seeded before the kiosk migration
+// tool set mustChangePassword kept admin123 or changeme123 without ever
+// being asked to replace it.
+func defaultPasswords() Migration {
+	return Migration{
+		Version: 10,
+		Name:    "default_passwords",
+		Scope:   Shared,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			admins := db.Collection("admins")
+			cursor, err := admins.Find(ctx, bson.M{"mustChangePassword": bson.M{"$ne": true}},
+				options.Find().SetProjection(bson.M{"password": 1}))
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			for cursor.Next(ctx) {
+				var admin struct {
+					ID       primitive.ObjectID `bson:"_id"`
+					Password string             `bson:"password"`
+				}
+				if err := cursor.Decode(&admin); err != nil {
+					return err
+				}
+				if !auth.CommonPassword(admin.Password) {
+					continue
+				}
+				_, err := admins.UpdateOne(ctx, bson.M{"_id": admin.ID}, bson.M{"$set": bson.M{"mustChangePassword": true}})
+				if err != nil {
+					return fmt.Errorf("flag admin %s: %w", admin.ID.Hex(), err)
+				}
+			}
+			return cursor.Err()
+		},
+	}
+}
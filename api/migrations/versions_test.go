@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+
+	"isy-api/mongodb/mongotest"
+)
+
+func TestDefaultPasswords(t *testing.T) {
+	ctx := context.Background()
+	db := mongotest.Database(t)
+	admins := map[string]string{"admin": "admin123", "imported": "changeme123", "chosen": "Lantern-Orbit-4711"}
+	for username, password := range admins {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Collection("admins").InsertOne(ctx, bson.M{"username": username, "password": string(hash)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := defaultPasswords().Up(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	for username, want := range map[string]bool{"admin": true, "imported": true, "chosen": false} {
+		var admin struct {
+			MustChangePassword bool `bson:"mustChangePassword"`
+		}
+		if err := db.Collection("admins").FindOne(ctx, bson.M{"username": username}).Decode(&admin); err != nil {
+			t.Fatal(err)
+		}
+		if admin.MustChangePassword != want {
+			t.Errorf("%s must change password = %v, want %v", username, admin.MustChangePassword, want)
+		}
+	}
+}
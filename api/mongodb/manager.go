@@ -0,0 +1,232 @@
+package mongodb
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// State describes the connection as reported on /health
+type State string
+
+const (
+	// StateConnecting means no connection has been established yet
+	StateConnecting State = "connecting"
+	// StateConnected means the last ping succeeded
+	StateConnected State = "connected"
+	// StateDisconnected means the server stopped answering after a
+	// successful connect; the driver reconnects on its own
+	StateDisconnected State = "disconnected"
+	// StateClosed means Disconnect was called
+	StateClosed State = "closed"
+)
+
+// Status is a snapshot of the connection state
+type Status struct {
+	State       State      `json:"state"`
+	Database    string     `json:"database"`
+	Attempts    int        `json:"attempts,omitempty"`
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+}
+
+// Manager establishes the MongoDB connection in the background. Until the
+// server is reachable it retries with exponential backoff; once connected it
+// calls OnConnect exactly once and then keeps pinging to report the state.
+type Manager struct {
+	URI  string
+	Name string
+
+	// ConnectTimeout bounds a single connect and ping attempt
+	ConnectTimeout time.Duration
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	PingInterval   time.Duration
+
+	// Monitor, if set, observes every command sent on the client
+	Monitor *event.CommandMonitor
+
+	// OnConnect receives the live client and database
+	OnConnect func(client *mongo.Client, db *mongo.Database)
+
+	mu          sync.RWMutex
+	client      *mongo.Client
+	db          *mongo.Database
+	state       State
+	attempts    int
+	connectedAt time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewManager creates a manager for database name on the server at uri
+func NewManager(uri, name string) *Manager {
+	return &Manager{
+		URI:            uri,
+		Name:           name,
+		ConnectTimeout: 5 * time.Second,
+		MinBackoff:     time.Second,
+		MaxBackoff:     30 * time.Second,
+		PingInterval:   10 * time.Second,
+		state:          StateConnecting,
+	}
+}
+
+// Start makes the first connection attempt synchronously, so a reachable
+// server is in use before the first request, and continues in the background.
+func (m *Manager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	connected := m.attempt(ctx)
+	go m.run(ctx, connected)
+}
+
+// Database returns the live database, or nil while not yet connected
+func (m *Manager) Database() *mongo.Database {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.db
+}
+
+// Client returns the live client, or nil while not yet connected
+func (m *Manager) Client() *mongo.Client {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.client
+}
+
+// Status returns the current connection state
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := Status{State: m.state, Database: m.Name}
+	if m.state == StateConnecting {
+		status.Attempts = m.attempts
+	}
+	if !m.connectedAt.IsZero() {
+		connectedAt := m.connectedAt
+		status.ConnectedAt = &connectedAt
+	}
+	return status
+}
+
+// Disconnect stops reconnecting and closes the client
+func (m *Manager) Disconnect(ctx context.Context) error {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
+	}
+
+	m.mu.Lock()
+	client := m.client
+	m.state = StateClosed
+	m.mu.Unlock()
+
+	if client == nil {
+		return nil
+	}
+	return client.Disconnect(ctx)
+}
+
+func (m *Manager) run(ctx context.Context, connected bool) {
+	defer close(m.done)
+
+	backoff := m.MinBackoff
+	for !connected {
+		log.Printf("MongoDB not reachable, retrying in %s", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if connected = m.attempt(ctx); ctx.Err() != nil {
+			return
+		}
+		if backoff *= 2; backoff > m.MaxBackoff {
+			backoff = m.MaxBackoff
+		}
+	}
+
+	ticker := time.NewTicker(m.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.ping(ctx)
+		}
+	}
+}
+
+// attempt connects and pings once. On success it publishes the connection
+// and calls OnConnect.
+func (m *Manager) attempt(ctx context.Context) bool {
+	attemptCtx, cancel := context.WithTimeout(ctx, m.ConnectTimeout)
+	defer cancel()
+
+	m.mu.Lock()
+	m.attempts++
+	m.mu.Unlock()
+
+	opts := options.Client().ApplyURI(m.URI)
+	if m.Monitor != nil {
+		opts.SetMonitor(m.Monitor)
+	}
+	client, err := mongo.Connect(attemptCtx, opts)
+	if err == nil {
+		if err = client.Ping(attemptCtx, nil); err != nil {
+			client.Disconnect(context.Background())
+		}
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Warning: Failed to connect to MongoDB: %v", err)
+		}
+		return false
+	}
+
+	db := client.Database(m.Name)
+	m.mu.Lock()
+	m.client, m.db = client, db
+	m.state = StateConnected
+	m.connectedAt = time.Now()
+	m.mu.Unlock()
+
+	log.Printf("✅ Connected to MongoDB (database %s)", m.Name)
+	if m.OnConnect != nil {
+		m.OnConnect(client, db)
+	}
+	return true
+}
+
+// ping refreshes the state of an established connection
+func (m *Manager) ping(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, m.ConnectTimeout)
+	defer cancel()
+
+	err := m.Client().Ping(pingCtx, nil)
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case err != nil && m.state == StateConnected:
+		log.Printf("Warning: Lost connection to MongoDB: %v", err)
+		m.state = StateDisconnected
+	case err == nil && m.state == StateDisconnected:
+		log.Println("✅ Reconnected to MongoDB")
+		m.state = StateConnected
+	}
+}
@@ -0,0 +1,50 @@
+// Package mongotest gives tests a throwaway MongoDB database. Tests that
+// need one are skipped unless TEST_MONGO_URI points at a server, e.g.
+//
+//	TEST_MONGO_URI=mongodb://localhost:27017 go test ./...
+package mongotest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// URIVariable names the environment variable holding the test server
+const URIVariable = "TEST_MONGO_URI"
+
+// Database returns an empty database of its own for t, dropped when t ends
+func Database(t testing.TB) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv(URIVariable)
+	if uri == "" {
+		t.Skip(URIVariable + " is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to %s: %v", uri, err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("pinging %s: %v", uri, err)
+	}
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	db := client.Database("isy_test_" + hex.EncodeToString(suffix))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return db
+}
@@ -0,0 +1,91 @@
+package observability
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "isy_http_requests_total",
+		Help: "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "isy_http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "isy_mongo_command_duration_seconds",
+		Help:    "MongoDB command latency by command name and outcome.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"command", "outcome"})
+
+	uploadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "isy_upload_bytes_total",
+		Help: "Bytes written to the uploads directory by source.",
+	}, []string{"source"})
+
+	uploadFiles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "isy_upload_files_total",
+		Help: "Files written to the uploads directory by source.",
+	}, []string{"source"})
+)
+
+func observeRequest(method, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ObserveUpload counts a file of size bytes written to the uploads
+// directory, e.g. source "upload" or "migration"
+func ObserveUpload(source string, size int64) {
+	uploadBytes.WithLabelValues(source).Add(float64(size))
+	uploadFiles.WithLabelValues(source).Inc()
+}
+
+// MongoMonitor records the duration of every MongoDB command and traces it
+// as a child span of the request that issued it
+func MongoMonitor() *event.CommandMonitor {
+	tracing := otelmongo.NewMonitor()
+	return &event.CommandMonitor{
+		Started: tracing.Started,
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			mongoDuration.WithLabelValues(e.CommandName, "success").Observe(e.Duration.Seconds())
+			tracing.Succeeded(ctx, e)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			mongoDuration.WithLabelValues(e.CommandName, "failure").Observe(e.Duration.Seconds())
+			tracing.Failed(ctx, e)
+		},
+	}
+}
+
+// MetricsHandler serves the Prometheus metrics. With a non-empty token the
+// scraper has to send it as a bearer token.
+func MetricsHandler(token string) http.Handler {
+	metrics := promhttp.Handler()
+	if token == "" {
+		return metrics
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
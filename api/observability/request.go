@@ -0,0 +1,170 @@
+package observability
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID. Incoming values are kept so IDs
+// assigned by nginx or a front-end can be followed across services.
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// accessLog writes one JSON line per request to stdout
+var accessLog = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// RequestInfo collects what the access log and metrics report about a
+// request. Inner middleware fills in the route, tenant and user as they
+// learn them.
+type RequestInfo struct {
+	ID string
+
+	mu      sync.Mutex
+	route   string
+	traceID string
+	tenant  string
+	user    string
+}
+
+type requestInfoKey struct{}
+
+// InfoFromContext returns the RequestInfo of the current request
+func InfoFromContext(ctx context.Context) (*RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info, ok
+}
+
+// RequestIDFromContext returns the ID of the current request
+func RequestIDFromContext(ctx context.Context) string {
+	if info, ok := InfoFromContext(ctx); ok {
+		return info.ID
+	}
+	return ""
+}
+
+// SetUser records the authenticated caller of the request in ctx
+func SetUser(ctx context.Context, user string) {
+	if info, ok := InfoFromContext(ctx); ok {
+		info.mu.Lock()
+		info.user = user
+		info.mu.Unlock()
+	}
+}
+
+// SetTenant records the tenant the request in ctx is bound to
+func SetTenant(ctx context.Context, tenant string) {
+	if info, ok := InfoFromContext(ctx); ok {
+		info.mu.Lock()
+		info.tenant = tenant
+		info.mu.Unlock()
+	}
+}
+
+// Handler assigns a request ID, then logs and measures every request that
+// reaches next, matched or not. Wrap it around the whole server.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		info := &RequestInfo{ID: id}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		duration := time.Since(start)
+		info.mu.Lock()
+		route, traceID, tenant, user := info.route, info.traceID, info.tenant, info.user
+		info.mu.Unlock()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		observeRequest(r.Method, route, recorder.status, duration)
+		accessLog.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("requestId", id),
+			slog.String("traceId", traceID),
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int64("bytes", recorder.bytes),
+			slog.Float64("durationMs", float64(duration.Microseconds())/1000),
+			slog.String("tenant", tenant),
+			slog.String("user", user),
+			slog.String("remoteAddr", r.RemoteAddr),
+		)
+	})
+}
+
+// RouteMiddleware records the matched route template and links the request
+// ID with the trace. Attach it to the mux router with Router.Use after
+// TraceMiddleware so it runs after route matching, inside the request span.
+func RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := InfoFromContext(r.Context()); ok {
+			info.mu.Lock()
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					info.route = template
+				}
+			}
+			if span := trace.SpanFromContext(r.Context()); span.SpanContext().IsValid() {
+				info.traceID = span.SpanContext().TraceID().String()
+				span.SetAttributes(attribute.String("http.request_id", info.ID))
+			}
+			info.mu.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder captures the status code and body size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,133 @@
+package response
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Code is a stable, machine-readable error identifier
+type Code string
+
+// Codes shared by every module. Packages define their own codes for
+// domain-specific errors, e.g. tenant_mismatch.
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeInvalidBody      Code = "invalid_body"
+	CodeInvalidID        Code = "invalid_id"
+	CodeValidationFailed Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeInvalidToken     Code = "invalid_token"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeRouteNotFound    Code = "route_not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeConflict         Code = "conflict"
+	CodePayloadTooLarge  Code = "payload_too_large"
+	CodeRateLimited      Code = "rate_limited"
+	CodeInternal         Code = "internal_error"
+	CodeUnavailable      Code = "database_unavailable"
+)
+
+// Error is an error response: the status, a stable code, a human-readable
+// message and optional details or per-field problems
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Details any
+	Fields  []FieldError
+	// Cause is logged for server errors and never sent to the client
+	Cause error
+}
+
+// FieldError describes a problem with one request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// New creates an error response
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// WithDetails returns a copy of e carrying details
+func (e *Error) WithDetails(details any) *Error {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// WithFields returns a copy of e carrying per-field problems
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	copied := *e
+	copied.Fields = append(append([]FieldError(nil), e.Fields...), fields...)
+	return &copied
+}
+
+// WithCause returns a copy of e recording the error that caused it
+func (e *Error) WithCause(cause error) *Error {
+	copied := *e
+	copied.Cause = cause
+	return &copied
+}
+
+// Errors most handlers need
+var (
+	ErrInvalidBody     = New(http.StatusBadRequest, CodeInvalidBody, "Invalid request payload")
+	ErrPayloadTooLarge = New(http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Request body too large")
+	ErrUnavailable     = New(http.StatusServiceUnavailable, CodeUnavailable, "Database not available")
+)
+
+// BodyError is the error for a request body that could not be read or
+// decoded: ErrPayloadTooLarge when it ran past its size limit, otherwise
+// ErrInvalidBody
+func BodyError(err error) *Error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrPayloadTooLarge
+	}
+	return ErrInvalidBody
+}
+
+// Internal is a server error with message, logging cause
+func Internal(message string, cause error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Cause: cause}
+}
+
+// CodeForStatus is the code Fail uses for status
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
@@ -0,0 +1,177 @@
+// Package response writes API responses in one shape for every module. Errors
+// carry a stable Code that front-ends can switch on; the message is for
+// humans and may change.
+//
+// Responses use the JSON envelope
+//
+//	{"success": false, "error": "...", "code": "...", "requestId": "..."}
+//
+// unless the client asks for RFC 7807 problem details with
+// "Accept: application/problem+json".
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"isy-api/observability"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// ProblemTypeBase prefixes the code to form the problem type URI
+const ProblemTypeBase = "urn:isy:problem:"
+
+// Envelope is the JSON body of every response that is not a problem document
+type Envelope struct {
+	Success   bool         `json:"success"`
+	Data      any          `json:"data,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Code      Code         `json:"code,omitempty"`
+	Details   any          `json:"details,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+	Page      *Page        `json:"page,omitempty"`
+	Links     *Links       `json:"links,omitempty"`
+}
+
+// Page describes the list in a paginated response
+type Page struct {
+	// Total counts every matching item, not only this page
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Links point at related pages
+type Links struct {
+	Next string `json:"next,omitempty"`
+}
+
+// Problem is an RFC 7807 problem document. Code, details, fields and the
+// request ID are extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	Details   any          `json:"details,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+}
+
+// JSON writes data in a success envelope
+func JSON(w http.ResponseWriter, r *http.Request, status int, data any) {
+	write(w, status, "application/json", Envelope{Success: true, Data: data})
+}
+
+// List writes one page of a list. The next link repeats the request with
+// the next cursor, so filters and sorting carry over.
+func List(w http.ResponseWriter, r *http.Request, items any, page Page) {
+	envelope := Envelope{Success: true, Data: items, Page: &page}
+	if page.NextCursor != "" {
+		query := r.URL.Query()
+		query.Set("cursor", page.NextCursor)
+		next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		envelope.Links = &Links{Next: next.String()}
+	}
+	write(w, http.StatusOK, "application/json", envelope)
+}
+
+// Fail writes an error with the default code for status
+func Fail(w http.ResponseWriter, r *http.Request, status int, message string) {
+	WriteError(w, r, New(status, CodeForStatus(status), message))
+}
+
+// WriteError writes err as an envelope or problem document. Errors that are
+// not an *Error are logged and reported as an internal error, so their text
+// never reaches the client.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		log.Printf("Unhandled error on %s %s: %v", r.Method, r.URL.Path, err)
+		apiErr = New(http.StatusInternalServerError, CodeInternal, "Internal server error")
+	} else if apiErr.Cause != nil && apiErr.Status >= http.StatusInternalServerError {
+		log.Printf("%s on %s %s: %v", apiErr.Message, r.Method, r.URL.Path, apiErr.Cause)
+	}
+
+	requestID := observability.RequestIDFromContext(r.Context())
+	if WantsProblem(r) {
+		write(w, apiErr.Status, ProblemContentType, Problem{
+			Type:      ProblemTypeBase + string(apiErr.Code),
+			Title:     http.StatusText(apiErr.Status),
+			Status:    apiErr.Status,
+			Detail:    apiErr.Message,
+			Instance:  r.URL.Path,
+			Code:      apiErr.Code,
+			Details:   apiErr.Details,
+			Fields:    apiErr.Fields,
+			RequestID: requestID,
+		})
+		return
+	}
+
+	write(w, apiErr.Status, "application/json", Envelope{
+		Success:   false,
+		Error:     apiErr.Message,
+		Code:      apiErr.Code,
+		Details:   apiErr.Details,
+		Fields:    apiErr.Fields,
+		RequestID: requestID,
+	})
+}
+
+// WantsProblem reports whether the Accept header prefers problem details
+// over plain JSON
+func WantsProblem(r *http.Request) bool {
+	var problem, plain float64
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case ProblemContentType:
+			problem = max(problem, q)
+		case "application/json":
+			plain = max(plain, q)
+		}
+	}
+	return problem > 0 && problem >= plain
+}
+
+// NotFoundHandler answers requests that match no route
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, New(http.StatusNotFound, CodeRouteNotFound, "Route not found"))
+	})
+}
+
+// MethodNotAllowedHandler answers requests whose path matches a route but
+// whose method does not
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+	})
+}
+
+func write(w http.ResponseWriter, status int, contentType string, body any) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -1,286 +1,213 @@
 package retail
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
+
+	"isy-api/auth"
+	"isy-api/listing"
+	"isy-api/response"
+	"isy-api/search"
+	"isy-api/validate"
 )
 
-// APIResponse represents a standard API response
-type APIResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
-}
+var errInvalidProductID = response.New(http.StatusBadRequest, response.CodeInvalidID, "Invalid product ID")
+
+// productListing lists the product fields clients may filter and sort on
+var productListing = listing.Spec{Hidden: []string{search.GramsField}, Fields: []listing.Field{
+	{Name: "id", Path: "_id", Type: listing.ObjectID, Sortable: true},
+	{Name: "name", Type: listing.String, Sortable: true},
+	{Name: "category", Type: listing.String},
+	{Name: "createdAt", Type: listing.Time, Sortable: true},
+	{Name: "updatedAt", Type: listing.Time, Sortable: true},
+}}
 
 // RetailHandlers contains all retail-related handlers
 type RetailHandlers struct {
-	DB *mongo.Database
+	Products ProductRepository
+	Metadata MetadataRepository
+	Auth     *auth.Service
+}
+
+// NewRetailHandlers creates a new retail handlers instance backed by MongoDB
+func NewRetailHandlers(db *mongo.Database, authService *auth.Service) *RetailHandlers {
+	return &RetailHandlers{
+		Products: NewMongoProductRepository(db),
+		Metadata: NewMongoMetadataRepository(db),
+		Auth:     authService,
+	}
 }
 
-// NewRetailHandlers creates a new retail handlers instance
-func NewRetailHandlers(db *mongo.Database) *RetailHandlers {
-	return &RetailHandlers{DB: db}
+// NewMemoryRetailHandlers creates handlers on empty memory repositories, for
+// tests and local development without MongoDB
+func NewMemoryRetailHandlers() *RetailHandlers {
+	return &RetailHandlers{
+		Products: NewMemoryProductRepository(),
+		Metadata: NewMemoryMetadataRepository(),
+	}
 }
 
-// GetProducts retrieves all products
+// GetProducts lists products a page at a time
 func (rh *RetailHandlers) GetProducts(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
+	query, err := listing.Parse(r, productListing)
+	if err != nil {
+		response.WriteError(w, r, err)
+		return
+	}
+
+	result, err := rh.Products.List(r.Context(), query)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Products", "Failed to fetch products")
 		return
 	}
 
-	ctx := r.Context()
-	collection := rh.DB.Collection("products")
+	response.List(w, r, result.Items, result.Page())
+}
 
-	cursor, err := collection.Find(ctx, bson.M{})
+// SearchProducts finds products by name, ID, category or description
+func (rh *RetailHandlers) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	query, limit, err := search.Parse(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch products")
+		response.WriteError(w, r, err)
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var products []bson.M
-	if err := cursor.All(ctx, &products); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to parse products")
+	hits, err := rh.Products.Search(r.Context(), query, limit)
+	if err != nil {
+		respondWithRepositoryError(w, r, err, "Products", "Search failed")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    products,
-	})
+	response.JSON(w, r, http.StatusOK, hits)
 }
 
 // GetProduct retrieves a single product by ID
 func (rh *RetailHandlers) GetProduct(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid product ID")
+		response.WriteError(w, r, errInvalidProductID)
 		return
 	}
 
-	ctx := r.Context()
-	collection := rh.DB.Collection("products")
-
-	var product bson.M
-	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&product)
+	product, err := rh.Products.Get(r.Context(), objID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			respondWithError(w, http.StatusNotFound, "Product not found")
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch product")
+		respondWithRepositoryError(w, r, err, "Product", "Failed to fetch product")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    product,
-	})
+	response.JSON(w, r, http.StatusOK, product)
 }
 
 // CreateProduct creates a new product
 func (rh *RetailHandlers) CreateProduct(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	var product map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	product, err := validate.Decode(r, productSchema)
+	if err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
 	product["createdAt"] = time.Now()
 	product["updatedAt"] = time.Now()
 
-	ctx := r.Context()
-	collection := rh.DB.Collection("products")
-
-	result, err := collection.InsertOne(ctx, product)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create product")
+	if err := rh.Products.Create(r.Context(), product); err != nil {
+		respondWithRepositoryError(w, r, err, "Product", "Failed to create product")
 		return
 	}
 
-	product["_id"] = result.InsertedID
-
-	respondWithJSON(w, http.StatusCreated, APIResponse{
-		Success: true,
-		Data:    product,
-	})
+	response.JSON(w, r, http.StatusCreated, product)
 }
 
 // UpdateProduct updates an existing product
 func (rh *RetailHandlers) UpdateProduct(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid product ID")
+		response.WriteError(w, r, errInvalidProductID)
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	updates, err := validate.DecodePartial(r, productSchema)
+	if err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
 	updates["updatedAt"] = time.Now()
 
-	ctx := r.Context()
-	collection := rh.DB.Collection("products")
-
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objID},
-		bson.M{"$set": updates},
-	)
-
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update product")
+	if err := rh.Products.Update(r.Context(), objID, updates); err != nil {
+		respondWithRepositoryError(w, r, err, "Product", "Failed to update product")
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		respondWithError(w, http.StatusNotFound, "Product not found")
-		return
-	}
-
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    map[string]interface{}{"message": "Product updated successfully"},
-	})
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "Product updated successfully"})
 }
 
 // DeleteProduct deletes a product
 func (rh *RetailHandlers) DeleteProduct(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
 	vars := mux.Vars(r)
 	id := vars["id"]
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid product ID")
-		return
-	}
-
-	ctx := r.Context()
-	collection := rh.DB.Collection("products")
-
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete product")
+		response.WriteError(w, r, errInvalidProductID)
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		respondWithError(w, http.StatusNotFound, "Product not found")
+	if err := rh.Products.Delete(r.Context(), objID); err != nil {
+		respondWithRepositoryError(w, r, err, "Product", "Failed to delete product")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    map[string]interface{}{"message": "Product deleted successfully"},
-	})
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "Product deleted successfully"})
 }
 
 // GetMetadata retrieves retail metadata
 func (rh *RetailHandlers) GetMetadata(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	ctx := r.Context()
-	collection := rh.DB.Collection("metadata")
-
-	var metadata bson.M
-	err := collection.FindOne(ctx, bson.M{"type": "retail"}).Decode(&metadata)
+	metadata, err := rh.Metadata.Get(r.Context(), "retail")
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			// Return default metadata
-			metadata = bson.M{
-				"type":    "retail",
-				"version": "1.0.0",
-			}
-		} else {
-			respondWithError(w, http.StatusInternalServerError, "Failed to fetch metadata")
+		if !errors.Is(err, ErrNotFound) {
+			respondWithRepositoryError(w, r, err, "Metadata", "Failed to fetch metadata")
 			return
 		}
+		// Return default metadata
+		metadata = bson.M{
+			"type":    "retail",
+			"version": "1.0.0",
+		}
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    metadata,
-	})
+	response.JSON(w, r, http.StatusOK, metadata)
 }
 
 // SaveMetadata saves retail metadata
 func (rh *RetailHandlers) SaveMetadata(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	var metadata map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	metadata, err := validate.Decode(r, metadataSchema)
+	if err != nil {
+		response.WriteError(w, r, err)
 		return
 	}
 
-	metadata["type"] = "retail"
 	metadata["updatedAt"] = time.Now()
 
-	ctx := r.Context()
-	collection := rh.DB.Collection("metadata")
-
-	_, err := collection.ReplaceOne(
-		ctx,
-		bson.M{"type": "retail"},
-		metadata,
-		options.Replace().SetUpsert(true),
-	)
-
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to save metadata")
+	if err := rh.Metadata.Save(r.Context(), "retail", metadata); err != nil {
+		respondWithRepositoryError(w, r, err, "Metadata", "Failed to save metadata")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    map[string]interface{}{"message": "Metadata saved successfully"},
-	})
+	response.JSON(w, r, http.StatusOK, map[string]interface{}{"message": "Metadata saved successfully"})
 }
 
 // AuthRequest represents login credentials
@@ -291,76 +218,28 @@ type AuthRequest struct {
 
 // Authenticate handles admin login
 func (rh *RetailHandlers) Authenticate(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
 	var authReq AuthRequest
 	if err := json.NewDecoder(r.Body).Decode(&authReq); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		response.WriteError(w, r, response.BodyError(err))
 		return
 	}
 
-	ctx := r.Context()
-	collection := rh.DB.Collection("admins")
-
-	var admin bson.M
-	err := collection.FindOne(ctx, bson.M{"username": authReq.Username}).Decode(&admin)
+	result, err := rh.Auth.Login(r.Context(), authReq.Username, authReq.Password, auth.ClientInfoFromRequest(r))
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "Failed to authenticate")
+		auth.RespondWithLoginError(w, r, err)
 		return
 	}
 
-	// Get password from database
-	hashedPassword, ok := admin["password"].(string)
-	if !ok {
-		respondWithError(w, http.StatusInternalServerError, "Invalid admin data")
-		return
-	}
-
-	// Compare password with bcrypt
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(authReq.Password))
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
-		return
-	}
-
-	// Generate a simple token (in production, use JWT)
-	tokenBytes := make([]byte, 32)
-	rand.Read(tokenBytes)
-	token := base64.URLEncoding.EncodeToString(tokenBytes)
-
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"token":    token,
-			"username": authReq.Username,
-		},
-	})
+	response.JSON(w, r, http.StatusOK, result)
 }
 
 // MigrateBase64ToFiles extracts base64 images from MongoDB and saves them as files
 func (rh *RetailHandlers) MigrateBase64ToFiles(w http.ResponseWriter, r *http.Request) {
-	if rh.DB == nil {
-		respondWithError(w, http.StatusServiceUnavailable, "Database not available")
-		return
-	}
-
-	ctx := r.Context()
-	collection := rh.DB.Collection("metadata")
-
-	// Find metadata documents
-	cursor, err := collection.Find(ctx, bson.M{})
+	documents, err := rh.Metadata.List(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch metadata")
+		respondWithRepositoryError(w, r, err, "Metadata", "Failed to fetch metadata")
 		return
 	}
-	defer cursor.Close(ctx)
 
 	migrationStats := map[string]interface{}{
 		"processed": 0,
@@ -371,12 +250,7 @@ func (rh *RetailHandlers) MigrateBase64ToFiles(w http.ResponseWriter, r *http.Re
 	// Note: Since pricing images now use external URLs (Unsplash),
 	// we'll just scan for base64 and report what needs to be replaced
 	
-	for cursor.Next(ctx) {
-		var doc bson.M
-		if err := cursor.Decode(&doc); err != nil {
-			continue
-		}
-
+	for _, doc := range documents {
 		migrationStats["processed"] = migrationStats["processed"].(int) + 1
 		
 		// Check for base64 images in content field
@@ -388,10 +262,7 @@ func (rh *RetailHandlers) MigrateBase64ToFiles(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	respondWithJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Data:    migrationStats,
-	})
+	response.JSON(w, r, http.StatusOK, migrationStats)
 }
 
 // Helper function to check for base64 images in content
@@ -402,7 +273,7 @@ func checkForBase64InContent(content bson.M) bool {
 	if images, ok := content["images"].(bson.M); ok {
 		for _, value := range images {
 			if strVal, ok := value.(string); ok {
-				if len(strVal) > 100 && (strVal[:10] == "data:image" || strVal[:10] == "data:image") {
+				if len(strVal) > 100 && strings.HasPrefix(strVal, "data:image") {
 					hasBase64 = true
 				}
 			}
@@ -414,7 +285,7 @@ func checkForBase64InContent(content bson.M) bool {
 		for _, item := range pricing {
 			if itemMap, ok := item.(bson.M); ok {
 				if imgStr, ok := itemMap["image"].(string); ok {
-					if len(imgStr) > 100 && (imgStr[:10] == "data:image" || imgStr[:10] == "data:image") {
+					if len(imgStr) > 100 && strings.HasPrefix(imgStr, "data:image") {
 						hasBase64 = true
 					}
 				}
@@ -425,16 +296,22 @@ func checkForBase64InContent(content bson.M) bool {
 	return hasBase64
 }
 
-// Helper functions
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, APIResponse{
-		Success: false,
-		Error:   message,
-	})
+// respondWithRepositoryError reports err from a repository. resource names
+// what was looked up, e.g. "Product", and message describes unexpected
+// failures.
+func respondWithRepositoryError(w http.ResponseWriter, r *http.Request, err error, resource, message string) {
+	response.WriteError(w, r, repositoryError(err, resource, message))
 }
 
-func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(payload)
+// repositoryError converts err from a repository to the error response
+// reporting it
+func repositoryError(err error, resource, message string) *response.Error {
+	switch {
+	case errors.Is(err, ErrUnavailable):
+		return response.ErrUnavailable
+	case errors.Is(err, ErrNotFound):
+		return response.New(http.StatusNotFound, response.CodeNotFound, resource+" not found")
+	default:
+		return response.Internal(message, err)
+	}
 }
@@ -0,0 +1,145 @@
+package retail
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/apitest"
+)
+
+const testProduct = `{"category":"kiosk","name":"Display stand"}`
+
+// productRequest builds a request for tenantID with id as the route's
+// product ID
+func productRequest(method, body, tenantID, id string) *http.Request {
+	return apitest.WithVars(apitest.ForTenant(apitest.Request(method, "/products", body), tenantID), map[string]string{"id": id})
+}
+
+// createProduct creates testProduct for tenantID and returns its ID
+func createProduct(t *testing.T, rh *RetailHandlers, tenantID string) string {
+	t.Helper()
+	status, body := apitest.Serve(t, rh.CreateProduct, productRequest("POST", testProduct, tenantID, ""))
+	if status != http.StatusCreated {
+		t.Fatalf("CreateProduct = %d %s", status, body.Code)
+	}
+	var product struct {
+		ID string `json:"_id"`
+	}
+	if body.Decode(t, &product); product.ID == "" {
+		t.Fatalf("CreateProduct = %s, want an ID", body.Data)
+	}
+	return product.ID
+}
+
+func TestProductCRUD(t *testing.T) {
+	rh := NewMemoryRetailHandlers()
+	id := createProduct(t, rh, "")
+
+	if status, body := apitest.Serve(t, rh.GetProducts, productRequest("GET", "", "", "")); status != http.StatusOK || body.Count(t) != 1 {
+		t.Fatalf("GetProducts = %d %s", status, body.Data)
+	}
+
+	if status, body := apitest.Serve(t, rh.UpdateProduct, productRequest("PUT", `{"name":"Floor stand"}`, "", id)); status != http.StatusOK {
+		t.Fatalf("UpdateProduct = %d %s", status, body.Code)
+	}
+	status, body := apitest.Serve(t, rh.GetProduct, productRequest("GET", "", "", id))
+	var product map[string]any
+	if body.Decode(t, &product); status != http.StatusOK || product["name"] != "Floor stand" || product["category"] != "kiosk" {
+		t.Fatalf("GetProduct after update = %d %s", status, body.Data)
+	}
+
+	if status, body := apitest.Serve(t, rh.DeleteProduct, productRequest("DELETE", "", "", id)); status != http.StatusOK {
+		t.Fatalf("DeleteProduct = %d %s", status, body.Code)
+	}
+	if status, _ := apitest.Serve(t, rh.GetProduct, productRequest("GET", "", "", id)); status != http.StatusNotFound {
+		t.Fatalf("GetProduct after delete = %d, want 404", status)
+	}
+}
+
+func TestProductNotFound(t *testing.T) {
+	rh := NewMemoryRetailHandlers()
+	missing := primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		id      string
+		want    int
+	}{
+		{"get unknown", rh.GetProduct, "GET", "", missing, http.StatusNotFound},
+		{"get malformed", rh.GetProduct, "GET", "", "nope", http.StatusBadRequest},
+		{"update unknown", rh.UpdateProduct, "PUT", `{"name":"Floor stand"}`, missing, http.StatusNotFound},
+		{"delete unknown", rh.DeleteProduct, "DELETE", "", missing, http.StatusNotFound},
+		{"create invalid", rh.CreateProduct, "POST", `{"category":"shelf","name":"Display stand"}`, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := apitest.Serve(t, tt.handler, productRequest(tt.method, tt.body, "", tt.id)); status != tt.want {
+				t.Errorf("status = %d %s, want %d", status, body.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductsPerTenant(t *testing.T) {
+	rh := NewMemoryRetailHandlers()
+	id := createProduct(t, rh, "north")
+
+	for tenantID, want := range map[string]int{"north": 1, "south": 0, "": 0} {
+		if _, body := apitest.Serve(t, rh.GetProducts, productRequest("GET", "", tenantID, "")); body.Count(t) != want {
+			t.Errorf("tenant %q sees %s, want %d products", tenantID, body.Data, want)
+		}
+	}
+
+	if status, _ := apitest.Serve(t, rh.GetProduct, productRequest("GET", "", "south", id)); status != http.StatusNotFound {
+		t.Errorf("another tenant's product = %d, want 404", status)
+	}
+	if status, _ := apitest.Serve(t, rh.DeleteProduct, productRequest("DELETE", "", "south", id)); status != http.StatusNotFound {
+		t.Errorf("deleting another tenant's product = %d, want 404", status)
+	}
+}
+
+func TestMetadataPerTenant(t *testing.T) {
+	rh := NewMemoryRetailHandlers()
+	save := apitest.ForTenant(apitest.Request("PUT", "/metadata", `{"version":"2.0.0"}`), "north")
+	if status, body := apitest.Serve(t, rh.SaveMetadata, save); status != http.StatusOK {
+		t.Fatalf("SaveMetadata = %d %s", status, body.Code)
+	}
+
+	for tenantID, want := range map[string]string{"north": "2.0.0", "south": "1.0.0"} {
+		_, body := apitest.Serve(t, rh.GetMetadata, apitest.ForTenant(apitest.Request("GET", "/metadata", ""), tenantID))
+		var metadata map[string]any
+		if body.Decode(t, &metadata); metadata["version"] != want {
+			t.Errorf("tenant %q metadata = %s, want version %s", tenantID, body.Data, want)
+		}
+	}
+}
+
+func TestRepositoryError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		resource string
+		status   int
+		message  string
+	}{
+		{"missing product", ErrNotFound, "Product", http.StatusNotFound, "Product not found"},
+		{"missing metadata", fmt.Errorf("retail: %w", ErrNotFound), "Metadata", http.StatusNotFound, "Metadata not found"},
+		{"no database", ErrUnavailable, "Product", http.StatusServiceUnavailable, "Database not available"},
+		{"anything else", errors.New("socket closed"), "Product", http.StatusInternalServerError, "Failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := repositoryError(tt.err, tt.resource, "Failed")
+			if got.Status != tt.status || got.Message != tt.message {
+				t.Errorf("repositoryError = %d %q, want %d %q", got.Status, got.Message, tt.status, tt.message)
+			}
+		})
+	}
+}
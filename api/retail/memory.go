@@ -0,0 +1,156 @@
+package retail
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/listing"
+	"isy-api/search"
+	"isy-api/tenant"
+)
+
+// MemoryProductRepository keeps products in process memory, in insertion
+// order and apart per tenant. It is meant for tests and local development
+// without MongoDB.
+type MemoryProductRepository struct {
+	mu       sync.RWMutex
+	products map[string][]bson.M
+}
+
+// NewMemoryProductRepository creates an empty product repository
+func NewMemoryProductRepository() *MemoryProductRepository {
+	return &MemoryProductRepository{products: map[string][]bson.M{}}
+}
+
+func (repo *MemoryProductRepository) List(ctx context.Context, query listing.Query) (listing.Result[bson.M], error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	result, err := listing.Apply(repo.products[tenant.ID(ctx)], query)
+	for i, product := range result.Items {
+		result.Items[i] = maps.Clone(product)
+	}
+	return result, err
+}
+
+func (repo *MemoryProductRepository) Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	hits, err := search.Rank(search.Products, query, repo.products[tenant.ID(ctx)], limit)
+	for i := range hits {
+		hits[i].Item = maps.Clone(hits[i].Item.(bson.M))
+	}
+	return hits, err
+}
+
+func (repo *MemoryProductRepository) Get(ctx context.Context, id primitive.ObjectID) (bson.M, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	products := repo.products[tenant.ID(ctx)]
+	if i := index(products, id); i >= 0 {
+		return maps.Clone(products[i]), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (repo *MemoryProductRepository) Create(ctx context.Context, product bson.M) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := product["_id"]; !ok {
+		product["_id"] = primitive.NewObjectID()
+	}
+	key := tenant.ID(ctx)
+	repo.products[key] = append(repo.products[key], maps.Clone(product))
+	return nil
+}
+
+func (repo *MemoryProductRepository) Update(ctx context.Context, id primitive.ObjectID, updates bson.M) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	products := repo.products[tenant.ID(ctx)]
+	i := index(products, id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	maps.Copy(products[i], updates)
+	return nil
+}
+
+func (repo *MemoryProductRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	key := tenant.ID(ctx)
+	i := index(repo.products[key], id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	repo.products[key] = slices.Delete(repo.products[key], i, i+1)
+	return nil
+}
+
+// index finds the product with id in products
+func index(products []bson.M, id primitive.ObjectID) int {
+	for i, product := range products {
+		if product["_id"] == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// MemoryMetadataRepository keeps metadata documents in process memory, by
+// tenant and kind
+type MemoryMetadataRepository struct {
+	mu       sync.RWMutex
+	metadata map[string]map[string]bson.M
+}
+
+// NewMemoryMetadataRepository creates an empty metadata repository
+func NewMemoryMetadataRepository() *MemoryMetadataRepository {
+	return &MemoryMetadataRepository{metadata: map[string]map[string]bson.M{}}
+}
+
+func (repo *MemoryMetadataRepository) List(ctx context.Context) ([]bson.M, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	documents := make([]bson.M, 0, len(repo.metadata[tenant.ID(ctx)]))
+	for _, metadata := range repo.metadata[tenant.ID(ctx)] {
+		documents = append(documents, maps.Clone(metadata))
+	}
+	return documents, nil
+}
+
+func (repo *MemoryMetadataRepository) Get(ctx context.Context, kind string) (bson.M, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	metadata, ok := repo.metadata[tenant.ID(ctx)][kind]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return maps.Clone(metadata), nil
+}
+
+func (repo *MemoryMetadataRepository) Save(ctx context.Context, kind string, metadata bson.M) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	key := tenant.ID(ctx)
+	if repo.metadata[key] == nil {
+		repo.metadata[key] = map[string]bson.M{}
+	}
+	metadata["type"] = kind
+	repo.metadata[key][kind] = maps.Clone(metadata)
+	return nil
+}
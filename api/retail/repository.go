@@ -0,0 +1,206 @@
+package retail
+
+import (
+	"context"
+	"errors"
+	"maps"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"isy-api/listing"
+	"isy-api/search"
+	"isy-api/tenant"
+)
+
+var (
+	// ErrNotFound is returned when a document does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrUnavailable is returned when the database is not connected
+	ErrUnavailable = errors.New("database not available")
+)
+
+// ProductRepository stores retail products. Products are schemaless
+// documents edited by the shop front-end.
+type ProductRepository interface {
+	List(ctx context.Context, query listing.Query) (listing.Result[bson.M], error)
+	Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error)
+	Get(ctx context.Context, id primitive.ObjectID) (bson.M, error)
+	// Create stores product and sets its "_id"
+	Create(ctx context.Context, product bson.M) error
+	Update(ctx context.Context, id primitive.ObjectID, updates bson.M) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// MetadataRepository stores site metadata documents, one per type
+type MetadataRepository interface {
+	List(ctx context.Context) ([]bson.M, error)
+	Get(ctx context.Context, kind string) (bson.M, error)
+	Save(ctx context.Context, kind string, metadata bson.M) error
+}
+
+func mongoCollection(ctx context.Context, db *mongo.Database, name string) (*mongo.Collection, error) {
+	db = tenant.Database(ctx, db)
+	if db == nil {
+		return nil, ErrUnavailable
+	}
+	return db.Collection(name), nil
+}
+
+// MongoProductRepository stores products in the products collection of the
+// request's tenant
+type MongoProductRepository struct {
+	DB *mongo.Database
+}
+
+// NewMongoProductRepository creates a product repository backed by db
+func NewMongoProductRepository(db *mongo.Database) *MongoProductRepository {
+	return &MongoProductRepository{DB: db}
+}
+
+func (repo *MongoProductRepository) List(ctx context.Context, query listing.Query) (listing.Result[bson.M], error) {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return listing.Result[bson.M]{}, err
+	}
+	return listing.Find[bson.M](ctx, collection, query)
+}
+
+func (repo *MongoProductRepository) Search(ctx context.Context, query search.Query, limit int) ([]search.Hit, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return nil, err
+	}
+	return search.Find[bson.M](ctx, collection, search.Products, query, limit)
+}
+
+func (repo *MongoProductRepository) Get(ctx context.Context, id primitive.ObjectID) (bson.M, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return nil, err
+	}
+
+	var product bson.M
+	opts := options.FindOne().SetProjection(bson.M{search.GramsField: 0})
+	if err := collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return product, nil
+}
+
+func (repo *MongoProductRepository) Create(ctx context.Context, product bson.M) error {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return err
+	}
+
+	grams, err := search.Products.Grams(product)
+	if err != nil {
+		return err
+	}
+	stored := maps.Clone(product)
+	stored[search.GramsField] = grams
+
+	result, err := collection.InsertOne(ctx, stored)
+	if err != nil {
+		return err
+	}
+	product["_id"] = result.InsertedID
+	return nil
+}
+
+func (repo *MongoProductRepository) Update(ctx context.Context, id primitive.ObjectID, updates bson.M) error {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return err
+	}
+
+	updates = maps.Clone(updates)
+	delete(updates, search.GramsField)
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": updates})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return search.Products.Refresh(ctx, collection, id)
+}
+
+func (repo *MongoProductRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	collection, err := mongoCollection(ctx, repo.DB, "products")
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MongoMetadataRepository stores metadata in the metadata collection, keyed
+// by its "type" field
+type MongoMetadataRepository struct {
+	DB *mongo.Database
+}
+
+// NewMongoMetadataRepository creates a metadata repository backed by db
+func NewMongoMetadataRepository(db *mongo.Database) *MongoMetadataRepository {
+	return &MongoMetadataRepository{DB: db}
+}
+
+func (repo *MongoMetadataRepository) List(ctx context.Context) ([]bson.M, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "metadata")
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	documents := []bson.M{}
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+func (repo *MongoMetadataRepository) Get(ctx context.Context, kind string) (bson.M, error) {
+	collection, err := mongoCollection(ctx, repo.DB, "metadata")
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata bson.M
+	if err := collection.FindOne(ctx, bson.M{"type": kind}).Decode(&metadata); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func (repo *MongoMetadataRepository) Save(ctx context.Context, kind string, metadata bson.M) error {
+	collection, err := mongoCollection(ctx, repo.DB, "metadata")
+	if err != nil {
+		return err
+	}
+
+	metadata["type"] = kind
+	_, err = collection.ReplaceOne(ctx, bson.M{"type": kind}, metadata, options.Replace().SetUpsert(true))
+	return err
+}
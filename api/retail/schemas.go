@@ -0,0 +1,42 @@
+package retail
+
+import "isy-api/validate"
+
+// Shared field shapes
+var (
+	identifier = validate.Field{Type: validate.String, MaxLength: 64}
+	label      = validate.Field{Type: validate.String, MaxLength: 200}
+	imageURL   = validate.Field{Type: validate.String, MaxLength: 2048, Format: validate.URL}
+	// Prices are displayed as entered, e.g. "$1,200" or "from 99"
+	priceText = validate.Field{Type: validate.String, MaxLength: 40}
+)
+
+// productSchema is the body of CreateProduct, and of UpdateProduct for the
+// fields it changes. It follows the shop front-end's ProductItem.
+var productSchema = validate.Schema{Fields: []validate.Field{
+	identifier.Named("id"),
+	{Name: "category", Type: validate.String, Required: true, Enum: []string{"kiosk", "pos"}},
+	label.Named("name").Require(),
+	{Name: "description", Type: validate.String, MaxLength: 5000},
+	imageURL.Named("image"),
+	{Name: "images", Type: validate.Array, MaxLength: 50, Items: &imageURL},
+	{Name: "specs", Type: validate.Array, MaxLength: 100, Items: &label},
+	priceText.Named("pricePurchase"),
+	priceText.Named("priceRent"),
+	{Name: "variants", Type: validate.Array, MaxLength: 50, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+		identifier.Named("id"),
+		label.Named("name").Require(),
+		{Name: "options", Type: validate.Array, MaxLength: 50, Items: &validate.Field{Type: validate.Object, Fields: []validate.Field{
+			identifier.Named("id"),
+			label.Named("name").Require(),
+			priceText.Named("priceBuy"),
+			priceText.Named("priceRent"),
+		}}},
+	}}},
+}}
+
+// metadataSchema is the body of SaveMetadata: free-form site settings whose
+// keys are still checked
+var metadataSchema = validate.Schema{Open: true, Fields: []validate.Field{
+	{Name: "version", Type: validate.String, MaxLength: 20},
+}}
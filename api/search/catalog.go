@@ -0,0 +1,47 @@
+package search
+
+// The searchable collections. Kiosk and retail products live in the same
+// products collection, which can only have one text index, so Products
+// covers the fields of both.
+var (
+	Products = Index{
+		Type:       "product",
+		Collection: "products",
+		Fields: []Field{
+			{Path: "name", Kind: Text, Weight: 3},
+			{Path: "sku", Kind: Identifier, Weight: 3},
+			{Path: "productId", Kind: Identifier, Weight: 2},
+			{Path: "variants.sku", Kind: Identifier, Weight: 2},
+			{Path: "id", Kind: Identifier, Weight: 2},
+			{Path: "category", Kind: Text},
+			{Path: "description", Kind: Text},
+		},
+	}
+	Customers = Index{
+		Type:       "customer",
+		Collection: "customers",
+		Fields: []Field{
+			{Path: "name", Kind: Text, Weight: 3},
+			{Path: "lastName", Kind: Text, Weight: 3},
+			{Path: "nickname", Kind: Text, Weight: 2},
+			{Path: "cell", Kind: Phone, Weight: 3},
+			{Path: "memberId", Kind: Identifier, Weight: 3},
+			{Path: "customerId", Kind: Identifier, Weight: 2},
+			{Path: "email", Kind: Text},
+		},
+	}
+	Patients = Index{
+		Type:       "patient",
+		Collection: "patients",
+		Fields: []Field{
+			{Path: "firstName", Kind: Text, Weight: 3},
+			{Path: "lastName", Kind: Text, Weight: 3},
+			{Path: "patientId", Kind: Identifier, Weight: 3},
+			{Path: "phoneNumber", Kind: Phone, Weight: 3},
+			{Path: "email", Kind: Text},
+		},
+	}
+)
+
+// Indexes lists every searchable collection of a business database
+var Indexes = []Index{Products, Customers, Patients}
@@ -0,0 +1,240 @@
+// Package search finds customers, products and patients by name, phone
+// number and identifier, tolerating typos, accents and formatting.
+//
+// Searchable collections carry a MongoDB text index over their fields and a
+// GramsField array holding the trigrams of every field, normalized the same
+// way queries are. A search gathers candidates from both indexes and ranks
+// them in Go, so the in-memory repositories rank exactly like MongoDB does.
+//
+// The trigrams are written by this API when it creates or updates a
+// document. Documents written by other applications get them from Reindex.
+package search
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GramsField holds the trigrams of a searchable document. It is internal
+// and should be left out of responses.
+const GramsField = "searchGrams"
+
+// Index names of searchable collections
+const (
+	textIndexName  = "search_text"
+	gramsIndexName = "search_grams"
+)
+
+// Kind says how a field is compared with a query
+type Kind int
+
+const (
+	// Text fields hold names and words and match fuzzily
+	Text Kind = iota
+	// Identifier fields such as SKUs and member IDs match whole or by
+	// prefix, ignoring case and punctuation
+	Identifier
+	// Phone fields match on their digits
+	Phone
+)
+
+// Field is a searchable document field
+type Field struct {
+	// Path is the dotted document path. Arrays of strings are searched
+	// element by element.
+	Path string
+	Kind Kind
+	// Weight ranks matches in this field against the others; 1 when zero
+	Weight int
+}
+
+func (f Field) weight() float64 {
+	return float64(max(f.Weight, 1))
+}
+
+// Index describes a searchable collection
+type Index struct {
+	// Type labels results from this collection, e.g. "customer"
+	Type       string
+	Collection string
+	Fields     []Field
+}
+
+// values returns the strings stored at the field's path in document,
+// descending into arrays like MongoDB does, so "variants.sku" yields the SKU
+// of every variant
+func (f Field) values(document bson.Raw) []string {
+	return collect(bson.RawValue{Type: bsontype.EmbeddedDocument, Value: document}, strings.Split(f.Path, "."))
+}
+
+func collect(value bson.RawValue, path []string) []string {
+	if array, ok := value.ArrayOK(); ok {
+		elements, _ := array.Values()
+		var list []string
+		for _, element := range elements {
+			list = append(list, collect(element, path)...)
+		}
+		return list
+	}
+	if len(path) == 0 {
+		if s, ok := value.StringValueOK(); ok {
+			return []string{s}
+		}
+		return nil
+	}
+	document, ok := value.DocumentOK()
+	if !ok {
+		return nil
+	}
+	next, err := document.LookupErr(path[0])
+	if err != nil {
+		return nil
+	}
+	return collect(next, path[1:])
+}
+
+// Grams returns the trigrams to store in GramsField for document, which may
+// be any value that marshals to a BSON document
+func (ix Index) Grams(document any) ([]string, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	return ix.grams(raw), nil
+}
+
+func (ix Index) grams(document bson.Raw) []string {
+	grams := []string{}
+	for _, field := range ix.Fields {
+		for _, value := range field.values(document) {
+			grams = append(grams, fieldGrams(field.Kind, value)...)
+		}
+	}
+	slices.Sort(grams)
+	return slices.Compact(grams)
+}
+
+func fieldGrams(kind Kind, value string) []string {
+	var grams []string
+	switch kind {
+	case Text:
+		for _, word := range words(foldText(value).runes) {
+			grams = append(grams, trigrams(word)...)
+		}
+	case Identifier:
+		if id := string(compact(value).runes); id != "" {
+			grams = trigrams(id)
+		}
+	case Phone:
+		if digits := NormalizePhone(value); digits != "" {
+			grams = trigrams(digits)
+		}
+	}
+	return grams
+}
+
+// EnsureIndexes creates the text and trigram indexes in db. A collection
+// can only have one text index; if another exists, the error says so and
+// searches use the existing one.
+func (ix Index) EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	keys := bson.D{}
+	weights := bson.D{}
+	for _, field := range ix.Fields {
+		if field.Kind == Phone {
+			continue
+		}
+		keys = append(keys, bson.E{Key: field.Path, Value: "text"})
+		weights = append(weights, bson.E{Key: field.Path, Value: max(field.Weight, 1)})
+	}
+
+	_, err := db.Collection(ix.Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: keys,
+			// Names and identifiers are not prose: no stemming or stop words
+			Options: options.Index().SetName(textIndexName).SetWeights(weights).SetDefaultLanguage("none"),
+		},
+		{
+			Keys:    bson.D{{Key: GramsField, Value: 1}},
+			Options: options.Index().SetName(gramsIndexName),
+		},
+	})
+	return err
+}
+
+// DropIndexes removes the search indexes and trigrams from db
+func (ix Index) DropIndexes(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection(ix.Collection)
+	for _, name := range []string{textIndexName, gramsIndexName} {
+		_, err := collection.Indexes().DropOne(ctx, name)
+		var cmdErr mongo.CommandError
+		if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27)) {
+			return err
+		}
+	}
+	_, err := collection.UpdateMany(ctx, bson.M{GramsField: bson.M{"$exists": true}}, bson.M{"$unset": bson.M{GramsField: ""}})
+	return err
+}
+
+// reindexBatch is how many documents Reindex updates per bulk write
+const reindexBatch = 500
+
+// Reindex recomputes GramsField for every document of the collection in db
+// and returns how many documents changed
+func (ix Index) Reindex(ctx context.Context, db *mongo.Database) (int64, error) {
+	collection := db.Collection(ix.Collection)
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var updated int64
+	var models []mongo.WriteModel
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if result != nil {
+			updated += result.ModifiedCount
+		}
+		models = models[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": cursor.Current.Lookup("_id")}).
+			SetUpdate(bson.M{"$set": bson.M{GramsField: ix.grams(cursor.Current)}}))
+		if len(models) == reindexBatch {
+			if err := flush(); err != nil {
+				return updated, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, err
+	}
+	return updated, flush()
+}
+
+// Refresh recomputes GramsField for the document with id, after an update
+// that may have changed its searchable fields
+func (ix Index) Refresh(ctx context.Context, collection *mongo.Collection, id any) error {
+	document, err := collection.FindOne(ctx, bson.M{"_id": id}).Raw()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{GramsField: ix.grams(document)}})
+	return err
+}
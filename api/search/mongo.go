@@ -0,0 +1,118 @@
+package search
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// candidateLimit caps the documents each index contributes before ranking
+const candidateLimit = 200
+
+// textScoreField carries MongoDB's text score into ranking
+const textScoreField = "_textScore"
+
+// Find searches collection and decodes the best limit hits into T
+func Find[T any](ctx context.Context, collection *mongo.Collection, ix Index, q Query, limit int) ([]Hit, error) {
+	var documents []bson.Raw
+	var bonus []float64
+	seen := map[string]int{}
+	add := func(document bson.Raw, textScore float64) {
+		id := document.Lookup("_id").String()
+		if i, ok := seen[id]; ok {
+			bonus[i] = max(bonus[i], textScore)
+			return
+		}
+		seen[id] = len(documents)
+		documents = append(documents, document)
+		bonus = append(bonus, textScore)
+	}
+
+	// Whole words, through the text index. A collection without one simply
+	// relies on the trigrams.
+	textCursor, err := collection.Find(ctx,
+		bson.M{"$text": bson.M{"$search": q.Raw}},
+		options.Find().
+			SetProjection(bson.M{textScoreField: bson.M{"$meta": "textScore"}, GramsField: 0}).
+			SetSort(bson.M{textScoreField: bson.M{"$meta": "textScore"}}).
+			SetLimit(candidateLimit))
+	switch {
+	case err == nil:
+		documents, err := readAll(ctx, textCursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, document := range documents {
+			score, _ := document.Lookup(textScoreField).DoubleOK()
+			add(document, score)
+		}
+	case !isMissingTextIndex(err):
+		return nil, err
+	}
+
+	// Fragments and typos, through the trigrams; documents sharing the most
+	// trigrams with the query come first
+	grams := q.grams()
+	gramCursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{GramsField: bson.M{"$in": grams}}}},
+		{{Key: "$addFields", Value: bson.M{"_shared": bson.M{"$size": bson.M{"$setIntersection": bson.A{"$" + GramsField, grams}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_shared", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: candidateLimit}},
+		{{Key: "$project", Value: bson.M{GramsField: 0, "_shared": 0}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	gramDocuments, err := readAll(ctx, gramCursor)
+	if err != nil {
+		return nil, err
+	}
+	for _, document := range gramDocuments {
+		add(document, 0)
+	}
+
+	return ix.rank(q, documents, bonus, limit, func(i int) (any, error) {
+		var item T
+		err := bson.Unmarshal(without(documents[i], textScoreField), &item)
+		return item, err
+	})
+}
+
+func readAll(ctx context.Context, cursor *mongo.Cursor) ([]bson.Raw, error) {
+	defer cursor.Close(ctx)
+	var documents []bson.Raw
+	for cursor.Next(ctx) {
+		documents = append(documents, append(bson.Raw(nil), cursor.Current...))
+	}
+	return documents, cursor.Err()
+}
+
+// without returns document minus the top-level key
+func without(document bson.Raw, key string) bson.Raw {
+	if _, err := document.LookupErr(key); err != nil {
+		return document
+	}
+	elements, _ := document.Elements()
+	kept := bson.D{}
+	for _, element := range elements {
+		if element.Key() != key {
+			kept = append(kept, bson.E{Key: element.Key(), Value: element.Value()})
+		}
+	}
+	raw, _ := bson.Marshal(kept)
+	return raw
+}
+
+// isMissingTextIndex reports the error of a $text query on a collection
+// without a text index
+func isMissingTextIndex(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 27 {
+		return true
+	}
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(27)
+}
@@ -0,0 +1,322 @@
+package search
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"isy-api/response"
+)
+
+// Query limits
+const (
+	MinQueryLength = 2
+	MaxQueryLength = 100
+	// MinPhoneDigits is how many digits a query needs to be compared with
+	// phone numbers
+	MinPhoneDigits = 4
+)
+
+// ErrInvalidQuery is returned for queries that are too short or too long
+var ErrInvalidQuery = errors.New("query must be 2 to 100 characters")
+
+// Query is a parsed search query
+type Query struct {
+	// Raw is the query as typed, for the text index
+	Raw string
+	// Words are the folded words of the query
+	Words []string
+	// ID is the query compacted like identifiers
+	ID string
+	// Digits are the query's phone digits, empty when there are too few
+	Digits string
+}
+
+// ParseQuery normalizes raw for matching
+func ParseQuery(raw string) (Query, error) {
+	raw = strings.TrimSpace(raw)
+	if n := utf8.RuneCountInString(raw); n < MinQueryLength || n > MaxQueryLength {
+		return Query{}, ErrInvalidQuery
+	}
+
+	q := Query{
+		Raw:   raw,
+		Words: words(foldText(raw).runes),
+		ID:    string(compact(raw).runes),
+	}
+	if digits := NormalizePhone(raw); len(digits) >= MinPhoneDigits {
+		q.Digits = digits
+	}
+	if len(q.Words) == 0 && q.Digits == "" {
+		return Query{}, ErrInvalidQuery
+	}
+	return q, nil
+}
+
+// grams are the trigrams a matching document may share with the query
+func (q Query) grams() []string {
+	var grams []string
+	for _, word := range q.Words {
+		grams = append(grams, trigrams(word)...)
+	}
+	if q.ID != "" {
+		grams = append(grams, trigrams(q.ID)...)
+	}
+	if q.Digits != "" {
+		grams = append(grams, trigrams(q.Digits)...)
+	}
+	slices.Sort(grams)
+	return slices.Compact(grams)
+}
+
+// Scores of the ways a query can match a field value
+const (
+	exactScore    = 1.0
+	prefixScore   = 0.8
+	containsScore = 0.6
+	// fuzzyScore is scaled by the similarity, which must reach
+	// minSimilarity to count
+	fuzzyScore    = 0.5
+	minSimilarity = 0.6
+	// suffixScore rewards phone numbers ending in the query digits, which
+	// is how staff type them without the country or area code
+	suffixScore = 0.9
+)
+
+// Hit is one search result
+type Hit struct {
+	Type  string  `json:"type"`
+	Score float64 `json:"score"`
+	// Highlights maps field paths to their value, HTML-escaped, with the
+	// matching parts wrapped in <mark>
+	Highlights map[string]string `json:"highlights,omitempty"`
+	Item       any               `json:"item"`
+}
+
+// score rates how well document matches q; zero means no match
+func (ix Index) score(q Query, document bson.Raw) (float64, map[string]string) {
+	var total float64
+	highlights := map[string]string{}
+	for _, field := range ix.Fields {
+		best := 0.0
+		for _, value := range field.values(document) {
+			score, marked := field.match(q, value)
+			if score > best {
+				best = score
+				if marked != "" {
+					highlights[field.Path] = marked
+				} else {
+					delete(highlights, field.Path)
+				}
+			}
+		}
+		total += best * field.weight()
+	}
+	if len(highlights) == 0 {
+		highlights = nil
+	}
+	return math.Round(total*1000) / 1000, highlights
+}
+
+// match scores value against q and highlights the matching parts
+func (f Field) match(q Query, value string) (float64, string) {
+	switch f.Kind {
+	case Identifier:
+		id := compact(value)
+		return matchWhole(q.ID, id)
+	case Phone:
+		if q.Digits == "" {
+			return 0, ""
+		}
+		digits := fold(value, isDigit)
+		normalized := strings.TrimPrefix(string(digits.runes), "00")
+		switch {
+		case normalized == q.Digits:
+			return exactScore, digits.highlight(digits.find([]rune(q.Digits)))
+		case strings.HasSuffix(normalized, q.Digits):
+			return suffixScore, digits.highlight(digits.find([]rune(q.Digits)))
+		case strings.Contains(normalized, q.Digits):
+			return containsScore, digits.highlight(digits.find([]rune(q.Digits)))
+		}
+		return 0, ""
+	}
+	return matchWords(q.Words, foldText(value))
+}
+
+// matchWhole compares a compacted identifier with the compacted query
+func matchWhole(query string, value folded) (float64, string) {
+	if query == "" {
+		return 0, ""
+	}
+	id := string(value.runes)
+	var score float64
+	switch {
+	case id == query:
+		score = exactScore
+	case strings.HasPrefix(id, query):
+		score = prefixScore
+	case len(query) >= 3 && strings.Contains(id, query):
+		score = containsScore
+	default:
+		return 0, ""
+	}
+	return score, value.highlight(value.find([]rune(query)))
+}
+
+// matchWords averages how well each query word matches its best word of
+// value, so "ana lopez" ranks "Ana López" above "Ana Torres"
+func matchWords(query []string, value folded) (float64, string) {
+	if len(query) == 0 {
+		return 0, ""
+	}
+	valueWords := words(value.runes)
+
+	var sum float64
+	var spans []span
+	for _, q := range query {
+		best, closest := 0.0, ""
+		for _, w := range valueWords {
+			var score float64
+			switch {
+			case w == q:
+				score = exactScore
+			case strings.HasPrefix(w, q):
+				score = prefixScore
+			case utf8.RuneCountInString(q) >= 3 && strings.Contains(w, q):
+				score = containsScore
+			default:
+				if sim := max(similarity(q, w), dice(q, w)); sim >= minSimilarity {
+					score = fuzzyScore * sim
+				}
+			}
+			if score > best {
+				best, closest = score, w
+			}
+		}
+		// A typo is highlighted as the whole word it was taken for
+		if best >= containsScore {
+			spans = append(spans, value.find([]rune(q))...)
+		} else if best > 0 {
+			spans = append(spans, value.find([]rune(closest))...)
+		}
+		sum += best
+	}
+
+	if sum == 0 {
+		return 0, ""
+	}
+	marked := ""
+	if len(spans) > 0 {
+		marked = value.highlight(spans)
+	}
+	return sum / float64(len(query)), marked
+}
+
+// rank scores documents, drops those that don't match and returns the best
+// limit hits, decoding items with decode
+func (ix Index) rank(q Query, documents []bson.Raw, bonus []float64, limit int, decode func(i int) (any, error)) ([]Hit, error) {
+	type scored struct {
+		index      int
+		score      float64
+		highlights map[string]string
+	}
+
+	var matches []scored
+	for i, document := range documents {
+		score, highlights := ix.score(q, document)
+		if bonus != nil {
+			score += bonus[i]
+		}
+		if score > 0 {
+			matches = append(matches, scored{i, score, highlights})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b scored) int {
+		return -cmp.Compare(a.score, b.score)
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	hits := make([]Hit, 0, len(matches))
+	for _, m := range matches {
+		item, err := decode(m.index)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, Hit{Type: ix.Type, Score: m.score, Highlights: m.highlights, Item: item})
+	}
+	return hits, nil
+}
+
+// Rank searches items in memory, for the in-memory repositories
+func Rank[T any](ix Index, q Query, items []T, limit int) ([]Hit, error) {
+	documents := make([]bson.Raw, len(items))
+	for i, item := range items {
+		raw, err := bson.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		documents[i] = raw
+	}
+	return ix.rank(q, documents, nil, limit, func(i int) (any, error) {
+		return items[i], nil
+	})
+}
+
+// Merge combines hits from several collections into the best limit overall
+func Merge(limit int, lists ...[]Hit) []Hit {
+	hits := []Hit{}
+	for _, list := range lists {
+		hits = append(hits, list...)
+	}
+	slices.SortStableFunc(hits, func(a, b Hit) int {
+		return -cmp.Compare(a.Score, b.Score)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// Result limits of search endpoints
+const (
+	DefaultLimit = 20
+	MaxLimit     = 50
+)
+
+// Parse reads the q and limit parameters of a search request
+func Parse(r *http.Request) (Query, int, error) {
+	var problems []response.FieldError
+	values := r.URL.Query()
+
+	q, err := ParseQuery(values.Get("q"))
+	if err != nil {
+		problems = append(problems, response.FieldError{Field: "q", Code: codeInvalidValue, Message: err.Error()})
+	}
+
+	limit := DefaultLimit
+	if raw := values.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > MaxLimit {
+			problems = append(problems, response.FieldError{Field: "limit", Code: codeInvalidValue, Message: fmt.Sprintf("must be a number between 1 and %d", MaxLimit)})
+		} else {
+			limit = n
+		}
+	}
+
+	if len(problems) > 0 {
+		return Query{}, 0, response.New(http.StatusBadRequest, response.CodeValidationFailed, "Invalid search parameters").WithFields(problems...)
+	}
+	return q, limit, nil
+}
+
+const codeInvalidValue response.Code = "invalid_value"
@@ -0,0 +1,176 @@
+package search
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// folded is a normalized form of a string that remembers where each of its
+// runes came from, so matches can be highlighted in the original
+type folded struct {
+	runes []rune
+	// source[i] is the index in original of runes[i]
+	source   []int
+	original []rune
+}
+
+// fold lowercases s, strips diacritics and keeps the runes keep accepts
+func fold(s string, keep func(rune) bool) folded {
+	f := folded{original: []rune(s)}
+	for i, r := range f.original {
+		for _, d := range norm.NFD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+			d = unicode.ToLower(d)
+			if keep(d) {
+				f.runes = append(f.runes, d)
+				f.source = append(f.source, i)
+			}
+		}
+	}
+	return f
+}
+
+func isAlnum(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// foldText keeps every rune, so words stay apart
+func foldText(s string) folded {
+	return fold(s, func(rune) bool { return true })
+}
+
+// Fold returns s lowercased, without diacritics and split into words, the
+// form names are compared in
+func Fold(s string) string {
+	return strings.Join(words(foldText(s).runes), " ")
+}
+
+// NormalizePhone keeps the digits of a phone number and drops the 00
+// international prefix, so "+52 (55) 1234-5678" and "0052 55 1234 5678"
+// both become "525512345678"
+func NormalizePhone(s string) string {
+	digits := string(fold(s, isDigit).runes)
+	return strings.TrimPrefix(digits, "00")
+}
+
+// compact keeps letters and digits only, the form identifiers such as SKUs
+// are compared in: "AB-12 3" becomes "ab123"
+func compact(s string) folded {
+	return fold(s, isAlnum)
+}
+
+func words(runes []rune) []string {
+	return strings.FieldsFunc(string(runes), func(r rune) bool { return !isAlnum(r) })
+}
+
+// trigrams returns the trigrams of word padded like PostgreSQL's pg_trgm:
+// two spaces before and one after, so prefixes and short words still match
+func trigrams(word string) []string {
+	padded := []rune("  " + word + " ")
+	grams := make([]string, 0, len(padded)-2)
+	for i := 0; i+3 <= len(padded); i++ {
+		grams = append(grams, string(padded[i:i+3]))
+	}
+	return grams
+}
+
+// dice is the Sørensen–Dice similarity of the trigram sets of a and b
+func dice(a, b string) float64 {
+	ga, gb := trigrams(a), trigrams(b)
+	shared := 0
+	for _, g := range ga {
+		if slices.Contains(gb, g) {
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(ga)+len(gb))
+}
+
+// similarity is 1 for equal words, falling with each edit; adjacent
+// transpositions count as one edit, so "jhon" is close to "john"
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// editDistance is the optimal string alignment distance between a and b
+func editDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// span is a half-open range of runes in the original string
+type span struct{ start, end int }
+
+// find returns where needle occurs in f, in original rune positions
+func (f folded) find(needle []rune) []span {
+	var spans []span
+	if len(needle) == 0 {
+		return nil
+	}
+	for i := 0; i+len(needle) <= len(f.runes); i++ {
+		if slices.Equal(f.runes[i:i+len(needle)], needle) {
+			spans = append(spans, span{f.source[i], f.source[i+len(needle)-1] + 1})
+		}
+	}
+	return spans
+}
+
+// highlight escapes the original string for HTML and wraps spans in <mark>.
+// Overlapping spans share one mark.
+func (f folded) highlight(spans []span) string {
+	slices.SortFunc(spans, func(a, b span) int { return a.start - b.start })
+	var merged []span
+	for _, s := range spans {
+		if last := len(merged) - 1; last >= 0 && s.start < merged[last].end {
+			merged[last].end = max(merged[last].end, s.end)
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	var b strings.Builder
+	position := 0
+	for _, s := range merged {
+		b.WriteString(html.EscapeString(string(f.original[position:s.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(f.original[s.start:s.end])))
+		b.WriteString("</mark>")
+		position = s.end
+	}
+	b.WriteString(html.EscapeString(string(f.original[position:])))
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"john", "john", 0},
+		{"", "ana", 3},
+		{"john", "jhon", 1},
+		{"maria", "amria", 1},
+		{"kitten", "sitting", 3},
+		// Optimal string alignment edits each substring once, so a
+		// transposition cannot be edited again
+		{"ca", "abc", 3},
+		{"josé", "jose", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := editDistance([]rune(tt.b), []rune(tt.a)); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestTrigrams(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"a", []string{"  a", " a "}},
+		{"jose", []string{"  j", " jo", "jos", "ose", "se "}},
+		{Fold("José"), []string{"  j", " jo", "jos", "ose", "se "}},
+		{Fold("Ñuño"), []string{"  n", " nu", "nun", "uno", "no "}},
+		// Letters that do not decompose stay one rune each
+		{Fold("Øst"), []string{"  ø", " øs", "øst", "st "}},
+	}
+	for _, tt := range tests {
+		if got := trigrams(tt.word); !slices.Equal(got, tt.want) {
+			t.Errorf("trigrams(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+
+	if got := dice(Fold("García"), "garcia"); got != 1 {
+		t.Errorf("dice of García and garcia = %v, want 1", got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		needle []string
+		want   string
+	}{
+		{"plain", "Maria Lopez", []string{"lopez"}, "Maria <mark>Lopez</mark>"},
+		{"diacritics in the value", "José Núñez", []string{"nunez"}, "José <mark>Núñez</mark>"},
+		{"every occurrence", "ana banana", []string{"ana"}, "<mark>ana</mark> b<mark>anana</mark>"},
+		{"overlapping spans", "abcdef", []string{"abc", "cde"}, "<mark>abcde</mark>f"},
+		{"span inside another", "abcdef", []string{"bcde", "cd"}, "a<mark>bcde</mark>f"},
+		{"markup around a match", "<script>alert(1)</script>", []string{"alert"}, "&lt;script&gt;<mark>alert</mark>(1)&lt;/script&gt;"},
+		{"markup inside a match", "a<b>c", []string{"a<b>c"}, "<mark>a&lt;b&gt;c</mark>"},
+		{"quotes", `"Tom's"`, []string{"tom"}, "&#34;<mark>Tom</mark>&#39;s&#34;"},
+		{"no match", "<i>", []string{"x"}, "&lt;i&gt;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := foldText(tt.value)
+			var spans []span
+			for _, needle := range tt.needle {
+				spans = append(spans, value.find([]rune(needle))...)
+			}
+			if got := value.highlight(spans); got != tt.want {
+				t.Errorf("highlight = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"+52 (55) 1234-5678", "525512345678"},
+		{"0052 55 1234 5678", "525512345678"},
+		{"+52 55 1234 5678", "525512345678"},
+		{"52.55.1234.5678", "525512345678"},
+		{"(55) 1234-5678", "5512345678"},
+		// Only the international prefix is dropped
+		{"5500 1234", "55001234"},
+		{"", ""},
+		{"ext.", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizePhone(tt.phone); got != tt.want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"isy-api/auth"
+	"isy-api/observability"
+	"isy-api/response"
+)
+
+// HeaderName selects a tenant explicitly, e.g. for kiosk terminals that all
+// talk to the same host
+const HeaderName = "X-Tenant"
+
+// Error codes for requests that cannot be bound to a tenant
+const (
+	CodeMismatch response.Code = "tenant_mismatch"
+	CodeNotFound response.Code = "tenant_not_found"
+	CodeRequired response.Code = "tenant_required"
+)
+
+type contextKey struct{}
+
+type binding struct {
+	tenant *Tenant
+	db     *mongo.Database
+}
+
+// WithTenant returns a copy of ctx bound to t and its database
+func WithTenant(ctx context.Context, t *Tenant, db *mongo.Database) context.Context {
+	return context.WithValue(ctx, contextKey{}, binding{tenant: t, db: db})
+}
+
+// FromContext returns the tenant resolved by Middleware
+func FromContext(ctx context.Context) (*Tenant, bool) {
+	b, ok := ctx.Value(contextKey{}).(binding)
+	return b.tenant, ok
+}
+
+// ID returns the ID of the tenant bound to ctx, or "" for requests that use
+// the shared database
+func ID(ctx context.Context) string {
+	if t, ok := FromContext(ctx); ok && t != nil {
+		return t.ID
+	}
+	return ""
+}
+
+// Database returns the database of the tenant bound to ctx, or fallback for
+// requests that aren't bound to a tenant
+func Database(ctx context.Context, fallback *mongo.Database) *mongo.Database {
+	if b, ok := ctx.Value(contextKey{}).(binding); ok {
+		return b.db
+	}
+	return fallback
+}
+
+// Middleware binds the request to a tenant. A tenant in the caller's token
+// wins; otherwise the X-Tenant header, then the Host header decide. Requests
+// that resolve to no tenant use the shared database unless Required is set.
+//
+// Attach it after auth.Middleware on protected routes so the token claim is
+// taken into account.
+func (reg *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reg.DB == nil {
+			// Handlers answer 503 themselves
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := reg.resolve(r)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrMismatch):
+				response.WriteError(w, r, response.New(http.StatusForbidden, CodeMismatch, "Credentials are not valid for this tenant"))
+			case errors.Is(err, ErrNotFound):
+				response.WriteError(w, r, response.New(http.StatusNotFound, CodeNotFound, "Unknown tenant"))
+			default:
+				log.Printf("Tenant lookup failed: %v", err)
+				response.Fail(w, r, http.StatusServiceUnavailable, "Tenant registry not available")
+			}
+			return
+		}
+
+		if t == nil {
+			if reg.Required {
+				response.WriteError(w, r, response.New(http.StatusBadRequest, CodeRequired, "Tenant could not be determined"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		observability.SetTenant(r.Context(), t.ID)
+		ctx := WithTenant(r.Context(), t, reg.Database(t))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (reg *Registry) resolve(r *http.Request) (*Tenant, error) {
+	ctx := r.Context()
+
+	var claimed string
+	if claims, ok := auth.ClaimsFromContext(ctx); ok {
+		claimed = claims.Tenant
+	}
+	requested := strings.ToLower(strings.TrimSpace(r.Header.Get(HeaderName)))
+
+	hostTenant, err := reg.LookupHost(ctx, r.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if claimed != "" {
+		if requested != "" && requested != claimed {
+			return nil, ErrMismatch
+		}
+		if hostTenant != nil && hostTenant.ID != claimed {
+			return nil, ErrMismatch
+		}
+		return reg.Lookup(ctx, claimed)
+	}
+	if requested != "" {
+		return reg.Lookup(ctx, requested)
+	}
+	return hostTenant, nil
+}
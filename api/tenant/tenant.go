@@ -0,0 +1,258 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"isy-api/config"
+)
+
+var (
+	// ErrNotFound is returned for unknown or inactive tenants
+	ErrNotFound = errors.New("tenant not found")
+	// ErrMismatch is returned when the tenant bound to the caller's token
+	// differs from the one requested by header or host
+	ErrMismatch = errors.New("tenant does not match credentials")
+	// ErrInvalidTenant is wrapped by Provision validation errors
+	ErrInvalidTenant = errors.New("invalid tenant")
+	// ErrUnavailable is returned when the database is not connected
+	ErrUnavailable = errors.New("database not available")
+)
+
+// DefaultCacheTTL is how long the registry serves tenants from memory before
+// reloading the tenants collection
+const DefaultCacheTTL = 30 * time.Second
+
+// Collections are created in every newly provisioned tenant database
+var Collections = []string{
+	"products", "customers", "orders", "categories", "subcategories",
+	"patients", "appointments", "medicalrecords",
+	"contents", "metadata",
+}
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,39}$`)
+
+// Tenant represents a shop or clinic in the tenants collection. Each tenant
+// keeps its business data in its own database; admins, sessions and audit
+// logs stay in the shared database.
+type Tenant struct {
+	ID       string `bson:"_id" json:"id"`
+	Name     string `bson:"name" json:"name"`
+	Database string `bson:"database" json:"database"`
+	// Hosts is omitted when empty so the sparse unique index allows any
+	// number of tenants that are only reached by header or token
+	Hosts     []string  `bson:"hosts,omitempty" json:"hosts"`
+	IsActive  bool      `bson:"isActive" json:"isActive"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// NewTenant describes a tenant to provision
+type NewTenant struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Database string   `json:"database"`
+	Hosts    []string `json:"hosts"`
+}
+
+// Registry looks up tenants and hands out their databases. Tenants are few
+// and change rarely, so the whole collection is cached in memory.
+type Registry struct {
+	Client *mongo.Client
+	DB     *mongo.Database
+	// Required rejects requests that don't resolve to a tenant instead of
+	// serving them from DB
+	Required bool
+	CacheTTL time.Duration
+	// Prepare, if set, finishes a provisioned database once its collections
+	// exist, e.g. by applying migrations
+	Prepare func(ctx context.Context, db *mongo.Database) error
+
+	mu       sync.RWMutex
+	byID     map[string]*Tenant
+	byHost   map[string]*Tenant
+	loadedAt time.Time
+}
+
+// NewRegistry creates a registry backed by the tenants collection in db
+func NewRegistry(client *mongo.Client, db *mongo.Database, cfg config.Tenants) *Registry {
+	return &Registry{Client: client, DB: db, Required: cfg.Required, CacheTTL: DefaultCacheTTL}
+}
+
+// Lookup returns an active tenant by ID
+func (reg *Registry) Lookup(ctx context.Context, id string) (*Tenant, error) {
+	if err := reg.load(ctx); err != nil {
+		return nil, err
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	if t, ok := reg.byID[id]; ok {
+		return t, nil
+	}
+	return nil, ErrNotFound
+}
+
+// LookupHost returns the active tenant serving host, or nil if none does
+func (reg *Registry) LookupHost(ctx context.Context, host string) (*Tenant, error) {
+	if err := reg.load(ctx); err != nil {
+		return nil, err
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.byHost[normalizeHost(host)], nil
+}
+
+// Database returns the database holding t's data
+func (reg *Registry) Database(t *Tenant) *mongo.Database {
+	return reg.Client.Database(t.Database)
+}
+
+// List returns every tenant, including inactive ones
+func (reg *Registry) List(ctx context.Context) ([]Tenant, error) {
+	if reg.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	cursor, err := reg.DB.Collection("tenants").Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tenants := []Tenant{}
+	if err := cursor.All(ctx, &tenants); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// Provision registers a tenant and creates its database with the standard
+// collections. The database name defaults to "isy_<id>".
+func (reg *Registry) Provision(ctx context.Context, req NewTenant) (*Tenant, error) {
+	if reg.DB == nil {
+		return nil, ErrUnavailable
+	}
+
+	req.ID = strings.ToLower(strings.TrimSpace(req.ID))
+	req.Name = strings.TrimSpace(req.Name)
+	if !idPattern.MatchString(req.ID) {
+		return nil, fmt.Errorf("%w: id must be 2-40 lowercase letters, digits or dashes", ErrInvalidTenant)
+	}
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidTenant)
+	}
+	if req.Database == "" {
+		req.Database = "isy_" + strings.ReplaceAll(req.ID, "-", "_")
+	}
+	if req.Database == reg.DB.Name() {
+		return nil, fmt.Errorf("%w: database %q is the shared database", ErrInvalidTenant, req.Database)
+	}
+
+	hosts := []string{}
+	for _, host := range req.Hosts {
+		if host = normalizeHost(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	now := time.Now()
+	t := Tenant{
+		ID:        req.ID,
+		Name:      req.Name,
+		Database:  req.Database,
+		Hosts:     hosts,
+		IsActive:  true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := reg.DB.Collection("tenants").InsertOne(ctx, t); err != nil {
+		return nil, err
+	}
+	reg.invalidate()
+
+	db := reg.Database(&t)
+	for _, name := range Collections {
+		if err := db.CreateCollection(ctx, name); err != nil && !isNamespaceExists(err) {
+			return nil, fmt.Errorf("create collection %s: %w", name, err)
+		}
+	}
+	if reg.Prepare != nil {
+		if err := reg.Prepare(ctx, db); err != nil {
+			return nil, fmt.Errorf("prepare database: %w", err)
+		}
+	}
+
+	return &t, nil
+}
+
+// load refreshes the cache once it is older than CacheTTL
+func (reg *Registry) load(ctx context.Context) error {
+	if reg.DB == nil {
+		return ErrUnavailable
+	}
+
+	reg.mu.RLock()
+	fresh := !reg.loadedAt.IsZero() && time.Since(reg.loadedAt) < reg.CacheTTL
+	reg.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	cursor, err := reg.DB.Collection("tenants").Find(ctx, bson.M{"isActive": true})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var tenants []*Tenant
+	if err := cursor.All(ctx, &tenants); err != nil {
+		return err
+	}
+
+	byID := make(map[string]*Tenant, len(tenants))
+	byHost := map[string]*Tenant{}
+	for _, t := range tenants {
+		byID[t.ID] = t
+		for _, host := range t.Hosts {
+			byHost[normalizeHost(host)] = t
+		}
+	}
+
+	reg.mu.Lock()
+	reg.byID, reg.byHost, reg.loadedAt = byID, byHost, time.Now()
+	reg.mu.Unlock()
+	return nil
+}
+
+func (reg *Registry) invalidate() {
+	reg.mu.Lock()
+	reg.loadedAt = time.Time{}
+	reg.mu.Unlock()
+}
+
+// normalizeHost lowercases host and strips any port
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+func isNamespaceExists(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 48
+}
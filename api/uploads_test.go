@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"isy-api/apitest"
+	"isy-api/config"
+)
+
+// upload posts a PNG for tenantID and returns the URL it is served at
+func upload(t *testing.T, a *App, tenantID string) string {
+	t.Helper()
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="logo.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("png of " + tenantID))
+	writer.Close()
+
+	r := apitest.ForTenant(httptest.NewRequest("POST", "/api/v1/upload", &form), tenantID)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	status, body := apitest.Serve(t, a.uploadFile, r)
+	var uploaded struct {
+		URL string `json:"url"`
+	}
+	body.Decode(t, &uploaded)
+	if status != http.StatusOK || uploaded.URL == "" {
+		t.Fatalf("uploading for %q = %d %s", tenantID, status, body.Code)
+	}
+	return uploaded.URL
+}
+
+func TestUploadsPerTenant(t *testing.T) {
+	dir := t.TempDir()
+	a := &App{Config: &config.Config{Uploads: config.Uploads{Dir: dir, MaxFileSize: 1 << 20}}}
+	north := upload(t, a, "north")
+	shared := upload(t, a, "")
+
+	serve := func(url, tenantID string) (int, string) {
+		w := httptest.NewRecorder()
+		a.serveUpload(w, apitest.ForTenant(httptest.NewRequest("GET", url, nil), tenantID))
+		return w.Code, w.Body.String()
+	}
+	tests := []struct {
+		name     string
+		url      string
+		tenantID string
+		want     int
+	}{
+		{"own file", north, "north", http.StatusOK},
+		{"another tenant's file", north, "south", http.StatusNotFound},
+		{"a tenant's file from the shared folder", north, "", http.StatusNotFound},
+		{"shared file", shared, "", http.StatusOK},
+		{"shared file from a tenant", shared, "north", http.StatusNotFound},
+		{"tenant folders", "/uploads/tenants", "", http.StatusNotFound},
+		{"nested path", "/uploads/tenants/north/" + strings.TrimPrefix(north, "/uploads/"), "", http.StatusNotFound},
+		{"parent folder", "/uploads/..", "north", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := serve(tt.url, tt.tenantID); status != tt.want {
+				t.Errorf("GET %s as %q = %d, want %d", tt.url, tt.tenantID, status, tt.want)
+			}
+		})
+	}
+	if _, content := serve(north, "north"); content != "png of north" {
+		t.Errorf("north's file holds %q", content)
+	}
+
+	remove := func(name, tenantID string) int {
+		r := apitest.WithVars(apitest.ForTenant(apitest.Request("DELETE", "/api/v1/upload/"+name, ""), tenantID), map[string]string{"filename": name})
+		status, _ := apitest.Serve(t, a.deleteFile, r)
+		return status
+	}
+	name := strings.TrimPrefix(north, "/uploads/")
+	for _, tt := range []struct {
+		name, tenantID string
+		want           int
+	}{
+		{name, "south", http.StatusNotFound},
+		{name, "", http.StatusNotFound},
+		{"..", "north", http.StatusBadRequest},
+		{"tenants", "", http.StatusNotFound},
+		{name, "north", http.StatusOK},
+	} {
+		if status := remove(tt.name, tt.tenantID); status != tt.want {
+			t.Errorf("DELETE %s as %q = %d, want %d", tt.name, tt.tenantID, status, tt.want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tenants")); err != nil {
+		t.Errorf("tenant folders after the refused deletes: %v", err)
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	a := &App{Config: &config.Config{Uploads: config.Uploads{Dir: t.TempDir(), MaxFileSize: 1 << 10}}}
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="logo.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte("png"), 1<<10))
+	writer.Close()
+
+	r := httptest.NewRequest("POST", "/api/v1/upload", &form)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	if status, body := apitest.Serve(t, a.uploadFile, r); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("uploading 3 KiB with a 1 KiB limit = %d %s, want 413", status, body.Code)
+	}
+}
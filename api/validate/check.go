@@ -0,0 +1,184 @@
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"isy-api/response"
+)
+
+// Codes of the field errors
+const (
+	CodeRequired      response.Code = "required"
+	CodeUnknownField  response.Code = "unknown_field"
+	CodeInvalidKey    response.Code = "invalid_key"
+	CodeInvalidType   response.Code = "invalid_type"
+	CodeInvalidValue  response.Code = "invalid_value"
+	CodeInvalidFormat response.Code = "invalid_format"
+	CodeTooShort      response.Code = "too_short"
+	CodeTooLong       response.Code = "too_long"
+	CodeOutOfRange    response.Code = "out_of_range"
+)
+
+// checker collects the problems of one body
+type checker struct {
+	problems []response.FieldError
+}
+
+func (c *checker) fail(path string, code response.Code, format string, args ...any) {
+	c.problems = append(c.problems, response.FieldError{Field: path, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// object checks the properties of m and returns them converted. Required
+// fields are only enforced when required is set, which partial updates
+// clear for the top-level object.
+func (c *checker) object(path string, m map[string]any, fields []Field, open, required bool) map[string]any {
+	converted := make(map[string]any, len(m))
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		at := join(path, key)
+		if !c.key(at, key) {
+			continue
+		}
+		i := slices.IndexFunc(fields, func(f Field) bool { return f.Name == key })
+		switch {
+		case i >= 0:
+			converted[key] = c.value(at, m[key], fields[i])
+		case open && !(path == "" && key == "_id"):
+			converted[key] = c.any(at, m[key])
+		default:
+			c.fail(at, CodeUnknownField, "is not a known field")
+		}
+	}
+
+	if required {
+		for _, f := range fields {
+			if v, ok := m[f.Name]; f.Required && (!ok || v == nil) {
+				c.fail(join(path, f.Name), CodeRequired, "is required")
+			}
+		}
+	}
+	return converted
+}
+
+// key reports whether key may be stored. "$" starts an operator and "."
+// separates path segments, so neither may appear in a field name.
+func (c *checker) key(path, key string) bool {
+	switch {
+	case key == "":
+		c.fail(path, CodeInvalidKey, "field names cannot be empty")
+	case strings.HasPrefix(key, "$"):
+		c.fail(path, CodeInvalidKey, "field names cannot start with $")
+	case strings.Contains(key, "."):
+		c.fail(path, CodeInvalidKey, "field names cannot contain dots")
+	default:
+		return true
+	}
+	return false
+}
+
+func (c *checker) value(path string, raw any, f Field) any {
+	if raw == nil {
+		// Missing required fields are reported by object
+		return nil
+	}
+	if f.Type == Any {
+		return c.any(path, raw)
+	}
+	v, ok := f.convert(raw)
+	if !ok {
+		c.fail(path, CodeInvalidType, "must be %s", typeNames[f.Type])
+		return nil
+	}
+
+	switch v := v.(type) {
+	case string:
+		c.text(path, v, f)
+	case float64:
+		c.bounds(path, v, f)
+	case int64:
+		c.bounds(path, float64(v), f)
+	case []any:
+		c.length(path, len(v), "items", f)
+		for i, item := range v {
+			at := path + "[" + strconv.Itoa(i) + "]"
+			if f.Items != nil {
+				v[i] = c.value(at, item, *f.Items)
+			} else {
+				v[i] = c.any(at, item)
+			}
+		}
+	case map[string]any:
+		return c.object(path, v, f.Fields, f.Open, true)
+	}
+	return v
+}
+
+func (c *checker) text(path, s string, f Field) {
+	if len(f.Enum) > 0 {
+		if !slices.Contains(f.Enum, s) {
+			c.fail(path, CodeInvalidValue, "must be one of %s", strings.Join(f.Enum, ", "))
+		}
+		return
+	}
+	if !c.length(path, utf8.RuneCountInString(s), "characters", f) {
+		return
+	}
+	if problem := f.checkFormat(s); problem != "" {
+		c.fail(path, CodeInvalidFormat, "%s", problem)
+	}
+}
+
+func (c *checker) length(path string, n int, unit string, f Field) bool {
+	switch {
+	case f.MinLength > 0 && n < f.MinLength:
+		c.fail(path, CodeTooShort, "must have at least %d %s", f.MinLength, unit)
+	case f.MaxLength > 0 && n > f.MaxLength:
+		c.fail(path, CodeTooLong, "must have at most %d %s", f.MaxLength, unit)
+	default:
+		return true
+	}
+	return false
+}
+
+func (c *checker) bounds(path string, n float64, f Field) {
+	if (f.Min != nil && n < *f.Min) || (f.Max != nil && n > *f.Max) {
+		c.fail(path, CodeOutOfRange, "must be %s", describeBounds(f.Min, f.Max))
+	}
+}
+
+// any checks the keys of a free-form value and converts its numbers the way
+// encoding/json does by default
+func (c *checker) any(path string, raw any) any {
+	switch v := raw.(type) {
+	case json.Number:
+		n, _ := v.Float64()
+		return n
+	case []any:
+		for i, item := range v {
+			v[i] = c.any(path+"["+strconv.Itoa(i)+"]", item)
+		}
+		return v
+	case map[string]any:
+		converted := make(map[string]any, len(v))
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			at := join(path, key)
+			if c.key(at, key) {
+				converted[key] = c.any(at, v[key])
+			}
+		}
+		return converted
+	}
+	return raw
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
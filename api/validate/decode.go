@@ -0,0 +1,71 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"isy-api/response"
+)
+
+// Decode reads the body of r, checks it against schema and returns the
+// document to store, with times, IDs and numbers converted to their BSON
+// types
+func Decode(r *http.Request, schema Schema) (bson.M, error) {
+	return read(r, schema, true)
+}
+
+// DecodePartial is Decode for updates that only change the fields present:
+// required fields may be left out, but not set to null
+func DecodePartial(r *http.Request, schema Schema) (bson.M, error) {
+	return read(r, schema, false)
+}
+
+// DecodeInto checks the body of r against schema and decodes it into dst, a
+// pointer to the typed model. The model receives the converted values, so
+// a date is a valid time.Time.
+func DecodeInto(r *http.Request, schema Schema, dst any) error {
+	document, err := Decode(r, schema)
+	if err != nil {
+		return err
+	}
+	converted, err := json.Marshal(document)
+	if err == nil {
+		err = json.Unmarshal(converted, dst)
+	}
+	if err != nil {
+		return response.ErrInvalidBody
+	}
+	return nil
+}
+
+func read(r *http.Request, schema Schema, required bool) (bson.M, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, response.BodyError(err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil || object == nil || decoder.More() {
+		return nil, response.ErrInvalidBody
+	}
+
+	var c checker
+	document := c.object("", object, schema.Fields, schema.Open, required)
+	if !required {
+		for _, f := range schema.Fields {
+			if v, ok := object[f.Name]; ok && v == nil && f.Required {
+				c.fail(f.Name, CodeRequired, "cannot be removed")
+			}
+		}
+	}
+	if len(c.problems) > 0 {
+		return nil, response.New(http.StatusBadRequest, response.CodeValidationFailed, "Invalid request body").WithFields(c.problems...)
+	}
+	return bson.M(document), nil
+}
@@ -0,0 +1,159 @@
+package validate
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"isy-api/response"
+)
+
+var productSchema = Schema{Fields: []Field{
+	{Name: "name", Type: String, Required: true, MaxLength: 20},
+	{Name: "price", Type: Number, Min: Limit(0)},
+	{Name: "stock", Type: Integer},
+	{Name: "note", Type: String},
+	{Name: "tags", Type: Array, Items: &Field{Type: String}},
+	{Name: "address", Type: Object, Fields: []Field{{Name: "city", Type: String, Required: true}}},
+	{Name: "extra", Type: Any},
+	{Name: "meta", Type: Object, Open: true},
+}}
+
+// pageSchema is a free-form document edited by a front-end
+var pageSchema = Schema{Open: true, Fields: []Field{{Name: "title", Type: String}}}
+
+// decode runs Decode, or DecodePartial when partial is set, and returns the
+// document and the code of each field problem
+func decode(t *testing.T, schema Schema, body string, partial bool) (bson.M, map[string]response.Code) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	var document bson.M
+	var err error
+	if partial {
+		document, err = DecodePartial(r, schema)
+	} else {
+		document, err = Decode(r, schema)
+	}
+	if err == nil {
+		return document, nil
+	}
+	var problem *response.Error
+	if !errors.As(err, &problem) || problem.Code != response.CodeValidationFailed {
+		t.Fatalf("decoding %s: %v, want a validation error", body, err)
+	}
+	problems := map[string]response.Code{}
+	for _, f := range problem.Fields {
+		problems[f.Field] = f.Code
+	}
+	return nil, problems
+}
+
+func TestDecodeKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema Schema
+		body   string
+		want   map[string]response.Code
+	}{
+		{"valid", productSchema, `{"name":"latte","price":2.5,"tags":["hot"]}`, nil},
+		{"operator", productSchema, `{"name":"latte","$set":{"price":0}}`, map[string]response.Code{"$set": CodeInvalidKey}},
+		{"operator in a free-form value", productSchema, `{"name":"latte","extra":{"price":{"$gt":0}}}`, map[string]response.Code{"extra.price.$gt": CodeInvalidKey}},
+		{"operator in an array", productSchema, `{"name":"latte","extra":[{"$where":"1"}]}`, map[string]response.Code{"extra[0].$where": CodeInvalidKey}},
+		{"operator in an open object", productSchema, `{"name":"latte","meta":{"$inc":{"stock":1}}}`, map[string]response.Code{"meta.$inc": CodeInvalidKey}},
+		{"dotted key", productSchema, `{"name":"latte","address.city":"Oaxaca"}`, map[string]response.Code{"address.city": CodeInvalidKey}},
+		{"dotted key in an open object", productSchema, `{"name":"latte","meta":{"a.b":1}}`, map[string]response.Code{"meta.a.b": CodeInvalidKey}},
+		{"empty key", productSchema, `{"name":"latte","":1}`, map[string]response.Code{"": CodeInvalidKey}},
+		{"unknown field", productSchema, `{"name":"latte","colour":"red"}`, map[string]response.Code{"colour": CodeUnknownField}},
+		{"unknown nested field", productSchema, `{"name":"latte","address":{"city":"Oaxaca","zip":"68000"}}`, map[string]response.Code{"address.zip": CodeUnknownField}},
+		{"_id", productSchema, `{"name":"latte","_id":"64b7f0c2a1b2c3d4e5f60718"}`, map[string]response.Code{"_id": CodeUnknownField}},
+		{"open schema", pageSchema, `{"title":"Home","hero":{"image":"/uploads/a.png"}}`, nil},
+		{"_id on an open schema", pageSchema, `{"title":"Home","_id":"home"}`, map[string]response.Code{"_id": CodeUnknownField}},
+		{"nested _id on an open schema", pageSchema, `{"sections":[{"_id":"intro"}]}`, nil},
+		{"operator on an open schema", pageSchema, `{"$unset":{"title":""}}`, map[string]response.Code{"$unset": CodeInvalidKey}},
+		{"every problem at once", productSchema, `{"$set":1,"a.b":2,"colour":"red","name":5}`, map[string]response.Code{
+			"$set": CodeInvalidKey, "a.b": CodeInvalidKey, "colour": CodeUnknownField, "name": CodeInvalidType,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, problems := decode(t, tt.schema, tt.body, false); !maps.Equal(problems, tt.want) {
+				t.Errorf("problems = %v, want %v", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePartial(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		partial bool
+		want    map[string]response.Code
+		// stored is what the document holds for the body's field, when valid
+		stored map[string]any
+	}{
+		{"required field left out", `{"price":3}`, true, nil, map[string]any{"price": 3.0}},
+		{"required field left out of a create", `{"price":3}`, false, map[string]response.Code{"name": CodeRequired}, nil},
+		{"nothing to change", `{}`, true, nil, map[string]any{}},
+		{"required field set to null", `{"name":null}`, true, map[string]response.Code{"name": CodeRequired}, nil},
+		{"required field set to null on create", `{"name":null}`, false, map[string]response.Code{"name": CodeRequired}, nil},
+		{"optional field set to null", `{"note":null}`, true, nil, map[string]any{"note": nil}},
+		{"object set to null", `{"address":null}`, true, nil, map[string]any{"address": nil}},
+		{"object replaced without its required fields", `{"address":{}}`, true, map[string]response.Code{"address.city": CodeRequired}, nil},
+		{"integer", `{"stock":4}`, true, nil, map[string]any{"stock": int64(4)}},
+		{"fraction for an integer", `{"stock":4.5}`, true, map[string]response.Code{"stock": CodeInvalidType}, nil},
+		{"out of range", `{"price":-1}`, true, map[string]response.Code{"price": CodeOutOfRange}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, problems := decode(t, productSchema, tt.body, tt.partial)
+			if !maps.Equal(problems, tt.want) {
+				t.Fatalf("problems = %v, want %v", problems, tt.want)
+			}
+			if tt.want == nil && !maps.Equal(map[string]any(document), tt.stored) {
+				t.Errorf("document = %#v, want %#v", document, tt.stored)
+			}
+		})
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		limit int64
+		want  *response.Error
+	}{
+		{"empty", "", 0, response.ErrInvalidBody},
+		{"null", "null", 0, response.ErrInvalidBody},
+		{"array", `[{"name":"latte"}]`, 0, response.ErrInvalidBody},
+		{"two objects", `{"name":"latte"} {"name":"mocha"}`, 0, response.ErrInvalidBody},
+		{"malformed", `{"name":`, 0, response.ErrInvalidBody},
+		{"over the limit", `{"name":"` + strings.Repeat("a", 100) + `"}`, 64, response.ErrPayloadTooLarge},
+		{"within the limit", `{"name":"latte"}`, 64, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.limit > 0 {
+				r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, tt.limit)
+			}
+			_, err := Decode(r, productSchema)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Decode = %v", err)
+				}
+				return
+			}
+			var problem *response.Error
+			if !errors.As(err, &problem) || problem.Status != tt.want.Status || problem.Code != tt.want.Code {
+				t.Errorf("Decode = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
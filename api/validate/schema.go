@@ -0,0 +1,205 @@
+// Package validate checks JSON request bodies against declarative schemas
+// before they reach MongoDB.
+//
+// Each create or update payload has a Schema listing its fields with their
+// type, whether they are required, and their bounds, enum or format. Fields
+// a schema does not list are refused, and so are keys starting with "$" or
+// containing "." anywhere in the body, so a client can neither set _id nor
+// smuggle query operators into a document. Every problem is reported at
+// once as a validation error with one entry per field.
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"isy-api/search"
+)
+
+// Type is the JSON type of a field and the BSON type it is stored as
+type Type int
+
+const (
+	String Type = iota
+	// Number is stored as a double
+	Number
+	// Integer is a whole number, stored as a 64-bit integer
+	Integer
+	Bool
+	// Time is an RFC 3339 time or a date, stored as a BSON date
+	Time
+	// ObjectID is a hex ID, stored as an ObjectId
+	ObjectID
+	Object
+	Array
+	// Any accepts every value; nested keys are still checked
+	Any
+)
+
+var typeNames = map[Type]string{
+	String: "a string", Number: "a number", Integer: "an integer", Bool: "true or false",
+	Time: "an RFC 3339 time or a date", ObjectID: "an ID", Object: "an object", Array: "an array",
+}
+
+// Format constrains the content of a string
+type Format int
+
+const (
+	NoFormat Format = iota
+	Email
+	// Phone accepts digits with the usual separators and an optional
+	// leading +
+	Phone
+	// URL accepts http and https URLs and paths on this server
+	URL
+	// Clock is a time of day, HH:MM
+	Clock
+)
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	phonePattern = regexp.MustCompile(`^\+?[0-9 ().-]+$`)
+	clockPattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+)
+
+// Field describes one property of a JSON object
+type Field struct {
+	Name string
+	Type Type
+	// Required fields must be present and not null. Optional fields
+	// accept null, which is stored as is.
+	Required bool
+	// MinLength and MaxLength bound strings in characters and arrays in
+	// items; zero is unbounded
+	MinLength int
+	MaxLength int
+	// Min and Max bound numbers; nil is unbounded
+	Min *float64
+	Max *float64
+	// Enum lists the accepted strings
+	Enum   []string
+	Format Format
+	// Items describes the elements of an array
+	Items *Field
+	// Fields describes the properties of an object
+	Fields []Field
+	// Open objects accept properties Fields does not list
+	Open bool
+}
+
+// Named returns a copy of f called name, for field shapes shared by several
+// fields
+func (f Field) Named(name string) Field {
+	f.Name = name
+	return f
+}
+
+// Require returns a required copy of f
+func (f Field) Require() Field {
+	f.Required = true
+	return f
+}
+
+// Limit is a bound for Field.Min and Field.Max
+func Limit(n float64) *float64 {
+	return &n
+}
+
+// Schema describes a request body, which is always a JSON object
+type Schema struct {
+	Fields []Field
+	// Open accepts properties Fields does not list, for free-form documents
+	// edited by a front-end. Their keys are still checked.
+	Open bool
+}
+
+// convert checks raw, as decoded with json.Decoder.UseNumber, against the
+// field's type and returns it as the value to store
+func (f Field) convert(raw any) (any, bool) {
+	switch f.Type {
+	case String:
+		s, ok := raw.(string)
+		return s, ok
+	case Number:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return nil, false
+		}
+		v, err := n.Float64()
+		return v, err == nil
+	case Integer:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return nil, false
+		}
+		v, err := n.Int64()
+		return v, err == nil
+	case Bool:
+		b, ok := raw.(bool)
+		return b, ok
+	case Time:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, false
+		}
+		for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+		return nil, false
+	case ObjectID:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, false
+		}
+		id, err := primitive.ObjectIDFromHex(s)
+		return id, err == nil
+	case Object:
+		m, ok := raw.(map[string]any)
+		return m, ok
+	case Array:
+		list, ok := raw.([]any)
+		return list, ok
+	}
+	return raw, true
+}
+
+// checkFormat returns what is wrong with s, or "" if it matches the format
+func (f Field) checkFormat(s string) string {
+	switch f.Format {
+	case Email:
+		if !emailPattern.MatchString(s) {
+			return "must be an email address"
+		}
+	case Phone:
+		if digits := len(search.NormalizePhone(s)); !phonePattern.MatchString(s) || digits < 6 || digits > 15 {
+			return "must be a phone number of 6 to 15 digits"
+		}
+	case URL:
+		if !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "/") {
+			return "must be an http(s) URL or a path starting with /"
+		}
+	case Clock:
+		if !clockPattern.MatchString(s) {
+			return "must be a time of day as HH:MM"
+		}
+	}
+	return ""
+}
+
+func describeBounds(min, max *float64) string {
+	switch {
+	case min != nil && max != nil:
+		return fmt.Sprintf("between %g and %g", *min, *max)
+	case min != nil:
+		return fmt.Sprintf("at least %g", *min)
+	default:
+		return fmt.Sprintf("at most %g", *max)
+	}
+}